@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// adminTokenHeader 是管理接口携带访问令牌的请求头
+const adminTokenHeader = "X-Admin-Token"
+
+// requireAdminToken 校验管理接口的访问令牌，未配置 MKB_ADMIN_TOKEN 时管理接口不可用
+func requireAdminToken(ctx context.Context, c *app.RequestContext) {
+	if cfg.AdminToken == "" {
+		c.AbortWithStatusJSON(consts.StatusForbidden, utils.H{
+			"error": "Admin API is disabled, set MKB_ADMIN_TOKEN to enable it",
+		})
+		return
+	}
+
+	token := c.GetHeader(adminTokenHeader)
+	if subtle.ConstantTimeCompare(token, []byte(cfg.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(consts.StatusUnauthorized, utils.H{
+			"error": "Invalid admin token",
+		})
+		return
+	}
+
+	c.Next(ctx)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxArchiveMembers     = 200       // 单个压缩包最多包含的文件数
+	maxArchiveTotalSize   = 500 << 20 // 解压后总大小上限 500MB
+	maxArchiveMemberSize  = maxFileSize
+	maxArchiveMemberRatio = 100 // 单个成员允许的最大压缩比，超过视为压缩炸弹
+	maxArchivePathLength  = 512
+)
+
+// 压缩包成员的入库状态
+const (
+	archiveMemberIngested = "ingested"
+	archiveMemberSkipped  = "skipped"
+	archiveMemberFailed   = "failed"
+)
+
+// 压缩包元数据字段名
+const (
+	metaArchiveName = "压缩包"
+	metaArchivePath = "压缩包路径"
+)
+
+var errArchiveSizeLimit = errors.New("archive exceeds total uncompressed size limit")
+
+// archiveMemberResult 记录压缩包中单个成员的入库结果
+type archiveMemberResult struct {
+	Path   string `json:"path"`
+	Name   string `json:"name,omitempty"`
+	DocID  string `json:"doc_id,omitempty"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// archiveReport 是一个压缩包的入库报告
+type archiveReport struct {
+	Archive  string                `json:"archive"`
+	Ingested int                   `json:"ingested"`
+	Skipped  int                   `json:"skipped"`
+	Failed   int                   `json:"failed"`
+	Members  []archiveMemberResult `json:"members"`
+	Error    string                `json:"error,omitempty"` // 解压中途终止的原因
+}
+
+func (r *archiveReport) add(result archiveMemberResult) {
+	switch result.Status {
+	case archiveMemberIngested:
+		r.Ingested++
+	case archiveMemberSkipped:
+		r.Skipped++
+	case archiveMemberFailed:
+		r.Failed++
+	}
+	r.Members = append(r.Members, result)
+}
+
+// isArchive 判断文件是否为支持解压的压缩包
+func isArchive(filename string) bool {
+	lower := strings.ToLower(filename)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// archiveStem 返回去掉压缩包后缀的文件名
+func archiveStem(filename string) string {
+	lower := strings.ToLower(filename)
+	for _, suffix := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(lower, suffix) {
+			return filename[:len(filename)-len(suffix)]
+		}
+	}
+	return filename
+}
+
+// sanitizeArchivePath 校验并规范化压缩包内的路径，拒绝绝对路径、.. 以及控制字符，防止 zip-slip
+func sanitizeArchivePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || len(name) > maxArchivePathLength {
+		return "", fmt.Errorf("invalid path length")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("path contains control characters")
+		}
+	}
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("absolute paths are not allowed")
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path traversal is not allowed")
+		}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("path traversal is not allowed")
+	}
+	return cleaned, nil
+}
+
+// isIgnoredArchiveMember 过滤系统生成的隐藏文件，如 __MACOSX 和 .DS_Store
+func isIgnoredArchiveMember(memberPath string) bool {
+	for _, part := range strings.Split(memberPath, "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveMemberFilename 将成员路径展开为文件名，如 manual.zip 中的 ch1/intro.md 变为 manual_ch1_intro.md
+func archiveMemberFilename(archiveName, memberPath string) string {
+	return archiveStem(archiveName) + "_" + strings.ReplaceAll(memberPath, "/", "_")
+}
+
+// ingestArchive 解压上传的压缩包，把每个支持的成员作为独立文档写入知识库，返回逐个成员的入库报告
+func ingestArchive(ctx context.Context, userID, filename string, file *multipart.FileHeader) (*archiveReport, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer src.Close()
+
+	report := &archiveReport{Archive: filename}
+	var totalSize int64
+
+	visit := func(name string, declaredSize int64, r io.Reader) error {
+		memberPath, err := sanitizeArchivePath(name)
+		if err != nil {
+			report.add(archiveMemberResult{Path: name, Status: archiveMemberFailed, Reason: err.Error()})
+			return nil
+		}
+		result := archiveMemberResult{Path: memberPath, Size: declaredSize}
+
+		switch {
+		case isIgnoredArchiveMember(memberPath):
+			result.Status = archiveMemberSkipped
+			result.Reason = "hidden or system file"
+			report.add(result)
+			return nil
+		case isArchive(memberPath):
+			result.Status = archiveMemberSkipped
+			result.Reason = "nested archives are not supported"
+			report.add(result)
+			return nil
+		case !isSupportedDocument(memberPath):
+			result.Status = archiveMemberSkipped
+			result.Reason = "unsupported file type"
+			report.add(result)
+			return nil
+		case declaredSize > maxArchiveMemberSize:
+			result.Status = archiveMemberFailed
+			result.Reason = fmt.Sprintf("file is too large. Max size is %d bytes", maxArchiveMemberSize)
+			report.add(result)
+			return nil
+		case totalSize+declaredSize > maxArchiveTotalSize:
+			return errArchiveSizeLimit
+		}
+
+		// 按实际读取的字节数限制大小，不信任压缩包头中声明的大小
+		tmp, err := os.CreateTemp(uploadDir, "archive-member-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		tmpPath := tmp.Name()
+		limit := int64(maxArchiveMemberSize)
+		if remaining := maxArchiveTotalSize - totalSize; remaining < limit {
+			limit = remaining
+		}
+		written, err := io.Copy(tmp, io.LimitReader(r, limit+1))
+		tmp.Close()
+		totalSize += written
+		if err != nil {
+			os.Remove(tmpPath)
+			result.Status = archiveMemberFailed
+			result.Reason = "failed to extract: " + err.Error()
+			report.add(result)
+			return nil
+		}
+		if written > limit {
+			os.Remove(tmpPath)
+			if limit < maxArchiveMemberSize {
+				return errArchiveSizeLimit
+			}
+			result.Status = archiveMemberFailed
+			result.Reason = fmt.Sprintf("file is too large. Max size is %d bytes", maxArchiveMemberSize)
+			report.add(result)
+			return nil
+		}
+		result.Size = written
+
+		memberName := archiveMemberFilename(filename, memberPath)
+		meta := map[string]string{
+			metaArchiveName: filename,
+			metaArchivePath: memberPath,
+		}
+		uploaded, err := ingestFile(ctx, userID, memberName, tmpPath, written, meta)
+		if err != nil {
+			result.Status = archiveMemberFailed
+			result.Reason = err.Error()
+			report.add(result)
+			return nil
+		}
+		result.Name = memberName
+		result.DocID, _ = uploaded["doc_id"].(string)
+		result.Status = archiveMemberIngested
+		report.add(result)
+		return nil
+	}
+
+	lower := strings.ToLower(filename)
+	if strings.HasSuffix(lower, ".zip") {
+		err = walkZip(src, file.Size, visit)
+	} else {
+		err = walkTarGz(src, visit)
+	}
+	if err != nil && len(report.Members) == 0 && !errors.Is(err, errArchiveSizeLimit) {
+		// 还没有处理任何成员，如压缩包格式错误或文件数超出上限
+		return nil, err
+	}
+	if err != nil {
+		// 解压中途终止时已经入库的成员保留，报告中说明终止原因
+		report.Error = err.Error()
+	}
+	return report, nil
+}
+
+// walkZip 遍历 zip 中的普通文件
+func walkZip(src io.ReaderAt, size int64, visit func(name string, declaredSize int64, r io.Reader) error) error {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	var fileCount int
+	for _, f := range zr.File {
+		if !f.Mode().IsDir() {
+			fileCount++
+		}
+	}
+	if fileCount > maxArchiveMembers {
+		return fmt.Errorf("archive contains %d files, max is %d", fileCount, maxArchiveMembers)
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		declared := int64(f.UncompressedSize64)
+		if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > maxArchiveMemberRatio {
+			return fmt.Errorf("%s has a suspicious compression ratio", f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", f.Name, err)
+		}
+		err = visit(f.Name, declared, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTarGz 遍历 tar.gz 中的普通文件，跳过符号链接等特殊文件
+func walkTarGz(src io.Reader, visit func(name string, declaredSize int64, r io.Reader) error) error {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("invalid gzip archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	var fileCount int
+	var scanned int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		fileCount++
+		if fileCount > maxArchiveMembers {
+			return fmt.Errorf("archive contains more than %d files", maxArchiveMembers)
+		}
+		// 跳过的成员同样需要解压读取，按声明大小累计，避免 gzip 炸弹耗尽CPU
+		scanned += hdr.Size
+		if scanned > maxArchiveTotalSize {
+			return errArchiveSizeLimit
+		}
+		if err := visit(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeArchivePath(t *testing.T) {
+	valid := map[string]string{
+		"docs/readme.md":      "docs/readme.md",
+		"docs\\guide.txt":     "docs/guide.txt",
+		"./a/./b.csv":         "a/b.csv",
+		"中文目录/说明.md":          "中文目录/说明.md",
+		"a//b.txt":            "a/b.txt",
+		"dir/sub/../file.txt": "",
+	}
+	for name, want := range valid {
+		got, err := sanitizeArchivePath(name)
+		if want == "" {
+			if err == nil {
+				t.Errorf("sanitizeArchivePath(%q) = %q, want error", name, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("sanitizeArchivePath(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"../etc/passwd",
+		"a/../../b.txt",
+		"/etc/passwd",
+		"C:\\Windows\\system.ini",
+		"..\\..\\evil.txt",
+		"bad\x00name.txt",
+		"line\nbreak.md",
+	}
+	for _, name := range invalid {
+		if got, err := sanitizeArchivePath(name); err == nil {
+			t.Errorf("sanitizeArchivePath(%q) = %q, want error", name, got)
+		}
+	}
+}
+
+func TestArchiveMemberFilename(t *testing.T) {
+	if got := archiveMemberFilename("manual.tar.gz", "ch1/intro.md"); got != "manual_ch1_intro.md" {
+		t.Errorf("archiveMemberFilename = %q", got)
+	}
+	if !isArchive("Docs.TGZ") || isArchive("report.gz") {
+		t.Error("isArchive returned unexpected result")
+	}
+}
+
+func buildTarGz(t *testing.T, entries []*tar.Header, bodies []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i, hdr := range entries {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if bodies[i] != "" {
+			if _, err := tw.Write([]byte(bodies[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestWalkTarGzSkipsSpecialFiles(t *testing.T) {
+	data := buildTarGz(t, []*tar.Header{
+		{Name: "docs/a.md", Typeflag: tar.TypeReg, Size: 5, Mode: 0644},
+		{Name: "docs/link.md", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755},
+	}, []string{"hello", "", ""})
+
+	var visited []string
+	err := walkTarGz(bytes.NewReader(data), func(name string, size int64, r io.Reader) error {
+		content, _ := io.ReadAll(r)
+		if string(content) != "hello" {
+			t.Errorf("unexpected content %q", content)
+		}
+		visited = append(visited, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != 1 || visited[0] != "docs/a.md" {
+		t.Errorf("visited = %v, want [docs/a.md]", visited)
+	}
+}
+
+func TestWalkTarGzFileCountLimit(t *testing.T) {
+	var headers []*tar.Header
+	var bodies []string
+	for i := 0; i <= maxArchiveMembers; i++ {
+		headers = append(headers, &tar.Header{Name: "f.txt", Typeflag: tar.TypeReg, Size: 1, Mode: 0644})
+		bodies = append(bodies, "x")
+	}
+	data := buildTarGz(t, headers, bodies)
+
+	err := walkTarGz(bytes.NewReader(data), func(string, int64, io.Reader) error { return nil })
+	if err == nil {
+		t.Fatal("expected file count limit error")
+	}
+}
+
+// archiveFileHeader 把压缩包内容包装为上传的表单文件
+func archiveFileHeader(t *testing.T, filename string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("files", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	form, err := multipart.NewReader(&body, mw.Boundary()).ReadForm(int64(len(data)) + 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["files"][0]
+}
+
+func TestIngestArchivePartialReport(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("setup.exe")
+	w.Write([]byte("MZ"))
+	w, _ = zw.Create("bomb.txt")
+	w.Write(bytes.Repeat([]byte{0}, 1<<20))
+	zw.Close()
+
+	// 中途终止时返回已处理成员的报告，并说明终止原因
+	report, err := ingestArchive(context.Background(), "u1", "docs.zip", archiveFileHeader(t, "docs.zip", buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ingestArchive: %v", err)
+	}
+	if report.Skipped != 1 || len(report.Members) != 1 || !strings.Contains(report.Error, "compression ratio") {
+		t.Fatalf("report = %+v, want one skipped member and compression ratio error", report)
+	}
+
+	// 没有处理任何成员时返回错误
+	if _, err := ingestArchive(context.Background(), "u1", "bad.zip", archiveFileHeader(t, "bad.zip", []byte("not a zip"))); err == nil {
+		t.Fatal("expected error for invalid archive")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"viking_db_tool"
+)
+
+// serverConfig 汇总可通过环境变量调整的服务配置
+type serverConfig struct {
+	// 文档预处理
+	PreprocessEnabled bool     // MKB_PREPROCESS，是否在入库前预处理文档，默认关闭
+	RedactRules       []string // MKB_REDACT_RULES，启用的内置脱敏规则，none 表示不启用
+	CustomRedactRules string   // MKB_REDACT_CUSTOM，自定义脱敏规则，JSON数组 [{"name":"qq","pattern":"..."}]
+
+	// 回收站
+	TrashRetention     time.Duration // MKB_TRASH_RETENTION，回收站中的文件保留多久后永久删除
+	TrashPurgeInterval time.Duration // MKB_TRASH_PURGE_INTERVAL，清理回收站的间隔
+
+	// 管理与对账
+	AdminToken        string        // MKB_ADMIN_TOKEN，管理接口的访问令牌，为空时禁用管理接口
+	ReconcileInterval time.Duration // MKB_RECONCILE_INTERVAL，定期对账的间隔，0 表示不定期对账
+	ReconcileRepair   bool          // MKB_RECONCILE_REPAIR，定期对账时是否自动修复
+
+	// 预签名URL有效期，最长7天
+	IngestURLExpiry   time.Duration // MKB_INGEST_URL_EXPIRY，提交给知识库拉取文件的URL有效期，需覆盖知识库排队处理的时间
+	DownloadURLExpiry time.Duration // MKB_DOWNLOAD_URL_EXPIRY，重定向给用户下载文件的URL有效期
+
+	// 每个用户的默认配额，0 表示不限制，管理员可以为单个用户调整
+	QuotaBytes     int64 // MKB_QUOTA_BYTES，存储总字节数，含历史版本和回收站
+	QuotaDocuments int64 // MKB_QUOTA_DOCUMENTS，文档数
+	QuotaTokens    int64 // MKB_QUOTA_TOKENS，知识库中已索引的token数
+
+	// 模型token计量
+	QuotaMonthlyTokens int64  // MKB_QUOTA_MONTHLY_TOKENS，每月对话和检索消耗的token上限，0 表示不限制
+	TokenPrices        string // MKB_TOKEN_PRICES，各模型每千token的价格，JSON对象 {"model":{"prompt":0.0008,"completion":0.002}}
+
+	// 限流，按用户分别计数，格式为 次数/时间，0 或 off 表示不限制
+	RateLimitChat   rateLimit // MKB_RATE_LIMIT_CHAT，对话接口的限额
+	RateLimitUpload rateLimit // MKB_RATE_LIMIT_UPLOAD，上传和编辑文档接口共享的限额
+	KBConcurrency   int       // MKB_KB_CONCURRENCY，同时向知识库发出的请求数上限
+
+	// 日志
+	LogLevel  string // MKB_LOG_LEVEL，日志级别 debug、info、warn 或 error
+	LogFormat string // MKB_LOG_FORMAT，日志格式 text 或 json
+
+	// 链路追踪
+	TraceExporter string // MKB_TRACE_EXPORTER，none 不导出，stdout 输出到标准输出，otlp 通过 OTEL_EXPORTER_OTLP_* 配置导出
+
+	// 就绪检查
+	ReadyCacheTTL     time.Duration // MKB_READY_CACHE_TTL，就绪检查结果的缓存时间
+	ReadyCheckTimeout time.Duration // MKB_READY_TIMEOUT，单次就绪检查的超时时间，超时的依赖视为不可用
+
+	// 退出
+	ShutdownTimeout time.Duration // MKB_SHUTDOWN_TIMEOUT，收到退出信号后等待进行中的请求和后台任务完成的最长时间
+
+	// 查询缓存
+	QueryCache     string        // MKB_QUERY_CACHE，memory 内存缓存，disk 保存到 ./data/query_cache，off 关闭
+	QueryCacheSize int           // MKB_QUERY_CACHE_SIZE，最多缓存的结果数
+	QueryCacheTTL  time.Duration // MKB_QUERY_CACHE_TTL，结果的有效期，检索结果中的附件链接会过期，不宜超过链接有效期
+
+	// 语义缓存，回答的有效期与查询缓存相同
+	SemanticCache          string  // MKB_SEMANTIC_CACHE，local 使用本地向量化，off 关闭
+	SemanticCacheThreshold float64 // MKB_SEMANTIC_CACHE_THRESHOLD，问题的余弦相似度不低于该值时使用缓存的回答
+	SemanticCacheSize      int     // MKB_SEMANTIC_CACHE_SIZE，每个知识库最多保存的问题数
+
+	// 检索结果后处理，按顺序执行：过滤低分并排序、去重、限制每个文档的切片数、合并相邻切片、截断到token预算
+	SearchRerank          bool    // MKB_SEARCH_RERANK，是否开启知识库的重排
+	SearchMinScore        float64 // MKB_SEARCH_MIN_SCORE，得分低于该值的切片不进入提示词
+	SearchDedupThreshold  float64 // MKB_SEARCH_DEDUP_THRESHOLD，内容相似度不低于该值的切片只保留一个，0 表示不去重
+	SearchMaxChunksPerDoc int     // MKB_SEARCH_MAX_CHUNKS_PER_DOC，每个文档最多保留的切片数，0 表示不限制
+	SearchMergeAdjacent   bool    // MKB_SEARCH_MERGE_ADJACENT，是否合并同一文档中相邻的切片
+	SearchContextTokens   int     // MKB_SEARCH_CONTEXT_TOKENS，检索结果最多占用的估算token数，0 表示不限制
+
+	// 共享知识库与联合检索
+	SharedKnowledgeBases string // MKB_SHARED_KNOWLEDGE_BASES，共享知识库及可以检索它的用户，JSON对象 {"kb_team":["u1","u2"],"kb_handbook":["*"]}
+	SearchFusion         string // MKB_SEARCH_FUSION，同时检索多个知识库时合并结果的方式，rrf 倒数排名融合，score 得分归一化
+
+	// 提示词预算
+	ModelContextTokens int // MKB_MODEL_CONTEXT_TOKENS，对话模型的上下文长度，0 表示按模型名称推断
+}
+
+// cfg 是服务使用的全局配置
+var cfg = loadConfig()
+
+// loadConfig 从环境变量加载配置，未设置的项使用默认值
+func loadConfig() serverConfig {
+	return serverConfig{
+		PreprocessEnabled: envBool("MKB_PREPROCESS", false),
+		RedactRules:       envList("MKB_REDACT_RULES", []string{"id_card", "phone", "email"}),
+		CustomRedactRules: envString("MKB_REDACT_CUSTOM", ""),
+
+		TrashRetention:     envDuration("MKB_TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval: envDuration("MKB_TRASH_PURGE_INTERVAL", time.Hour),
+
+		AdminToken:        envString("MKB_ADMIN_TOKEN", ""),
+		ReconcileInterval: envDuration("MKB_RECONCILE_INTERVAL", 6*time.Hour),
+		ReconcileRepair:   envBool("MKB_RECONCILE_REPAIR", false),
+
+		IngestURLExpiry:   envDuration("MKB_INGEST_URL_EXPIRY", 24*time.Hour),
+		DownloadURLExpiry: envDuration("MKB_DOWNLOAD_URL_EXPIRY", 15*time.Minute),
+
+		QuotaBytes:     envInt64("MKB_QUOTA_BYTES", 0),
+		QuotaDocuments: envInt64("MKB_QUOTA_DOCUMENTS", 0),
+		QuotaTokens:    envInt64("MKB_QUOTA_TOKENS", 0),
+
+		QuotaMonthlyTokens: envInt64("MKB_QUOTA_MONTHLY_TOKENS", 0),
+		TokenPrices:        envString("MKB_TOKEN_PRICES", ""),
+
+		RateLimitChat:   envRateLimit("MKB_RATE_LIMIT_CHAT", rateLimit{Count: 20, Per: time.Minute}),
+		RateLimitUpload: envRateLimit("MKB_RATE_LIMIT_UPLOAD", rateLimit{Count: 30, Per: time.Minute}),
+		KBConcurrency:   int(envInt64("MKB_KB_CONCURRENCY", 16)),
+
+		LogLevel:  envString("MKB_LOG_LEVEL", "info"),
+		LogFormat: envString("MKB_LOG_FORMAT", "text"),
+
+		TraceExporter: envString("MKB_TRACE_EXPORTER", traceExporterNone),
+
+		ReadyCacheTTL:     envDuration("MKB_READY_CACHE_TTL", 5*time.Second),
+		ReadyCheckTimeout: envDuration("MKB_READY_TIMEOUT", 3*time.Second),
+
+		ShutdownTimeout: envDuration("MKB_SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		QueryCache:     envString("MKB_QUERY_CACHE", queryCacheMemory),
+		QueryCacheSize: int(envInt64("MKB_QUERY_CACHE_SIZE", 1000)),
+		QueryCacheTTL:  envDuration("MKB_QUERY_CACHE_TTL", 10*time.Minute),
+
+		SemanticCache:          envString("MKB_SEMANTIC_CACHE", semanticCacheOff),
+		SemanticCacheThreshold: envFloat("MKB_SEMANTIC_CACHE_THRESHOLD", 0.9),
+		SemanticCacheSize:      int(envInt64("MKB_SEMANTIC_CACHE_SIZE", 200)),
+
+		SearchRerank:          envBool("MKB_SEARCH_RERANK", false),
+		SearchMinScore:        envFloat("MKB_SEARCH_MIN_SCORE", 0),
+		SearchDedupThreshold:  envFloat("MKB_SEARCH_DEDUP_THRESHOLD", 0.9),
+		SearchMaxChunksPerDoc: int(envInt64("MKB_SEARCH_MAX_CHUNKS_PER_DOC", 0)),
+		SearchMergeAdjacent:   envBool("MKB_SEARCH_MERGE_ADJACENT", true),
+		SearchContextTokens:   int(envInt64("MKB_SEARCH_CONTEXT_TOKENS", 0)),
+
+		SharedKnowledgeBases: envString("MKB_SHARED_KNOWLEDGE_BASES", ""),
+		SearchFusion:         envString("MKB_SEARCH_FUSION", string(viking_db_tool.FusionRRF)),
+
+		ModelContextTokens: int(envInt64("MKB_MODEL_CONTEXT_TOKENS", 0)),
+	}
+}
+
+// searchPostProcessSteps 按配置组装检索结果的后处理步骤
+func (c serverConfig) searchPostProcessSteps() []viking_db_tool.PostProcessStep {
+	steps := []viking_db_tool.PostProcessStep{viking_db_tool.MinScoreStep(c.SearchMinScore)}
+	if c.SearchDedupThreshold > 0 {
+		steps = append(steps, viking_db_tool.DedupStep(c.SearchDedupThreshold))
+	}
+	if c.SearchMaxChunksPerDoc > 0 {
+		steps = append(steps, viking_db_tool.MaxChunksPerDocStep(c.SearchMaxChunksPerDoc))
+	}
+	if c.SearchMergeAdjacent {
+		steps = append(steps, viking_db_tool.MergeAdjacentStep())
+	}
+	if c.SearchContextTokens > 0 {
+		steps = append(steps, viking_db_tool.TokenBudgetStep(c.SearchContextTokens))
+	}
+	return steps
+}
+
+func envString(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	value, err := strconv.ParseBool(envString(key, strconv.FormatBool(def)))
+	if err != nil {
+		slog.Warn("Invalid config value, using default", "key", key, "default", def)
+		return def
+	}
+	return value
+}
+
+func envInt64(key string, def int64) int64 {
+	value, err := strconv.ParseInt(envString(key, strconv.FormatInt(def, 10)), 10, 64)
+	if err != nil || value < 0 {
+		slog.Warn("Invalid config value, using default", "key", key, "default", def)
+		return def
+	}
+	return value
+}
+
+func envFloat(key string, def float64) float64 {
+	value, err := strconv.ParseFloat(envString(key, strconv.FormatFloat(def, 'g', -1, 64)), 64)
+	if err != nil || value < 0 {
+		slog.Warn("Invalid config value, using default", "key", key, "default", def)
+		return def
+	}
+	return value
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(envString(key, def.String()))
+	if err != nil || value < 0 {
+		slog.Warn("Invalid config value, using default", "key", key, "default", def)
+		return def
+	}
+	return value
+}
+
+func envRateLimit(key string, def rateLimit) rateLimit {
+	value, err := parseRateLimit(envString(key, def.String()))
+	if err != nil {
+		slog.Warn("Invalid config value, using default", "key", key, "default", def)
+		return def
+	}
+	return value
+}
+
+// envList 读取逗号分隔的列表，值为 none 时返回空列表
+func envList(key string, def []string) []string {
+	value := envString(key, "")
+	if value == "" {
+		return def
+	}
+	if strings.EqualFold(value, "none") {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
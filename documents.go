@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+	"tos_tool"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// 合并文档视图时的数据来源，用于报告部分失败
+const (
+	sourceStorage       = "storage"
+	sourceKnowledgeBase = "knowledge_base"
+)
+
+// documentView 合并了对象存储、知识库和文档元数据中同一个文档的信息
+type documentView struct {
+	DocID         string            `json:"doc_id"`
+	Name          string            `json:"name"`
+	DocType       string            `json:"doc_type"`
+	Size          int64             `json:"size"`
+	ObjectKey     string            `json:"object_key,omitempty"`
+	DownloadURL   string            `json:"download_url,omitempty"`
+	Source        string            `json:"source,omitempty"`
+	Version       int               `json:"version,omitempty"`
+	InStorage     bool              `json:"in_storage"`
+	Indexed       bool              `json:"indexed"`
+	PointNum      int               `json:"point_num"`
+	ProcessStatus *int              `json:"process_status"` // 未写入知识库或知识库不可用时为 null
+	StatusText    string            `json:"status_text,omitempty"`
+	Status        string            `json:"status"` // 见 docStatusKey，未写入知识库为 not_indexed，知识库不可用为 unknown
+	TotalTokens   int64             `json:"total_tokens,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	documentDiagnostics
+}
+
+func (v *documentView) applyObject(object tos_tool.ObjectInfo) {
+	v.InStorage = true
+	v.ObjectKey = object.Key
+	v.Name = object.Name
+	v.Size = object.Size
+	v.UpdatedAt = object.LastModified
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = object.LastModified
+	}
+}
+
+// applyRecord 用元数据记录补充文档信息，记录中的创建和更新时间优先
+func (v *documentView) applyRecord(record DocumentRecord) {
+	v.Name = record.Name
+	v.DocType = record.DocType
+	v.Source = record.Source
+	v.Version = record.Version
+	v.Metadata = record.Metadata
+	v.CreatedAt = record.CreatedAt
+	v.UpdatedAt = record.UpdatedAt
+	v.documentDiagnostics = newDocumentDiagnostics(record)
+	if v.ObjectKey == "" {
+		v.ObjectKey = record.ObjectKey
+	}
+	if !v.InStorage {
+		v.Size = record.Size
+	}
+}
+
+func (v *documentView) applyKnowledgeBase(doc viking_db_tool.DocumentInfo) {
+	status := doc.Status.ProcessStatus
+	v.Indexed = true
+	v.PointNum = doc.PointNum
+	v.ProcessStatus = &status
+	v.StatusText = viking_db_tool.NewDocumentStatusInfo(doc).StatusText
+	v.TotalTokens = doc.TotalTokens
+	if status == docStatusFailed && v.LastError == "" {
+		v.LastError = failureReason(doc.Status)
+	}
+	if v.Name == "" {
+		v.Name = doc.DocName
+	}
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = unixTime(doc.CreateTime)
+	}
+	if v.UpdatedAt.IsZero() {
+		v.UpdatedAt = unixTime(doc.UpdateTime)
+	}
+}
+
+// finish 补全文档类型和状态，kbAvailable 为 false 表示知识库查询失败
+func (v *documentView) finish(kbAvailable bool) {
+	if v.DocType == "" {
+		v.DocType = getDocTypeByExtension(v.Name)
+	}
+	switch {
+	case v.Indexed:
+		v.Status = docStatusKey(*v.ProcessStatus)
+	case kbAvailable:
+		v.Status = "not_indexed"
+	default:
+		v.Status = "unknown"
+	}
+}
+
+// unixTime 转换知识库返回的时间戳，兼容秒和毫秒
+func unixTime(value int64) time.Time {
+	switch {
+	case value <= 0:
+		return time.Time{}
+	case value > 1e12:
+		return time.UnixMilli(value)
+	default:
+		return time.Unix(value, 0)
+	}
+}
+
+// 列出用户的全部文档，每个文档一条合并后的记录
+func listDocuments(ctx context.Context, c *app.RequestContext) {
+	userID := c.Query("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	q, err := parseListQuery(c)
+	if err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	views := make(map[string]*documentView)
+	view := func(docID string) *documentView {
+		if views[docID] == nil {
+			views[docID] = &documentView{DocID: docID}
+		}
+		return views[docID]
+	}
+	sourceErrors := make(map[string]string)
+
+	// 对象存储中的文件
+	for object, err := range tos_tool.ObjectsWithEnvConfig(ctx, userUploadPrefix(userID)) {
+		if err != nil {
+			sourceErrors[sourceStorage] = "Failed to list files from TOS: " + err.Error()
+			break
+		}
+		view(generateDocID(object.Name)).applyObject(object)
+	}
+
+	// 元数据记录
+	for _, record := range registry.List(userID) {
+		view(record.DocID).applyRecord(record)
+	}
+
+	// 知识库中的文档
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(userID), knowledgeBaseProject)
+	if err != nil {
+		sourceErrors[sourceKnowledgeBase] = "Failed to check knowledge base existence: " + err.Error()
+	} else if exists {
+		for doc, err := range viking_db_tool.Documents(ctx, resourceID) {
+			if err != nil {
+				sourceErrors[sourceKnowledgeBase] = "Failed to list documents: " + err.Error()
+				break
+			}
+			view(doc.DocID).applyKnowledgeBase(doc)
+		}
+	}
+
+	if len(sourceErrors) == 2 {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error":  "Failed to load documents from both TOS and knowledge base",
+			"errors": sourceErrors,
+		})
+		return
+	}
+
+	_, kbFailed := sourceErrors[sourceKnowledgeBase]
+	documents := make([]*documentView, 0, len(views))
+	for _, v := range views {
+		v.finish(!kbFailed)
+		documents = append(documents, v)
+	}
+
+	documents, page := applyListQuery(documents, q, func(v *documentView) listEntry {
+		return listEntry{
+			Name:    v.Name,
+			DocType: v.DocType,
+			Size:    v.Size,
+			Time:    v.UpdatedAt,
+			Status:  v.Status,
+		}
+	})
+
+	// 下载地址指向下载代理，访问时才生成预签名URL
+	for _, v := range documents {
+		if v.InStorage {
+			v.DownloadURL = downloadPath(userID, v.Name)
+		}
+	}
+
+	response := utils.H{
+		"documents": documents,
+		"user_id":   userID,
+		"page":      page,
+		"partial":   len(sourceErrors) > 0,
+	}
+	if len(sourceErrors) > 0 {
+		response["errors"] = sourceErrors
+	}
+	c.JSON(consts.StatusOK, response)
+}
+
+// 查询单个文档的合并记录
+func getDocument(ctx context.Context, c *app.RequestContext) {
+	userID := c.Query("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	docID := c.Param("id")
+	if !requireDocID(c, docID) {
+		return
+	}
+	v := &documentView{DocID: docID}
+	sourceErrors := make(map[string]string)
+
+	record, hasRecord := registry.Get(userID, docID)
+	if hasRecord {
+		v.applyRecord(record)
+	}
+
+	// 知识库中的文档，同时查询token用量
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(userID), knowledgeBaseProject)
+	if err != nil {
+		sourceErrors[sourceKnowledgeBase] = "Failed to check knowledge base existence: " + err.Error()
+	} else if exists {
+		info, err := viking_db_tool.GetDocumentInfo(ctx, viking_db_tool.DocumentInfoRequest{
+			ResourceID:       resourceID,
+			DocID:            docID,
+			ReturnTokenUsage: true,
+		})
+		if err != nil {
+			sourceErrors[sourceKnowledgeBase] = "Failed to get document info: " + err.Error()
+		} else if info.Code == 0 && info.Data != nil {
+			v.applyKnowledgeBase(viking_db_tool.DocumentInfo(*info.Data))
+		}
+	}
+
+	// 对象存储中的文件，没有元数据记录时按知识库中的文档名推断对象键
+	objectKey := v.ObjectKey
+	if objectKey == "" && v.Name != "" {
+		objectKey = uploadObjectKey(userID, v.Name)
+	}
+	if objectKey != "" {
+		object, err := tos_tool.StatObjectWithEnvConfig(ctx, objectKey)
+		switch {
+		case err == nil:
+			v.InStorage = true
+			v.ObjectKey = object.Key
+			v.Size = object.Size
+			if !hasRecord {
+				v.CreatedAt, v.UpdatedAt = object.LastModified, object.LastModified
+			}
+		case !errors.Is(err, tos_tool.ErrObjectNotFound):
+			sourceErrors[sourceStorage] = "Failed to get file from TOS: " + err.Error()
+		}
+	}
+
+	if !hasRecord && !v.Indexed && !v.InStorage && len(sourceErrors) == 0 {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "Document not found",
+		})
+		return
+	}
+
+	_, kbFailed := sourceErrors[sourceKnowledgeBase]
+	v.finish(!kbFailed)
+	if v.InStorage {
+		v.DownloadURL = downloadPath(userID, v.Name)
+	}
+
+	response := utils.H{
+		"document": v,
+		"partial":  len(sourceErrors) > 0,
+	}
+	if len(sourceErrors) > 0 {
+		response["errors"] = sourceErrors
+	}
+	c.JSON(consts.StatusOK, response)
+}
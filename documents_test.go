@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+	"tos_tool"
+	"viking_db_tool"
+)
+
+func TestDocumentViewMerge(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+
+	v := &documentView{DocID: "reportpdf"}
+	v.applyObject(tos_tool.ObjectInfo{Key: "uploads/u1/report.pdf", Name: "report.pdf", Size: 2048, LastModified: updated.Add(time.Minute)})
+	v.applyRecord(DocumentRecord{DocID: "reportpdf", Name: "report.pdf", DocType: "pdf", Size: 1024, Version: 2, CreatedAt: created, UpdatedAt: updated})
+	v.applyKnowledgeBase(viking_db_tool.DocumentInfo{DocID: "reportpdf", DocName: "ignored.pdf", PointNum: 7, TotalTokens: 900, Status: viking_db_tool.DocumentProcessingStatus{ProcessStatus: 6}})
+	v.finish(true)
+
+	if v.Name != "report.pdf" || v.Size != 2048 || v.Version != 2 {
+		t.Errorf("unexpected storage/record fields: %+v", v)
+	}
+	if !v.CreatedAt.Equal(created) || !v.UpdatedAt.Equal(updated) {
+		t.Errorf("record times should win, got %v / %v", v.CreatedAt, v.UpdatedAt)
+	}
+	if !v.InStorage || !v.Indexed || v.PointNum != 7 || v.TotalTokens != 900 || v.Status != "processing" {
+		t.Errorf("unexpected knowledge base fields: %+v", v)
+	}
+
+	// 只存在于知识库中的文档
+	orphan := &documentView{DocID: "oldmd"}
+	orphan.applyKnowledgeBase(viking_db_tool.DocumentInfo{DocID: "oldmd", DocName: "old.md", CreateTime: 1700000000})
+	orphan.finish(true)
+	if orphan.Name != "old.md" || orphan.DocType != "markdown" || orphan.InStorage || orphan.Status != "completed" {
+		t.Errorf("unexpected orphan view: %+v", orphan)
+	}
+	if !orphan.CreatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("CreatedAt = %v", orphan.CreatedAt)
+	}
+
+	// 知识库不可用时状态未知
+	pending := &documentView{DocID: "atxt", Name: "a.txt"}
+	pending.finish(false)
+	if pending.Status != "unknown" || pending.ProcessStatus != nil {
+		t.Errorf("unexpected status without knowledge base: %+v", pending)
+	}
+}
+
+func TestUnixTime(t *testing.T) {
+	if !unixTime(0).IsZero() {
+		t.Error("zero timestamp should map to zero time")
+	}
+	if got := unixTime(1700000000123); !got.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("millisecond timestamp = %v", got)
+	}
+}
+
+func TestDownloadPath(t *testing.T) {
+	got := downloadPath("user 1", "报告 v1.pdf")
+	want := "/api/files/%E6%8A%A5%E5%91%8A%20v1.pdf/download?user_id=user+1"
+	if got != want {
+		t.Errorf("downloadPath = %q, want %q", got, want)
+	}
+
+	got = contentDisposition(`报告"1".pdf`)
+	want = `attachment; filename="___1_.pdf"; filename*=UTF-8''%E6%8A%A5%E5%91%8A%221%22.pdf`
+	if got != want {
+		t.Errorf("contentDisposition = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"tos_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// downloadPath 返回文件经下载代理的访问路径，列表中只返回该路径，访问时才读取TOS
+func downloadPath(userID, filename string) string {
+	return fmt.Sprintf("/api/files/%s/download?user_id=%s", url.PathEscape(filename), url.QueryEscape(userID))
+}
+
+// contentDisposition 生成附件下载的响应头，兼容非ASCII文件名
+func contentDisposition(filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, url.PathEscape(filename))
+}
+
+// 下载文件：只允许下载该用户 uploads/ 下的文件，默认由服务端从TOS转发，
+// redirect=true 时重定向到短期有效的预签名URL
+func downloadFile(ctx context.Context, c *app.RequestContext) {
+	// 校验参数并按元数据记录核对文件归属
+	record, ok := resolveUserFile(ctx, c, c.Query("user_id"), c.Param("filename"))
+	if !ok {
+		return
+	}
+
+	filename, objectKey := record.Name, record.ObjectKey
+	object, err := tos_tool.StatObjectWithEnvConfig(ctx, objectKey)
+	if errors.Is(err, tos_tool.ErrObjectNotFound) {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "File not found",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to get file from TOS: " + err.Error(),
+		})
+		return
+	}
+
+	if c.Query("redirect") == "true" {
+		preSignedURL, err := tos_tool.PreSignGetURLWithEnvConfig(objectKey, cfg.DownloadURLExpiry)
+		if err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Failed to generate download URL: " + err.Error(),
+			})
+			return
+		}
+		c.Redirect(consts.StatusFound, []byte(preSignedURL))
+		return
+	}
+
+	content, size, err := tos_tool.GetObjectWithEnvConfig(ctx, objectKey)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to get file from TOS: " + err.Error(),
+		})
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", contentDisposition(filename))
+	c.Header("Last-Modified", object.LastModified.UTC().Format(http.TimeFormat))
+	c.Response.Header.SetContentType(contentType)
+	// 响应结束后由框架关闭 content
+	c.SetBodyStream(content, int(size))
+}
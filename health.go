@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tos_tool"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// 就绪检查的依赖名称
+const (
+	dependencyObjectStore   = "object_store"
+	dependencyKnowledgeBase = "knowledge_base"
+	dependencyDisk          = "disk"
+)
+
+// readinessProbeKB 是就绪检查时查询的知识库名称，不需要真实存在
+const readinessProbeKB = "kb_readiness_probe"
+
+// dependencyCheck 是检查一个依赖的函数，返回错误表示该依赖不可用
+type dependencyCheck func(ctx context.Context) error
+
+// dependencyStatus 是单个依赖的检查结果
+type dependencyStatus struct {
+	Status    string `json:"status"` // ok 或 error
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// readinessReport 是一次就绪检查的结果
+type readinessReport struct {
+	Ready     bool                        `json:"ready"`
+	CheckedAt time.Time                   `json:"checked_at"`
+	Checks    map[string]dependencyStatus `json:"checks"`
+}
+
+// readinessChecker 并发检查所有依赖，并在 ttl 内缓存结果，避免探针频繁调用TOS和知识库
+type readinessChecker struct {
+	checks  map[string]dependencyCheck
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu   sync.Mutex
+	last *readinessReport
+}
+
+func newReadinessChecker(checks map[string]dependencyCheck, ttl, timeout time.Duration) *readinessChecker {
+	return &readinessChecker{checks: checks, ttl: ttl, timeout: timeout}
+}
+
+// Check 返回缓存中未过期的结果，否则重新检查。检查期间持有锁，同时到达的探针等待同一次检查的结果
+func (r *readinessChecker) Check(ctx context.Context, now time.Time) readinessReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last != nil && now.Sub(r.last.CheckedAt) < r.ttl {
+		return *r.last
+	}
+
+	// 检查结果会缓存给其他探针使用，不随当前请求取消
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeout)
+	defer cancel()
+
+	report := readinessReport{Ready: true, CheckedAt: now, Checks: make(map[string]dependencyStatus, len(r.checks))}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for name, check := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			status := dependencyStatus{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = "error"
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = status
+			if err != nil {
+				report.Ready = false
+			}
+		}()
+	}
+	wg.Wait()
+
+	r.last = &report
+	return report
+}
+
+// checkDirWritable 在目录中写入并删除一个临时文件，检查磁盘是否可写
+func checkDirWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("upload directory is not writable: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString("ok"); err != nil {
+		file.Close()
+		return fmt.Errorf("upload directory is not writable: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("upload directory is not writable: %w", err)
+	}
+	return nil
+}
+
+// readiness 检查TOS的存储桶、知识库接口和上传目录
+var readiness = newReadinessChecker(map[string]dependencyCheck{
+	dependencyObjectStore: tos_tool.HeadBucketWithEnvConfig,
+	dependencyKnowledgeBase: func(ctx context.Context) error {
+		return viking_db_tool.Ping(ctx, readinessProbeKB, knowledgeBaseProject)
+	},
+	dependencyDisk: func(context.Context) error {
+		return checkDirWritable(uploadDir)
+	},
+}, cfg.ReadyCacheTTL, cfg.ReadyCheckTimeout)
+
+// 存活检查，只说明进程能处理请求，不检查依赖
+func livenessHandler(ctx context.Context, c *app.RequestContext) {
+	c.JSON(consts.StatusOK, utils.H{
+		"status": "ok",
+		"time":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// 就绪检查，所有依赖可用时返回200，否则返回503并给出不可用的依赖
+func readinessHandler(ctx context.Context, c *app.RequestContext) {
+	// 开始退出后不再接收流量
+	if inflight.Draining() {
+		c.JSON(consts.StatusServiceUnavailable, utils.H{
+			"status": "shutting_down",
+			"error":  "Server is shutting down",
+		})
+		return
+	}
+
+	report := readiness.Check(ctx, time.Now())
+	resp := utils.H{
+		"status":     "ready",
+		"checked_at": report.CheckedAt.Format(time.RFC3339),
+		"checks":     report.Checks,
+	}
+	if report.Ready {
+		c.JSON(consts.StatusOK, resp)
+		return
+	}
+
+	var failed []string
+	for name, check := range report.Checks {
+		if check.Status != "ok" {
+			failed = append(failed, name)
+		}
+	}
+	sort.Strings(failed)
+	resp["status"] = "not_ready"
+	resp["error"] = "Dependencies unavailable: " + strings.Join(failed, ", ")
+	c.JSON(consts.StatusServiceUnavailable, resp)
+}
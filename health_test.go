@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadinessChecker(t *testing.T) {
+	calls := 0
+	var kbErr error
+	checker := newReadinessChecker(map[string]dependencyCheck{
+		dependencyDisk: func(context.Context) error {
+			return nil
+		},
+		dependencyKnowledgeBase: func(context.Context) error {
+			calls++
+			return kbErr
+		},
+	}, 5*time.Second, time.Second)
+
+	now := time.Now()
+	report := checker.Check(context.Background(), now)
+	if !report.Ready || report.Checks[dependencyDisk].Status != "ok" || report.Checks[dependencyKnowledgeBase].Status != "ok" {
+		t.Fatalf("report = %+v, want ready", report)
+	}
+
+	// 缓存期内不重新检查
+	kbErr = errors.New("connection refused")
+	if report := checker.Check(context.Background(), now.Add(4*time.Second)); !report.Ready || calls != 1 {
+		t.Fatalf("cached report = %+v after %d calls, want the cached ready report", report, calls)
+	}
+
+	report = checker.Check(context.Background(), now.Add(5*time.Second))
+	if report.Ready || calls != 2 {
+		t.Fatalf("report = %+v after %d calls, want not ready after a new check", report, calls)
+	}
+	if got := report.Checks[dependencyKnowledgeBase]; got.Status != "error" || got.Error != "connection refused" {
+		t.Fatalf("knowledge base check = %+v, want error", got)
+	}
+	if report.Checks[dependencyDisk].Status != "ok" {
+		t.Fatalf("disk check = %+v, want ok", report.Checks[dependencyDisk])
+	}
+}
+
+func TestCheckDirWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkDirWritable(dir); err != nil {
+		t.Fatalf("checkDirWritable(%q) = %v", dir, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*")); len(matches) != 0 {
+		t.Fatalf("checkDirWritable left files behind: %v", matches)
+	}
+	if err := checkDirWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("checkDirWritable on a missing directory = nil, want error")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader 是请求ID的请求头和响应头，调用方传入合法的ID时沿用，否则由服务生成
+const requestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 64
+
+type requestIDKey struct{}
+
+// withRequestID 返回携带请求ID的 ctx，ctx 传入 tos_tool 和 viking_db_tool 后，它们的日志也会带上请求ID
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// requestIDFrom 返回 ctx 中的请求ID，后台任务中为空
+func requestIDFrom(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// newRequestID 生成16字节的随机请求ID
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID 检查调用方传入的请求ID，只接受不含特殊字符的短ID，避免注入日志
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		if !isRequestIDChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isRequestIDChar(r rune) bool {
+	return r < utf8.RuneSelf && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.@:", r))
+}
+
+// requestIDHandler 为每条日志补上 ctx 中的请求ID和链路ID
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if requestID := requestIDFrom(ctx); requestID != "" {
+		r.AddAttrs(slog.String("request_id", requestID))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// newLogger 按配置的级别和格式（text 或 json）创建日志
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid MKB_LOG_LEVEL %q: %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid MKB_LOG_FORMAT %q, use text or json", format)
+	}
+	return slog.New(requestIDHandler{handler}), nil
+}
+
+// requestLogMiddleware 为每个请求分配请求ID并放入 ctx，请求结束后记录访问日志
+func requestLogMiddleware(ctx context.Context, c *app.RequestContext) {
+	requestID := string(c.GetHeader(requestIDHeader))
+	if !validRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	ctx = withRequestID(ctx, requestID)
+	c.Header(requestIDHeader, requestID)
+
+	start := time.Now()
+	c.Next(ctx)
+
+	status := c.Response.StatusCode()
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "request",
+		slog.String("method", string(c.Method())),
+		slog.String("path", string(c.Path())),
+		slog.Int("status", status),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("client_ip", c.ClientIP()),
+	)
+}
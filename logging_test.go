@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLoggerAddsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "debug", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withRequestID(context.Background(), "req-1")
+	logger.With("component", "test").InfoContext(ctx, "hello", slog.String("user_id", "u1"))
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v: %s", err, buf.String())
+	}
+	if entry["request_id"] != "req-1" || entry["component"] != "test" || entry["user_id"] != "u1" {
+		t.Errorf("entry = %v", entry)
+	}
+
+	buf.Reset()
+	logger.Info("background")
+	if bytes.Contains(buf.Bytes(), []byte("request_id")) {
+		t.Errorf("entry without request ID = %s", buf.String())
+	}
+
+	if _, err := newLogger(&buf, "verbose", "json"); err == nil {
+		t.Error("invalid level accepted")
+	}
+	if _, err := newLogger(&buf, "info", "xml"); err == nil {
+		t.Error("invalid format accepted")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"0af7651916cd43dd8448eb211c80319c": true,
+		"trace-1:span-2":                   true,
+		"":                                 false,
+		"a b":                              false,
+		"a\nlevel=ERROR":                   false,
+		string(make([]byte, 65)):           false,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
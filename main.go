@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 	"tos_tool"
@@ -17,47 +20,51 @@ import (
 	"github.com/cloudwego/hertz/pkg/common/utils"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
 	"github.com/hertz-contrib/cors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	uploadDir   = "./uploads"
 	maxFileSize = 100 << 20 // 100MB
+
+	knowledgeBaseProject = "default"
 )
 
+// 非结构化文档支持类型
+var unstructuredDocTypes = map[string]string{
+	".txt":      "txt",
+	".doc":      "doc",
+	".docx":     "docx",
+	".pdf":      "pdf",
+	".markdown": "markdown",
+	".md":       "markdown",
+	".pptx":     "pptx",
+}
+
+// 结构化文档支持类型
+var structuredDocTypes = map[string]string{
+	".xlsx":  "xlsx",
+	".csv":   "csv",
+	".jsonl": "jsonl",
+}
+
 // getDocTypeByExtension 根据文件后缀确定文档类型
 func getDocTypeByExtension(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
 
-	// 非结构化文档支持类型
-	unstructuredTypes := map[string]string{
-		".txt":      "txt",
-		".doc":      "doc",
-		".docx":     "docx",
-		".pdf":      "pdf",
-		".markdown": "markdown",
-		".md":       "markdown",
-		".pptx":     "pptx",
-	}
-
-	// 结构化文档支持类型
-	structuredTypes := map[string]string{
-		".xlsx":  "xlsx",
-		".csv":   "csv",
-		".jsonl": "jsonl",
-	}
-
 	// 特殊处理：faq.xlsx 文件
 	if strings.Contains(strings.ToLower(filename), "faq") && ext == ".xlsx" {
 		return "faq.xlsx"
 	}
 
 	// 先检查非结构化类型
-	if docType, exists := unstructuredTypes[ext]; exists {
+	if docType, exists := unstructuredDocTypes[ext]; exists {
 		return docType
 	}
 
 	// 再检查结构化类型
-	if docType, exists := structuredTypes[ext]; exists {
+	if docType, exists := structuredDocTypes[ext]; exists {
 		return docType
 	}
 
@@ -65,20 +72,152 @@ func getDocTypeByExtension(filename string) string {
 	return "txt"
 }
 
+// isSupportedDocument 判断文件后缀是否为知识库支持的文档类型
+func isSupportedDocument(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	_, unstructured := unstructuredDocTypes[ext]
+	_, structured := structuredDocTypes[ext]
+	return unstructured || structured
+}
+
+// generateDocID 根据文件名生成知识库文档ID，只保留字母、数字以及_和-
+func generateDocID(filename string) string {
+	return docIDPattern.ReplaceAllString(filename, "")
+}
+
+var docIDPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// knowledgeBaseName 返回用户对应的知识库名称
+func knowledgeBaseName(userID string) string {
+	return "kb_" + userID // 使用用户id作为知识库名称
+}
+
+// ensureKnowledgeBase 检查用户的知识库是否存在，不存在则创建，返回知识库ResourceID
+func ensureKnowledgeBase(ctx context.Context, userID string) (string, error) {
+	name := knowledgeBaseName(userID)
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, name, knowledgeBaseProject)
+	if err != nil {
+		return "", fmt.Errorf("Failed to check knowledge base existence: %w", err)
+	}
+	if exists {
+		slog.DebugContext(ctx, "Knowledge base exists", "name", name, "resource_id", resourceID)
+		return resourceID, nil
+	}
+
+	// 如果知识库不存在，创建知识库
+	createResp, err := viking_db_tool.CreateKnowledgeBase(ctx, name, "Knowledge base for user documents", "unstructured_data", knowledgeBaseProject)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create knowledge base: %w", err)
+	}
+	if createResp.Code != 0 {
+		return "", fmt.Errorf("Failed to create knowledge base: %s", createResp.Message)
+	}
+	slog.InfoContext(ctx, "Created knowledge base", "name", name, "resource_id", createResp.Data.ResourceID)
+	return createResp.Data.ResourceID, nil
+}
+
 func main() {
+	// 配置日志
+	logger, err := newLogger(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		panic(err.Error())
+	}
+	slog.SetDefault(logger)
+
+	// 配置链路追踪
+	shutdownTracing, err := setupTracing(context.Background(), cfg.TraceExporter)
+	if err != nil {
+		panic(err.Error())
+	}
+
 	// 创建上传目录
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
 		panic(fmt.Sprintf("Failed to create upload directory: %v", err))
 	}
 
-	h := server.Default(server.WithHostPorts("0.0.0.0:8888"))
+	// 加载文档元数据
+	registry, err = newDocumentRegistry(filepath.Join(dataDir, registryFile))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load document registry: %v", err))
+	}
+
+	// 加载未完成的工作
+	pending, err = newPendingWorkStore(filepath.Join(dataDir, pendingWorkFile))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load pending work: %v", err))
+	}
+
+	// 加载用户配额
+	quotas, err = newQuotaStore(filepath.Join(dataDir, quotaFile))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load quotas: %v", err))
+	}
+
+	// 加载模型token用量
+	prices, err := parseTokenPrices(cfg.TokenPrices)
+	if err != nil {
+		panic(err.Error())
+	}
+	meter, err = newTokenMeter(filepath.Join(dataDir, tokenUsageFile), prices)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to load token usage: %v", err))
+	}
+
+	// 加载共享知识库和联合检索配置
+	sharedKnowledgeBases, err = parseSharedKnowledgeBases(cfg.SharedKnowledgeBases)
+	if err != nil {
+		panic(err.Error())
+	}
+	searchFusion, err = viking_db_tool.ParseFusionMethod(cfg.SearchFusion)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid MKB_SEARCH_FUSION: %v", err))
+	}
+
+	// 创建检索和对话结果的缓存
+	queryCache, err = newQueryResultCache(cfg.QueryCache, cfg.QueryCacheSize, cfg.QueryCacheTTL, dataDir)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create query cache: %v", err))
+	}
+	semantic, err = newSemanticCache(cfg.SemanticCache, cfg.SemanticCacheThreshold, cfg.SemanticCacheSize, cfg.QueryCacheTTL, queryCache.versions)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create semantic cache: %v", err))
+	}
+
+	// 构建文档预处理流水线
+	preprocessPipeline, err = newPreprocessPipeline(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to build preprocess pipeline: %v", err))
+	}
+
+	// 限制同时向知识库发出的请求数
+	viking_db_tool.SetMaxConcurrentRequests(cfg.KBConcurrency)
+
+	// 统计TOS和知识库请求的耗时和错误，并为每次请求创建span
+	tos_tool.SetObserver(chainHooks(outboundObserver(outboundSystemTOS), outboundTracer(outboundSystemTOS)))
+	viking_db_tool.SetObserver(chainHooks(outboundObserver(outboundSystemKB), outboundTracer(outboundSystemKB)))
+
+	// 命令行对账：file-upload-server reconcile [-repair] [-server addr] [-user id1,id2]
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		os.Exit(runReconcileCommand(os.Args[2:]))
+	}
+
+	// 清理上次运行遗留的临时文件，命令行对账时服务可能正在运行，不能清理
+	if err := cleanUploadDir(uploadDir); err != nil {
+		panic(fmt.Sprintf("Failed to clean upload directory: %v", err))
+	}
+
+	h := server.Default(server.WithHostPorts("0.0.0.0:8888"), server.WithExitWaitTime(cfg.ShutdownTimeout))
+	h.SetCustomSignalWaiter(waitForShutdownSignal)
+
+	// 为每个请求分配请求ID并记录访问日志，按路由统计请求指标，创建请求的span
+	h.Use(requestLogMiddleware, metricsMiddleware, tracingMiddleware)
 
 	// 配置CORS
 	h.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", adminTokenHeader, requestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", requestIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -92,25 +231,69 @@ func main() {
 	h.Static("/static", "./static")
 
 	// API路由
-	api := h.Group("/api")
+	// 退出时拒绝新的写请求，并等待进行中的写请求完成
+	api := h.Group("/api", drainMiddleware)
 	{
-		api.POST("/upload", uploadFile)
+		// 上传和编辑文档共享一个限额
+		uploadLimit := rateLimitMiddleware(cfg.RateLimitUpload)
+		chatLimit := rateLimitMiddleware(cfg.RateLimitChat)
+
+		api.POST("/upload", uploadLimit, uploadFile)
 		api.GET("/files", listFiles)
 		api.GET("/files/:filename", downloadFile)
+		api.GET("/files/:filename/download", downloadFile)
 		api.DELETE("/files/:filename", deleteFile)
-		api.POST("/chat", chatWithKnowledgeBase)
+		api.POST("/chat", chatLimit, chatWithKnowledgeBase)
+		api.GET("/documents", listDocuments)
 		api.GET("/documents/status", getDocumentStatus)
+		api.GET("/documents/events", documentEvents)
+		api.GET("/documents/:id", getDocument)
+		api.POST("/documents/text", uploadLimit, createTextDocument)
+		api.PUT("/documents/:id", uploadLimit, updateTextDocument)
+		api.GET("/documents/:id/versions", listDocumentVersions)
+		api.GET("/documents/:id/versions/:version", downloadDocumentVersion)
+		api.GET("/documents/:id/diff", diffDocumentVersions)
+		api.POST("/documents/:id/rollback", rollbackDocument)
+		api.POST("/documents/:id/reprocess", reprocessDocument)
+		api.POST("/documents/retry-failed", retryFailedDocuments)
+		api.GET("/trash", listTrash)
+		api.GET("/usage", getUsage)
+		api.GET("/usage/tokens", getTokenUsage)
+		api.POST("/trash/:id/restore", restoreTrashItem)
 	}
 
-	// 健康检查
-	h.GET("/health", func(ctx context.Context, c *app.RequestContext) {
-		c.JSON(consts.StatusOK, utils.H{
-			"status": "ok",
-			"time":   time.Now().Format(time.RFC3339),
-		})
+	// 管理接口，需要 X-Admin-Token
+	admin := api.Group("/admin", requireAdminToken)
+	{
+		admin.POST("/reconcile", reconcileHandler)
+		admin.GET("/reconcile", lastReconcileHandler)
+		admin.GET("/quotas/:user_id", getUserQuota)
+		admin.PUT("/quotas/:user_id", setUserQuota)
+		admin.DELETE("/quotas/:user_id", resetUserQuota)
+	}
+
+	// 健康检查：/healthz 为存活检查，/readyz 检查依赖是否可用，/health 保留兼容
+	h.GET("/health", livenessHandler)
+	h.GET("/healthz", livenessHandler)
+	h.GET("/readyz", readinessHandler)
+
+	// Prometheus指标
+	h.GET("/metrics", metricsHandler)
+
+	// 后台任务：继续上次退出时未完成的工作，定期清理回收站中超过保留期限的文件，定期对账，跟踪文档处理状态
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	inflight.Go(func() { resumePendingWork(backgroundCtx) })
+	startTrashPurger(backgroundCtx)
+	startReconciler(backgroundCtx)
+	inflight.Go(func() { statusWatcher.Run(backgroundCtx) })
+	h.OnShutdown = append(h.OnShutdown, func(ctx context.Context) {
+		drainAndPersist(ctx, stopBackground)
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
 	})
 
-	fmt.Println("Server starting on http://0.0.0.0:8888 (accessible from external IPs)")
+	slog.Info("Server starting on http://0.0.0.0:8888 (accessible from external IPs)")
 	h.Spin()
 }
 
@@ -126,14 +309,13 @@ func uploadFile(ctx context.Context, c *app.RequestContext) {
 	}
 
 	// 获取用户ID
-	userIDs := form.Value["user_id"]
-	if len(userIDs) == 0 || userIDs[0] == "" {
-		c.JSON(consts.StatusBadRequest, utils.H{
-			"error": "User ID is required",
-		})
+	var userID string
+	if userIDs := form.Value["user_id"]; len(userIDs) > 0 {
+		userID = userIDs[0]
+	}
+	if !requireUserID(c, userID) {
 		return
 	}
-	userID := userIDs[0]
 
 	files := form.File["file"]
 	if len(files) == 0 {
@@ -143,7 +325,10 @@ func uploadFile(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("upload.files", len(files)))
+
 	var uploadedFiles []map[string]interface{}
+	var archives []*archiveReport
 	for _, file := range files {
 		// 检查文件大小
 		if file.Size > maxFileSize {
@@ -153,8 +338,25 @@ func uploadFile(ctx context.Context, c *app.RequestContext) {
 			return
 		}
 
-		// 创建临时文件路径
 		filename := filepath.Base(file.Filename)
+		if !requireFilename(c, filename) {
+			return
+		}
+
+		// 压缩包解压后逐个成员入库
+		if isArchive(filename) {
+			report, err := ingestArchive(ctx, userID, filename, file)
+			if err != nil {
+				c.JSON(consts.StatusBadRequest, utils.H{
+					"error": fmt.Sprintf("Failed to unpack archive %s: %v", filename, err),
+				})
+				return
+			}
+			archives = append(archives, report)
+			continue
+		}
+
+		// 创建临时文件路径
 		tempFilePath := filepath.Join(uploadDir, filename)
 
 		// 打开源文件
@@ -186,226 +388,333 @@ func uploadFile(ctx context.Context, c *app.RequestContext) {
 		}
 		dst.Close()
 
-		// 调用TOS上传方法，在路径中包含用户ID
-		objectKey := fmt.Sprintf("uploads/%s/%s", userID, filename)
-		preSignedURL, err := tos_tool.UploadFileWithEnvConfig(tempFilePath, objectKey)
+		uploaded, err := ingestFile(ctx, userID, filename, tempFilePath, file.Size, nil)
 		if err != nil {
-			// 清理临时文件
-			os.Remove(tempFilePath)
-			c.JSON(consts.StatusInternalServerError, utils.H{
-				"error": "Failed to upload to TOS: " + err.Error(),
-			})
+			writeIngestError(c, err)
 			return
 		}
+		uploadedFiles = append(uploadedFiles, uploaded)
+	}
 
-		// 清理临时文件
-		os.Remove(tempFilePath)
+	response := utils.H{
+		"message": "Files uploaded successfully to TOS",
+		"files":   uploadedFiles,
+	}
+	if len(archives) > 0 {
+		response["archives"] = archives
+	}
+	c.JSON(consts.StatusOK, response)
+}
 
-		uploadedFiles = append(uploadedFiles, map[string]interface{}{
-			"name":    filename,
-			"url":     preSignedURL,
-			"user_id": userID,
+// writeIngestError 写入上传失败的响应：文档ID与其他文件冲突时为 409，其余见 writeQuotaError
+func writeIngestError(c *app.RequestContext, err error) {
+	var conflict *docIDConflictError
+	if errors.As(err, &conflict) {
+		c.JSON(consts.StatusConflict, utils.H{
+			"error":         err.Error(),
+			"doc_id":        conflict.DocID,
+			"existing_name": conflict.ExistingName,
 		})
+		return
+	}
+	writeQuotaError(c, err)
+}
 
-		// 检查知识库是否存在
-		knowledgeBaseName := "kb_" + userID // 使用用户id作为知识库名称
-		project := "default"
-		exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName, project)
-		if err != nil {
-			c.JSON(consts.StatusInternalServerError, utils.H{
-				"error": "Failed to check knowledge base existence: " + err.Error(),
-			})
-			return
+// ingestFile 将本地临时文件上传到TOS并写入用户知识库，完成后删除临时文件
+func ingestFile(ctx context.Context, userID, filename, tempFilePath string, size int64, extraMeta map[string]string) (uploaded map[string]interface{}, err error) {
+	// 清理临时文件
+	defer os.Remove(tempFilePath)
+
+	// 压缩包成员的文件名由成员路径生成，同样需要校验
+	if err := validateFilename(filename); err != nil {
+		return nil, err
+	}
+
+	docType := getDocTypeByExtension(filename)
+	docID := generateDocID(filename)
+	objectKey := uploadObjectKey(userID, filename)
+
+	ctx, span := startSpan(ctx, "upload.ingest",
+		attribute.String("doc.id", docID), attribute.String("doc.type", docType), attribute.Int64("doc.size", size))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	// 同名文件再次上传时作为新版本保存；回收站中的同名文档直接恢复为新版本
+	if err := checkDocIDConflict(userID, docID, objectKey); err != nil {
+		return nil, err
+	}
+	existing, replacing := registry.Get(userID, docID)
+	var previous *DocumentVersion // 知识库中已有的版本，新版本写入失败时恢复
+	if replacing {
+		active := existing.ActiveVersion()
+		previous = &active
+	}
+	trashed, inTrash := registry.GetTrashed(userID, docID)
+	if inTrash {
+		existing = trashed
+	}
+	version := 1
+	if replacing || inTrash {
+		version = existing.LatestVersion() + 1
+	}
+	span.SetAttributes(attribute.Int("doc.version", version), attribute.Bool("doc.replacing", replacing))
+
+	// 新版本计入存储用量，只有新文档计入文档数
+	addDocuments := 1
+	if replacing {
+		addDocuments = 0
+	}
+	if err := checkQuota(userID, size, addDocuments); err != nil {
+		return nil, err
+	}
+
+	// 需要预处理的文档先读出原始内容
+	var raw []byte
+	if shouldPreprocess(docType) {
+		var err error
+		if raw, err = os.ReadFile(tempFilePath); err != nil {
+			return nil, fmt.Errorf("Failed to read temporary file: %w", err)
 		}
+	}
 
-		if !exists {
-			// 如果知识库不存在，创建知识库
-			createResp, err := viking_db_tool.CreateKnowledgeBase(ctx, knowledgeBaseName, "Knowledge base for user documents", "unstructured_data", project)
-			if err != nil {
-				c.JSON(consts.StatusInternalServerError, utils.H{
-					"error": "Failed to create knowledge base: " + err.Error(),
-				})
-				return
-			}
-			if createResp.Code != 0 {
-				c.JSON(consts.StatusInternalServerError, utils.H{
-					"error": "Failed to create knowledge base: " + createResp.Message,
-				})
-				return
-			}
-			resourceID = createResp.Data.ResourceID
-			fmt.Printf("Created knowledge base: %s with ResourceID: %s\n", knowledgeBaseName, resourceID)
-		} else {
-			fmt.Printf("Knowledge base exists: %s with ResourceID: %s\n", knowledgeBaseName, resourceID)
-		}
-
-		// 上传文件到Viking DB
-		// docID只保留字符和数字以及_和-
-		docID := regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(filename, "")
-		docName := filename
-		docType := getDocTypeByExtension(filename)
-		meta := []viking_db_tool.MetaField{
-			viking_db_tool.CreateStringMetaField("行业", "企业服务"),
-			viking_db_tool.CreateStringMetaField("用户ID", userID),
-		}
-
-		// 上传文件到Viking DB
-		response, err := viking_db_tool.UploadDocumentByURL(
-			ctx,
-			resourceID,
-			docID,
-			docName,
-			docType,
-			preSignedURL,
-			meta,
-		)
+	// 调用TOS上传方法，在路径中包含用户ID
+	stageCtx, stage := startSpan(ctx, "upload.store")
+	v, err := storeVersionFile(stageCtx, userID, docID, filename, objectKey, version, tempFilePath, size)
+	endSpan(stage, err)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			c.JSON(consts.StatusInternalServerError, utils.H{
-				"error": "Failed to upload to Viking DB: " + err.Error(),
-			})
-			return
+	// 记录文档元数据
+	now := time.Now()
+	record := DocumentRecord{
+		DocID:     docID,
+		UserID:    userID,
+		Name:      filename,
+		DocType:   docType,
+		ObjectKey: objectKey,
+		Source:    documentSourceUpload,
+		Size:      size,
+		Version:   version,
+		Versions:  []DocumentVersion{v},
+		Metadata:  extraMeta,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if replacing || inTrash {
+		record.CreatedAt = existing.CreatedAt
+		record.Versions = append(existing.Versions, v)
+	}
+	if inTrash {
+		if err := tos_tool.DeleteFileWithEnvConfig(ctx, trashed.TrashKey); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete object from TOS", "key", trashed.TrashKey, "error", err)
 		}
+	}
 
-		fmt.Printf("Uploaded document to Viking DB: %s\n", response)
+	// 检查知识库是否存在，不存在则创建
+	stageCtx, stage = startSpan(ctx, "upload.ensure_knowledge_base")
+	resourceID, err := ensureKnowledgeBase(stageCtx, userID)
+	endSpan(stage, err)
+	if err != nil {
+		return nil, err
 	}
 
-	c.JSON(consts.StatusOK, utils.H{
-		"message": "Files uploaded successfully to TOS",
-		"files":   uploadedFiles,
-	})
+	// 上传文件到Viking DB，预处理后的副本单独保存，知识库中索引处理后的版本
+	// 写入失败时同样保存记录，以便查看失败原因并重新处理
+	stageCtx, stage = startSpan(ctx, "upload.index", attribute.Bool("doc.preprocess", raw != nil))
+	processed, err := indexAndSave(stageCtx, resourceID, &record, v, raw, previous)
+	endSpan(stage, err)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded = map[string]interface{}{
+		"name":    filename,
+		"url":     downloadPath(userID, filename),
+		"user_id": userID,
+		"doc_id":  docID,
+		"version": version,
+	}
+	if processed != nil {
+		uploaded["preprocess"] = processed
+	}
+	return uploaded, nil
+}
+
+// sortedMetaKeys 返回排序后的元数据字段名，保证写入顺序稳定
+func sortedMetaKeys(meta map[string]string) []string {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // 列出所有文件
 func listFiles(ctx context.Context, c *app.RequestContext) {
 	// 获取用户ID参数
 	userID := c.Query("user_id")
-	if userID == "" {
-		c.JSON(consts.StatusBadRequest, utils.H{
-			"error": "User ID is required",
-		})
+	if !requireUserID(c, userID) {
 		return
 	}
 
-	// 使用TOS工具列出用户上传的文件
-	prefix := fmt.Sprintf("uploads/%s/", userID)
-	files, err := tos_tool.ListFilesWithEnvConfig(prefix)
+	q, err := parseListQuery(c)
 	if err != nil {
-		c.JSON(consts.StatusInternalServerError, utils.H{
-			"error": "Failed to list files from TOS: " + err.Error(),
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
-	// 为每个文件添加用户ID信息
-	for i := range files {
-		files[i]["user_id"] = userID
+	// 使用TOS工具逐页列出用户上传的文件
+	prefix := userUploadPrefix(userID)
+	var objects []tos_tool.ObjectInfo
+	for object, err := range tos_tool.ObjectsWithEnvConfig(ctx, prefix) {
+		if err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Failed to list files from TOS: " + err.Error(),
+			})
+			return
+		}
+		objects = append(objects, object)
+	}
+
+	// 按处理状态过滤或排序时，从知识库查询文档状态
+	var statuses map[string]string
+	if q.NeedsStatus() {
+		if statuses, err = documentStatuses(ctx, userID); err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Failed to get document status: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	objects, page := applyListQuery(objects, q, func(object tos_tool.ObjectInfo) listEntry {
+		return listEntry{
+			Name:    object.Name,
+			DocType: getDocTypeByExtension(object.Name),
+			Size:    object.Size,
+			Time:    object.LastModified,
+			Status:  statuses[generateDocID(object.Name)],
+		}
+	})
+
+	// 下载地址指向下载代理，访问时才生成预签名URL，并添加用户ID信息
+	files := make([]map[string]interface{}, 0, len(objects))
+	for _, object := range objects {
+		file := map[string]interface{}{
+			"name":    object.Name,
+			"size":    object.Size,
+			"modTime": object.LastModified.Format(time.RFC3339),
+			"key":     object.Key,
+			"url":     downloadPath(userID, object.Name),
+			"user_id": userID,
+		}
+		if status, ok := statuses[generateDocID(object.Name)]; ok {
+			file["status"] = status
+		}
+		files = append(files, file)
 	}
 
 	c.JSON(consts.StatusOK, utils.H{
 		"files":   files,
 		"user_id": userID,
+		"page":    page,
 	})
 }
 
-// 下载文件
-func downloadFile(ctx context.Context, c *app.RequestContext) {
-	filename := c.Param("filename")
-	if filename == "" {
-		c.JSON(consts.StatusBadRequest, utils.H{
-			"error": "Filename is required",
-		})
-		return
+// documentStatuses 返回用户知识库中每个文档的处理状态，按 docID 索引；
+// 知识库中没有的文档状态为 not_indexed
+func documentStatuses(ctx context.Context, userID string) (map[string]string, error) {
+	statuses := make(map[string]string)
+	for _, record := range registry.List(userID) {
+		statuses[record.DocID] = "not_indexed"
 	}
 
-	filepath := filepath.Join(uploadDir, filename)
-
-	// 检查文件是否存在
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		c.JSON(consts.StatusNotFound, utils.H{
-			"error": "File not found",
-		})
-		return
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(userID), knowledgeBaseProject)
+	if err != nil || !exists {
+		return statuses, err
 	}
 
-	c.File(filepath)
+	for doc, err := range viking_db_tool.Documents(ctx, resourceID) {
+		if err != nil {
+			return nil, err
+		}
+		statuses[doc.DocID] = docStatusKey(doc.Status.ProcessStatus)
+	}
+	return statuses, nil
 }
 
 // 删除文件
 func deleteFile(ctx context.Context, c *app.RequestContext) {
-	filename := c.Param("filename")
-	if filename == "" {
-		c.JSON(consts.StatusBadRequest, utils.H{
-			"error": "Filename is required",
-		})
-		return
-	}
-
-	// 获取用户ID参数
 	userID := c.Query("user_id")
-	if userID == "" {
-		c.JSON(consts.StatusBadRequest, utils.H{
-			"error": "User ID is required",
-		})
+
+	// 校验参数并按元数据记录核对文件归属
+	record, ok := resolveUserFile(ctx, c, userID, c.Param("filename"))
+	if !ok {
 		return
 	}
+	docID := record.DocID
 
-	// 构建TOS对象键
-	objectKey := fmt.Sprintf("uploads/%s/%s", userID, filename)
-
-	// 删除TOS中的文件
-	err := tos_tool.DeleteFileWithEnvConfig(objectKey)
-	if err != nil {
+	// 将TOS中的文件移入回收站，保留元数据以便恢复
+	if err := moveToTrash(ctx, &record); err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{
-			"error": "Failed to delete file from TOS: " + err.Error(),
+			"error": "Failed to move file to trash: " + err.Error(),
 		})
 		return
 	}
+	purgeAt := record.DeletedAt.Add(cfg.TrashRetention)
 
 	// 检查知识库是否存在
-	knowledgeBaseName := "kb_" + userID
-	project := "default"
-	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName, project)
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(userID), knowledgeBaseProject)
 	if err != nil {
-		// 如果检查知识库存在性失败，记录错误但不影响TOS删除的成功响应
-		fmt.Printf("Failed to check knowledge base existence: %v\n", err)
+		// 如果检查知识库存在性失败，记录错误但不影响移入回收站的成功响应
+		slog.ErrorContext(ctx, "Failed to check knowledge base existence", "error", err)
 		c.JSON(consts.StatusOK, utils.H{
-			"message": "File deleted successfully from TOS, but failed to check knowledge base",
+			"message":  "File moved to trash, but failed to check knowledge base",
+			"purge_at": purgeAt,
 		})
 		return
 	}
 
 	if exists {
-		// 生成与上传时相同的docID
-		docID := regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(filename, "")
 
 		// 删除知识库中的文档
 		deleteResp, err := viking_db_tool.DeleteDocumentByResourceID(ctx, resourceID, docID)
 		if err != nil {
-			// 如果删除知识库文档失败，记录错误但不影响TOS删除的成功响应
-			fmt.Printf("Failed to delete document from knowledge base: %v\n", err)
+			// 如果删除知识库文档失败，记录错误但不影响移入回收站的成功响应
+			slog.ErrorContext(ctx, "Failed to delete document from knowledge base", "doc_id", docID, "error", err)
 			c.JSON(consts.StatusOK, utils.H{
-				"message": "File deleted successfully from TOS, but failed to delete from knowledge base",
+				"message":  "File moved to trash, but failed to delete from knowledge base",
+				"purge_at": purgeAt,
 			})
 			return
 		}
 
 		if deleteResp.Code != 0 {
-			fmt.Printf("Failed to delete document from knowledge base: code %d, message %s\n", deleteResp.Code, deleteResp.Message)
+			slog.ErrorContext(ctx, "Failed to delete document from knowledge base", "doc_id", docID,
+				"code", deleteResp.Code, "message", deleteResp.Message, "kb_request_id", deleteResp.RequestID)
 			c.JSON(consts.StatusOK, utils.H{
-				"message": "File deleted successfully from TOS, but failed to delete from knowledge base",
+				"message":  "File moved to trash, but failed to delete from knowledge base",
+				"purge_at": purgeAt,
 			})
 			return
 		}
 
-		fmt.Printf("Successfully deleted document from knowledge base: %s\n", docID)
+		slog.InfoContext(ctx, "Deleted document from knowledge base", "doc_id", docID)
+		queryCache.Invalidate(ctx, userID)
 		c.JSON(consts.StatusOK, utils.H{
-			"message": "File deleted successfully from both TOS and knowledge base",
+			"message":  "File moved to trash and removed from knowledge base",
+			"purge_at": purgeAt,
 		})
 	} else {
-		// 知识库不存在，只删除TOS文件
+		// 知识库不存在，只移动TOS文件
 		c.JSON(consts.StatusOK, utils.H{
-			"message": "File deleted successfully from TOS (knowledge base not found)",
+			"message":  "File moved to trash (knowledge base not found)",
+			"purge_at": purgeAt,
 		})
 	}
 }
@@ -413,9 +722,10 @@ func deleteFile(ctx context.Context, c *app.RequestContext) {
 // 知识库对话处理
 func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 	var request struct {
-		UserID   string                        `json:"user_id"`
-		Query    string                        `json:"query"`
-		Messages []viking_db_tool.MessageParam `json:"messages"`
+		UserID         string                        `json:"user_id"`
+		Query          string                        `json:"query"`
+		Messages       []viking_db_tool.MessageParam `json:"messages"`
+		KnowledgeBases []string                      `json:"knowledge_bases"` // 要检索的知识库，为空时检索个人知识库和可以访问的所有共享知识库
 	}
 
 	if err := c.BindJSON(&request); err != nil {
@@ -425,10 +735,7 @@ func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	if request.UserID == "" {
-		c.JSON(consts.StatusBadRequest, utils.H{
-			"error": "User ID is required",
-		})
+	if !requireUserID(c, request.UserID) {
 		return
 	}
 
@@ -439,10 +746,34 @@ func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	// 本月token用量达到上限时拒绝对话
+	if err := checkMonthlyTokens(request.UserID); err != nil {
+		writeQuotaError(c, err)
+		return
+	}
+
+	// 确定要检索的知识库，个人知识库在前
+	kbName := knowledgeBaseName(request.UserID)
+
+	// 本次请求各阶段的token用量在请求结束时一次记录，生成回答失败时同样记录已经消耗的检索用量
+	var usageStages []tokenStage
+	defer func() {
+		if err := meter.RecordStages(request.UserID, kbName, usageStages, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "Failed to record token usage", "error", err)
+		}
+	}()
+	kbNames, err := searchKnowledgeBaseNames(request.UserID, request.KnowledgeBases)
+	if err != nil {
+		c.JSON(consts.StatusForbidden, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	// 检查知识库是否存在
-	knowledgeBaseName := "kb_" + request.UserID
-	project := "default"
-	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName, project)
+	stageCtx, span := startSpan(ctx, "chat.check_knowledge_base", attribute.String("kb.name", kbName))
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(stageCtx, kbName, knowledgeBaseProject)
+	endSpan(span, err, attribute.Bool("kb.exists", exists))
 	if err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{
 			"error": "Failed to check knowledge base existence: " + err.Error(),
@@ -450,24 +781,56 @@ func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	if !exists {
+	// 个人知识库还没有创建时只检索共享知识库
+	if !exists && kbNames[0] == kbName {
+		kbNames = kbNames[1:]
+	}
+	if len(kbNames) == 0 {
 		c.JSON(consts.StatusNotFound, utils.H{
 			"error": "Knowledge base not found. Please upload some files first.",
 		})
 		return
 	}
+	targets := searchTargets(kbNames, kbName, resourceID, knowledgeBaseProject)
+	cacheable := !includesShared(kbNames, kbName)
+
+	// 单轮提问时查找同一知识库中相似的已回答问题，多轮对话的问题依赖上下文，不使用语义缓存；
+	// 语义缓存按单个知识库保存，只在检索个人知识库时使用
+	var queryVector []float32
+	var kbVersion int64
+	if cacheable && semantic != nil && len(request.Messages) == 0 {
+		kbVersion = semantic.Version(kbNames[0])
+		if queryVector, err = semantic.Embed(ctx, request.Query); err != nil {
+			slog.WarnContext(ctx, "Failed to embed query", "error", err)
+			queryVector = nil
+		} else if match, ok := semantic.Lookup(kbNames[0], queryVector, time.Now()); ok {
+			c.JSON(consts.StatusOK, utils.H{
+				"answer":         match.answer.Answer,
+				"usage":          match.answer.Usage,
+				"search_usage":   match.answer.SearchUsage,
+				"citations":      match.answer.Citations,
+				"semantic_match": match,
+				"cached": utils.H{
+					"search":   false,
+					"chat":     false,
+					"semantic": true,
+				},
+			})
+			return
+		}
+	}
 
 	// 设置知识库检索参数
-	viking_db_tool.CollectionName = knowledgeBaseName
-	viking_db_tool.Project = project
+	viking_db_tool.CollectionName = kbName
+	viking_db_tool.Project = knowledgeBaseProject
 	viking_db_tool.ResourceID = resourceID
 	viking_db_tool.Query = request.Query
 
 	// 构建检索请求参数
 	searchReq := viking_db_tool.CollectionSearchKnowledgeRequest{
-		Name:        knowledgeBaseName,
-		Project:     project,
-		ResourceId:  resourceID,
+		Name:        targets[0].Name,
+		Project:     targets[0].Project,
+		ResourceId:  targets[0].ResourceId,
 		Query:       request.Query,
 		Limit:       5, // 限制返回结果数量
 		DenseWeight: 0.5,
@@ -478,7 +841,7 @@ func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 			Messages:         request.Messages, // 使用传入的聊天历史
 		},
 		Postprocessing: viking_db_tool.PostProcessing{
-			RerankSwitch:        false,
+			RerankSwitch:        cfg.SearchRerank,
 			RetrieveCount:       25,
 			GetAttachmentLink:   true,
 			ChunkGroup:          true,
@@ -486,24 +849,78 @@ func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 		},
 	}
 
+	// 知识库内容没有变化时，相同的问题和检索参数直接使用缓存的检索结果；检索共享知识库时不使用缓存
+	var searchKey string
+	if cacheable {
+		cacheReq := searchReq
+		cacheReq.Query = normalizeQuery(request.Query)
+		searchKey, err = queryCache.Key(queryCacheSearch, kbName, struct {
+			Request        viking_db_tool.CollectionSearchKnowledgeRequest `json:"request"`
+			KnowledgeBases map[string]int64                                `json:"knowledge_bases"`
+			Fusion         viking_db_tool.FusionMethod                     `json:"fusion,omitempty"`
+		}{cacheReq, knowledgeBaseVersions(queryCache.versions, kbNames), searchFusion})
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to build query cache key", "error", err)
+		}
+	}
+	var searchResp *viking_db_tool.CollectionSearchKnowledgeResponse
+	searchCached := searchKey != "" && queryCache.Get(queryCacheSearch, searchKey, &searchResp)
+
 	// 执行知识库检索
-	searchResp, err := viking_db_tool.SearchKnowledgeWithParams(ctx, searchReq)
-	if err != nil {
-		c.JSON(consts.StatusInternalServerError, utils.H{
-			"error": "Failed to search knowledge base: " + err.Error(),
-		})
-		return
+	stageCtx, span = startSpan(ctx, "chat.search", attribute.Int64("search.limit", int64(searchReq.Limit)), attribute.Int("search.knowledge_bases", len(targets)), attribute.Bool("cache.hit", searchCached))
+	if !searchCached {
+		if len(targets) == 1 {
+			searchResp, err = viking_db_tool.SearchKnowledgeWithParams(stageCtx, searchReq)
+		} else {
+			// 并发检索多个知识库并合并结果，每个切片记录所在的知识库
+			searchResp, err = viking_db_tool.SearchKnowledgeFederated(stageCtx, searchReq, targets, searchFusion)
+		}
+		if err != nil {
+			endSpan(span, err)
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Failed to search knowledge base: " + err.Error(),
+			})
+			return
+		}
+
+		if searchResp.Code != 0 {
+			endSpan(span, fmt.Errorf("code %d, message %s", searchResp.Code, searchResp.Message))
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Knowledge base search failed: " + searchResp.Message,
+			})
+			return
+		}
+		queryCache.Set(searchKey, searchResp)
 	}
 
-	if searchResp.Code != 0 {
-		c.JSON(consts.StatusInternalServerError, utils.H{
-			"error": "Knowledge base search failed: " + searchResp.Message,
-		})
-		return
+	// 记录检索各阶段的token用量，命中缓存时没有消耗token
+	var searchUsage *viking_db_tool.TotalTokenUsage
+	var resultCount int
+	if searchResp.Data != nil {
+		searchUsage = searchResp.Data.TokenUsage
+		resultCount = len(searchResp.Data.ResultList)
+	}
+	endSpan(span, nil, append(searchUsageAttributes(searchUsage), attribute.Int("search.result_count", resultCount))...)
+	if !searchCached {
+		usageStages = append(usageStages, searchStages(searchUsage)...)
+	}
+
+	// 检索结果后处理，缓存的是处理前的结果，调整后处理配置后不需要清空缓存
+	_, span = startSpan(ctx, "chat.post_process", attribute.Int("search.result_count", resultCount))
+	searchResp = viking_db_tool.PostProcessResults(searchResp, cfg.searchPostProcessSteps()...)
+	if searchResp.Data != nil {
+		resultCount = len(searchResp.Data.ResultList)
 	}
+	endSpan(span, nil, attribute.Int("post_process.result_count", resultCount))
 
-	// 生成提示词
-	prompt, images, err := viking_db_tool.GeneratePrompt(searchResp)
+	// 按模型的上下文长度生成提示词，为问题和回答预留上下文，放不下的低名次切片截断或丢弃
+	budget := viking_db_tool.NewPromptBudget(viking_db_tool.ModelName, []viking_db_tool.MessageParam{{Role: "user", Content: request.Query}})
+	if cfg.ModelContextTokens > 0 {
+		budget.ContextWindow = cfg.ModelContextTokens
+	}
+	_, span = startSpan(ctx, "chat.generate_prompt", attribute.Int("prompt.context_window", budget.ContextWindow))
+	prompt, images, promptReport, err := viking_db_tool.GeneratePromptWithBudget(searchResp, budget)
+	endSpan(span, err, append(promptReportAttributes(promptReport), attribute.Int("prompt.length", len(prompt)), attribute.Int("prompt.images", len(images)))...)
 	if err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{
 			"error": "Failed to generate prompt: " + err.Error(),
@@ -551,26 +968,74 @@ func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 		}
 	}
 
+	// 检索结果和模型相同时直接使用缓存的回答，检索共享知识库时不使用缓存
+	var chatKey string
+	if cacheable {
+		chatKey, err = queryCache.Key(queryCacheChat, kbName, struct {
+			Model    string                        `json:"model"`
+			Messages []viking_db_tool.MessageParam `json:"messages"`
+		}{viking_db_tool.ModelName, messages})
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to build query cache key", "error", err)
+		}
+	}
+	var chatResp *viking_db_tool.CollectionChatCompletionResponse
+	chatCached := chatKey != "" && queryCache.Get(queryCacheChat, chatKey, &chatResp)
+
 	// 调用大模型生成回答
-	chatResp, err := viking_db_tool.ChatCompletion(ctx, messages)
+	stageCtx, span = startSpan(ctx, "chat.completion", attribute.String("llm.model", viking_db_tool.ModelName), attribute.Bool("cache.hit", chatCached))
+	if !chatCached {
+		chatResp, err = viking_db_tool.ChatCompletion(stageCtx, messages)
+		if err != nil {
+			endSpan(span, err)
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Failed to generate response: " + err.Error(),
+			})
+			return
+		}
+
+		if chatResp.Code != 0 {
+			endSpan(span, fmt.Errorf("code %d, message %s", chatResp.Code, chatResp.Message))
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Chat completion failed: " + chatResp.Message,
+			})
+			return
+		}
+		queryCache.Set(chatKey, chatResp)
+	}
+
+	// 记录大模型的token用量，命中缓存时没有消耗token
+	usage, err := chatResp.Data.TokenUsage()
 	if err != nil {
-		c.JSON(consts.StatusInternalServerError, utils.H{
-			"error": "Failed to generate response: " + err.Error(),
-		})
-		return
+		slog.WarnContext(ctx, "Failed to parse token usage", "error", err)
+	}
+	endSpan(span, nil, tokenUsageAttributes("llm", usage)...)
+	if !chatCached {
+		usageStages = append(usageStages, tokenStage{viking_db_tool.ModelName, usage})
 	}
 
-	if chatResp.Code != 0 {
-		c.JSON(consts.StatusInternalServerError, utils.H{
-			"error": "Chat completion failed: " + chatResp.Message,
-		})
-		return
+	citations := searchCitations(searchResp)
+	if queryVector != nil {
+		semantic.Store(kbNames[0], request.Query, queryVector, semanticAnswer{
+			Answer:      chatResp.Data.GenerateAnswer,
+			Citations:   citations,
+			Usage:       usage,
+			SearchUsage: searchUsage,
+		}, kbVersion, time.Now())
 	}
 
 	// 返回生成的回答
 	c.JSON(consts.StatusOK, utils.H{
-		"answer": chatResp.Data.GenerateAnswer,
-		"usage":  chatResp.Data.Usage,
+		"answer":       chatResp.Data.GenerateAnswer,
+		"usage":        usage,
+		"search_usage": searchUsage,
+		"citations":    citations,
+		"context":      promptReport,
+		"cached": utils.H{
+			"search":   searchCached,
+			"chat":     chatCached,
+			"semantic": false,
+		},
 	})
 }
 
@@ -578,17 +1043,20 @@ func chatWithKnowledgeBase(ctx context.Context, c *app.RequestContext) {
 func getDocumentStatus(ctx context.Context, c *app.RequestContext) {
 	// 获取用户ID参数
 	userID := c.Query("user_id")
-	if userID == "" {
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	q, err := parseListQuery(c)
+	if err != nil {
 		c.JSON(consts.StatusBadRequest, utils.H{
-			"error": "User ID is required",
+			"error": err.Error(),
 		})
 		return
 	}
 
 	// 检查知识库是否存在
-	knowledgeBaseName := "kb_" + userID
-	project := "default"
-	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName, project)
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(userID), knowledgeBaseProject)
 	if err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{
 			"error": "Failed to check knowledge base existence: " + err.Error(),
@@ -603,7 +1071,7 @@ func getDocumentStatus(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	// 获取文档处理状态
+	// 分页获取全部文档的处理状态
 	docStatus, err := viking_db_tool.GetDocumentProcessingStatus(ctx, resourceID)
 	if err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{
@@ -612,8 +1080,40 @@ func getDocumentStatus(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	docStatus, page := applyListQuery(docStatus, q, func(info viking_db_tool.DocumentStatusInfo) listEntry {
+		docType := info.DocType
+		if docType == "" {
+			docType = getDocTypeByExtension(info.DocName)
+		}
+		record, _ := registry.Get(userID, info.DocID)
+		return listEntry{
+			Name:    info.DocName,
+			DocType: docType,
+			Size:    record.Size,
+			Time:    time.Unix(info.UpdateTime, 0),
+			Status:  docStatusKey(info.ProcessStatus),
+		}
+	})
+
+	// 附带元数据记录中的重试次数和失败原因
+	entries := make([]documentStatusEntry, 0, len(docStatus))
+	for _, info := range docStatus {
+		entry := documentStatusEntry{DocumentStatusInfo: info}
+		if record, ok := registry.Get(userID, info.DocID); ok {
+			entry.documentDiagnostics = newDocumentDiagnostics(record)
+		}
+		if entry.LastError == "" && info.ProcessStatus == docStatusFailed {
+			entry.LastError = failureReason(viking_db_tool.DocumentProcessingStatus{
+				ProcessStatus: info.ProcessStatus,
+				FailedMsg:     info.FailedMsg,
+			})
+		}
+		entries = append(entries, entry)
+	}
+
 	c.JSON(consts.StatusOK, utils.H{
-		"document_status": docStatus,
+		"document_status": entries,
 		"user_id":         userID,
+		"page":            page,
 	})
 }
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+const tokenUsageFile = "token_usage.json"
+
+// 检索接口各阶段的模型在知识库中配置，按阶段名计量
+const (
+	searchModelEmbedding = "embedding"
+	searchModelRerank    = "rerank"
+	searchModelRewrite   = "rewrite"
+	searchModelLLM       = "search_llm"
+)
+
+const quotaResourceMonthlyTokens = "monthly_tokens"
+
+const usageDateLayout = "2006-01-02"
+
+// tokenUsageEntry 是一个用户在某天调用某个模型的累计token用量
+type tokenUsageEntry struct {
+	Date             string `json:"date"`
+	UserID           string `json:"user_id"`
+	KnowledgeBase    string `json:"knowledge_base"`
+	Model            string `json:"model"`
+	Calls            int64  `json:"calls"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+}
+
+func (e *tokenUsageEntry) key() string {
+	return strings.Join([]string{e.Date, e.UserID, e.KnowledgeBase, e.Model}, "|")
+}
+
+// tokenPrice 是模型每千token的价格
+type tokenPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// parseTokenPrices 解析 MKB_TOKEN_PRICES 配置，为空时不估算费用
+func parseTokenPrices(value string) (map[string]tokenPrice, error) {
+	prices := make(map[string]tokenPrice)
+	if value == "" {
+		return prices, nil
+	}
+	if err := json.Unmarshal([]byte(value), &prices); err != nil {
+		return nil, fmt.Errorf("invalid MKB_TOKEN_PRICES: %w", err)
+	}
+	return prices, nil
+}
+
+// cost 估算一条用量的费用，没有配置价格的模型费用为 0
+func (p tokenPrice) cost(e tokenUsageEntry) float64 {
+	prompt, completion := e.PromptTokens, e.CompletionTokens
+	if prompt == 0 && completion == 0 {
+		// 重排只返回总token数
+		prompt = e.TotalTokens
+	}
+	return (float64(prompt)*p.Prompt + float64(completion)*p.Completion) / 1000
+}
+
+// tokenMeter 按天、用户、知识库和模型累计token用量，以JSON文件持久化
+type tokenMeter struct {
+	mu      sync.RWMutex
+	path    string
+	entries map[string]*tokenUsageEntry
+	prices  map[string]tokenPrice
+}
+
+// meter 是服务使用的全局token计量表，在 main 中初始化
+var meter *tokenMeter
+
+// newTokenMeter 从指定文件加载用量记录，文件不存在时创建空表
+func newTokenMeter(path string, prices map[string]tokenPrice) (*tokenMeter, error) {
+	m := &tokenMeter{
+		path:    path,
+		entries: make(map[string]*tokenUsageEntry),
+		prices:  prices,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token usage: %w", err)
+	}
+
+	var entries []*tokenUsageEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse token usage: %w", err)
+	}
+	for _, e := range entries {
+		m.entries[e.key()] = e
+	}
+	return m, nil
+}
+
+// tokenStage 是一次请求中一个模型（或检索阶段）的用量
+type tokenStage struct {
+	model string
+	usage *viking_db_tool.ModelTokenUsage
+}
+
+// searchStages 把检索接口的用量拆分为按阶段名计量的用量
+func searchStages(usage *viking_db_tool.TotalTokenUsage) []tokenStage {
+	if usage == nil {
+		return nil
+	}
+	var rerank *viking_db_tool.ModelTokenUsage
+	if usage.RerankUsage != nil {
+		rerank = &viking_db_tool.ModelTokenUsage{TotalTokens: *usage.RerankUsage}
+	}
+	return []tokenStage{
+		{searchModelEmbedding, usage.EmbeddingUsage},
+		{searchModelRerank, rerank},
+		{searchModelRewrite, usage.RewriteUsage},
+		{searchModelLLM, usage.LLMUsage},
+	}
+}
+
+// RecordStages 在一次加锁中累计一次请求各阶段的用量，只落盘一次；usage 为 nil 的阶段忽略，没有用量时不落盘
+func (m *tokenMeter) RecordStages(userID, kb string, stages []tokenStage, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recorded := false
+	for _, stage := range stages {
+		if stage.usage == nil {
+			continue
+		}
+		e := &tokenUsageEntry{Date: at.Format(usageDateLayout), UserID: userID, KnowledgeBase: kb, Model: stage.model}
+		if existing, ok := m.entries[e.key()]; ok {
+			e = existing
+		} else {
+			m.entries[e.key()] = e
+		}
+		e.Calls++
+		e.PromptTokens += stage.usage.PromptTokens
+		e.CompletionTokens += stage.usage.CompletionTokens
+		e.TotalTokens += stage.usage.TotalTokens
+		recorded = true
+	}
+	if !recorded {
+		return nil
+	}
+	return m.saveLocked()
+}
+
+// Record 累计一次模型调用的用量并落盘，usage 为 nil 时忽略
+func (m *tokenMeter) Record(userID, kb, model string, usage *viking_db_tool.ModelTokenUsage, at time.Time) error {
+	return m.RecordStages(userID, kb, []tokenStage{{model, usage}}, at)
+}
+
+// RecordSearch 累计检索接口各阶段的用量
+func (m *tokenMeter) RecordSearch(userID, kb string, usage *viking_db_tool.TotalTokenUsage, at time.Time) error {
+	return m.RecordStages(userID, kb, searchStages(usage), at)
+}
+
+// Entries 返回用户在 [from, to] 日期范围内的用量，按日期和模型排序
+func (m *tokenMeter) Entries(userID, from, to string) []tokenUsageEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []tokenUsageEntry
+	for _, e := range m.entries {
+		if e.UserID == userID && e.Date >= from && e.Date <= to {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key() < entries[j].key()
+	})
+	return entries
+}
+
+// MonthTotal 返回用户在 at 所在月份的总token用量
+func (m *tokenMeter) MonthTotal(userID string, at time.Time) int64 {
+	month := at.Format("2006-01")
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var total int64
+	for _, e := range m.entries {
+		if e.UserID == userID && strings.HasPrefix(e.Date, month) {
+			total += e.TotalTokens
+		}
+	}
+	return total
+}
+
+// ModelTotals 返回每个模型累计的用量，按模型名排序
+func (m *tokenMeter) ModelTotals() []tokenUsageEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	totals := make(map[string]*tokenUsageEntry)
+	for _, e := range m.entries {
+		total, ok := totals[e.Model]
+		if !ok {
+			total = &tokenUsageEntry{Model: e.Model}
+			totals[e.Model] = total
+		}
+		total.Calls += e.Calls
+		total.PromptTokens += e.PromptTokens
+		total.CompletionTokens += e.CompletionTokens
+		total.TotalTokens += e.TotalTokens
+	}
+
+	entries := make([]tokenUsageEntry, 0, len(totals))
+	for _, model := range sortedKeys(totals) {
+		entries = append(entries, *totals[model])
+	}
+	return entries
+}
+
+// Cost 估算一条用量的费用
+func (m *tokenMeter) Cost(e tokenUsageEntry) float64 {
+	return m.prices[e.Model].cost(e)
+}
+
+func (m *tokenMeter) saveLocked() error {
+	entries := make([]*tokenUsageEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key() < entries[j].key()
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal token usage: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to create token usage directory: %w", err)
+	}
+	tmpPath := m.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write token usage: %w", err)
+	}
+	if err := os.Rename(tmpPath, m.path); err != nil {
+		return fmt.Errorf("failed to replace token usage: %w", err)
+	}
+	return nil
+}
+
+// checkMonthlyTokens 检查用户本月的token用量是否已达到上限
+func checkMonthlyTokens(userID string) error {
+	limits, _ := quotas.Limits(userID)
+	if limits.MaxMonthlyTokens <= 0 {
+		return nil
+	}
+	if used := meter.MonthTotal(userID, time.Now()); used >= limits.MaxMonthlyTokens {
+		return &quotaExceededError{Resource: quotaResourceMonthlyTokens, Limit: limits.MaxMonthlyTokens, Used: used}
+	}
+	return nil
+}
+
+// modelUsageView 是按天汇总中单个模型的用量
+type modelUsageView struct {
+	Model            string  `json:"model"`
+	KnowledgeBase    string  `json:"knowledge_base"`
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// dayUsageView 是一天的用量汇总
+type dayUsageView struct {
+	Date        string           `json:"date"`
+	TotalTokens int64            `json:"total_tokens"`
+	Cost        float64          `json:"cost"`
+	Models      []modelUsageView `json:"models"`
+}
+
+// 按天汇总用户的模型token用量和估算费用，默认统计本月
+func getTokenUsage(ctx context.Context, c *app.RequestContext) {
+	userID := c.Query("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	now := time.Now()
+	from := c.DefaultQuery("from", now.Format("2006-01")+"-01")
+	to := c.DefaultQuery("to", now.Format(usageDateLayout))
+	for _, value := range []string{from, to} {
+		if _, err := time.Parse(usageDateLayout, value); err != nil {
+			c.JSON(consts.StatusBadRequest, utils.H{
+				"error": "Invalid date, use YYYY-MM-DD: " + value,
+			})
+			return
+		}
+	}
+
+	days := []*dayUsageView{}
+	var totalTokens int64
+	var totalCost float64
+	for _, e := range meter.Entries(userID, from, to) {
+		if len(days) == 0 || days[len(days)-1].Date != e.Date {
+			days = append(days, &dayUsageView{Date: e.Date})
+		}
+		day := days[len(days)-1]
+
+		cost := meter.Cost(e)
+		day.Models = append(day.Models, modelUsageView{
+			Model:            e.Model,
+			KnowledgeBase:    e.KnowledgeBase,
+			Calls:            e.Calls,
+			PromptTokens:     e.PromptTokens,
+			CompletionTokens: e.CompletionTokens,
+			TotalTokens:      e.TotalTokens,
+			Cost:             cost,
+		})
+		day.TotalTokens += e.TotalTokens
+		day.Cost += cost
+		totalTokens += e.TotalTokens
+		totalCost += cost
+	}
+
+	limits, _ := quotas.Limits(userID)
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id":      userID,
+		"from":         from,
+		"to":           to,
+		"days":         days,
+		"total_tokens": totalTokens,
+		"cost":         totalCost,
+		"month": utils.H{
+			"used":  meter.MonthTotal(userID, now),
+			"limit": limits.MaxMonthlyTokens,
+		},
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"viking_db_tool"
+)
+
+func TestTokenMeter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), tokenUsageFile)
+	prices := map[string]tokenPrice{
+		"llm":                {Prompt: 1, Completion: 2},
+		searchModelEmbedding: {Prompt: 0.5},
+		searchModelRerank:    {Prompt: 0.1},
+	}
+	m, err := newTokenMeter(path, prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := time.Date(2024, 3, 2, 10, 0, 0, 0, time.Local)
+	usage := &viking_db_tool.ModelTokenUsage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500}
+	for _, at := range []time.Time{day1, day1, day2} {
+		if err := m.Record("u1", "kb_u1", "llm", usage, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rerank := int64(2000)
+	search := &viking_db_tool.TotalTokenUsage{
+		EmbeddingUsage: &viking_db_tool.ModelTokenUsage{PromptTokens: 100, TotalTokens: 100},
+		RerankUsage:    &rerank,
+	}
+	if err := m.RecordSearch("u1", "kb_u1", search, day2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Record("u2", "kb_u2", "llm", usage, day1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载后用量保持不变
+	if m, err = newTokenMeter(path, prices); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := m.Entries("u1", "2024-03-01", "2024-03-01")
+	if len(entries) != 1 || entries[0].Calls != 2 || entries[0].TotalTokens != 3000 {
+		t.Fatalf("Entries = %+v, want one llm entry with 2 calls", entries)
+	}
+	if got := m.Cost(entries[0]); math.Abs(got-4) > 1e-9 {
+		t.Errorf("llm cost = %v, want 4", got)
+	}
+
+	if got := len(m.Entries("u1", "2024-03-02", "2024-03-02")); got != 3 {
+		t.Errorf("day2 has %d entries, want llm, embedding and rerank", got)
+	}
+	for _, e := range m.Entries("u1", "2024-03-02", "2024-03-02") {
+		if e.Model == searchModelRerank && math.Abs(m.Cost(e)-0.2) > 1e-9 {
+			t.Errorf("rerank cost = %v, want 0.2", m.Cost(e))
+		}
+	}
+
+	if got := m.MonthTotal("u1", day1); got != 1500*3+100+2000 {
+		t.Errorf("MonthTotal = %d", got)
+	}
+	if got := m.MonthTotal("u1", day1.AddDate(0, 1, 0)); got != 0 {
+		t.Errorf("MonthTotal for next month = %d, want 0", got)
+	}
+}
+
+func TestTokenMeterRecordStages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), tokenUsageFile)
+	m, err := newTokenMeter(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+
+	// 没有任何用量时不写文件
+	if err := m.RecordStages("u1", "kb_u1", searchStages(&viking_db_tool.TotalTokenUsage{}), at); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Stat = %v, want usage file not written", err)
+	}
+
+	// 一次请求的检索和生成用量一起记录
+	search := &viking_db_tool.TotalTokenUsage{
+		EmbeddingUsage: &viking_db_tool.ModelTokenUsage{PromptTokens: 100, TotalTokens: 100},
+		RewriteUsage:   &viking_db_tool.ModelTokenUsage{PromptTokens: 50, CompletionTokens: 10, TotalTokens: 60},
+	}
+	stages := append(searchStages(search), tokenStage{"llm", &viking_db_tool.ModelTokenUsage{PromptTokens: 1000, CompletionTokens: 500, TotalTokens: 1500}})
+	if err := m.RecordStages("u1", "kb_u1", stages, at); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = newTokenMeter(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.Entries("u1", "2024-03-01", "2024-03-01")); got != 3 {
+		t.Errorf("got %d entries, want embedding, rewrite and llm", got)
+	}
+	if got := m.MonthTotal("u1", at); got != 100+60+1500 {
+		t.Errorf("MonthTotal = %d", got)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// 指标以Prometheus文本格式从 /metrics 输出，计数器和直方图保存在进程内，重启后从零开始；
+// 文档数、处理队列和模型token用量在抓取时从元数据和计量表中统计
+
+// 请求耗时直方图的桶，单位为秒
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	httpRequests = newCounterVec("mkb_http_requests_total",
+		"HTTP requests handled, by route and status.", "method", "route", "status")
+	httpDuration = newHistogramVec("mkb_http_request_duration_seconds",
+		"HTTP request latency in seconds, by route.", durationBuckets, "method", "route")
+	outboundRequests = newCounterVec("mkb_outbound_requests_total",
+		"Requests to the object store (tos) and the knowledge base (kb), by operation and result.", "system", "operation", "result")
+	outboundDuration = newHistogramVec("mkb_outbound_request_duration_seconds",
+		"Latency of requests to the object store and the knowledge base in seconds, by operation.", durationBuckets, "system", "operation")
+	queryCacheRequests = newCounterVec("mkb_query_cache_requests_total",
+		"Query cache lookups for search and chat results, by result (hit or miss).", "kind", "result")
+)
+
+// 出站请求的系统标签
+const (
+	outboundSystemTOS = "tos"
+	outboundSystemKB  = "kb"
+)
+
+// counterVec 是按标签分组的计数器
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterSample
+}
+
+type counterSample struct {
+	labels []string
+	value  float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]*counterSample)}
+}
+
+// Inc 为指定标签值的计数器加一，标签值的顺序与创建时的标签名一致
+func (v *counterVec) Inc(labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	sample, ok := v.values[key]
+	if !ok {
+		sample = &counterSample{labels: labelValues}
+		v.values[key] = sample
+	}
+	sample.value++
+}
+
+func (v *counterVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, "counter")
+	for _, key := range sortedKeys(v.values) {
+		sample := v.values[key]
+		writeSample(w, v.name, v.labels, sample.labels, sample.value)
+	}
+}
+
+// histogramVec 是按标签分组的直方图
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramSample
+}
+
+type histogramSample struct {
+	labels []string
+	counts []uint64 // 每个桶的非累计计数
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramSample)}
+}
+
+// Observe 记录一次观测值
+func (v *histogramVec) Observe(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	sample, ok := v.values[key]
+	if !ok {
+		sample = &histogramSample{labels: labelValues, counts: make([]uint64, len(v.buckets))}
+		v.values[key] = sample
+	}
+	if i := sort.SearchFloat64s(v.buckets, value); i < len(v.buckets) {
+		sample.counts[i]++
+	}
+	sample.sum += value
+	sample.count++
+}
+
+func (v *histogramVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, "histogram")
+	bucketLabels := append(append([]string{}, v.labels...), "le")
+	for _, key := range sortedKeys(v.values) {
+		sample := v.values[key]
+		var cumulative uint64
+		for i, upper := range v.buckets {
+			cumulative += sample.counts[i]
+			writeSample(w, v.name+"_bucket", bucketLabels, append(append([]string{}, sample.labels...), formatFloat(upper)), float64(cumulative))
+		}
+		writeSample(w, v.name+"_bucket", bucketLabels, append(append([]string{}, sample.labels...), "+Inf"), float64(sample.count))
+		writeSample(w, v.name+"_sum", v.labels, sample.labels, sample.sum)
+		writeSample(w, v.name+"_count", v.labels, sample.labels, float64(sample.count))
+	}
+}
+
+// collectedSample 是抓取时统计出的一个指标值
+type collectedSample struct {
+	labels []string
+	value  float64
+}
+
+// writeCollected 输出抓取时统计的指标，metricType 为 gauge 或 counter
+func writeCollected(w io.Writer, name, help, metricType string, labels []string, samples []collectedSample) {
+	writeHeader(w, name, help, metricType)
+	for _, sample := range samples {
+		writeSample(w, name, labels, sample.labels, sample.value)
+	}
+}
+
+func writeHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, value float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, label := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escapeLabelValue(values[i]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(value))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metricsMiddleware 按路由统计请求数和耗时，未匹配到路由的请求归入 unmatched，避免标签数量无限增长
+func metricsMiddleware(ctx context.Context, c *app.RequestContext) {
+	start := time.Now()
+	c.Next(ctx)
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	method := string(c.Method())
+	httpRequests.Inc(method, route, strconv.Itoa(c.Response.StatusCode()))
+	httpDuration.Observe(time.Since(start).Seconds(), method, route)
+}
+
+// outboundHook 是 tos_tool 和 viking_db_tool 的请求观察者：请求前调用，返回的函数在请求结束时调用
+type outboundHook = func(ctx context.Context, op string) (context.Context, func(error))
+
+// outboundObserver 返回统计出站请求的观察者
+func outboundObserver(system string) outboundHook {
+	return func(ctx context.Context, op string) (context.Context, func(error)) {
+		start := time.Now()
+		return ctx, func(err error) {
+			result := "ok"
+			if err != nil {
+				result = "error"
+			}
+			outboundRequests.Inc(system, op, result)
+			outboundDuration.Observe(time.Since(start).Seconds(), system, op)
+		}
+	}
+}
+
+// documentStatusCounts 按处理状态统计所有未删除的文档。处理中的文档以状态监视器最近查询到的状态为准，
+// 其余文档按最近一次提交或处理是否失败计为 failed 或 completed
+func documentStatusCounts() map[string]int {
+	inFlight := statusWatcher.ProcessStatuses()
+	counts := make(map[string]int)
+	for _, userID := range registry.Users() {
+		for _, record := range registry.List(userID) {
+			status, tracked := inFlight[registryKey(userID, record.DocID)]
+			switch {
+			case tracked && status < 0:
+				counts["queued"]++
+			case tracked:
+				counts[docStatusKey(status)]++
+			case record.LastError != "":
+				counts["failed"]++
+			default:
+				counts["completed"]++
+			}
+		}
+	}
+	return counts
+}
+
+// writeMetrics 以Prometheus文本格式输出所有指标
+func writeMetrics(w io.Writer) {
+	httpRequests.write(w)
+	httpDuration.write(w)
+	outboundRequests.write(w)
+	outboundDuration.write(w)
+	queryCacheRequests.write(w)
+
+	writeCollected(w, "mkb_ingestion_queue_depth", "Documents submitted to the knowledge base that are still being processed.", "gauge",
+		nil, []collectedSample{{value: float64(len(statusWatcher.ProcessStatuses()))}})
+
+	counts := documentStatusCounts()
+	var statuses []collectedSample
+	for _, status := range sortedKeys(counts) {
+		statuses = append(statuses, collectedSample{labels: []string{status}, value: float64(counts[status])})
+	}
+	writeCollected(w, "mkb_documents", "Documents outside the trash, by processing status.", "gauge",
+		[]string{"process_status"}, statuses)
+
+	var tokens []collectedSample
+	for _, e := range meter.ModelTotals() {
+		tokens = append(tokens,
+			collectedSample{labels: []string{e.Model, "prompt"}, value: float64(e.PromptTokens)},
+			collectedSample{labels: []string{e.Model, "completion"}, value: float64(e.CompletionTokens)},
+			collectedSample{labels: []string{e.Model, "total"}, value: float64(e.TotalTokens)},
+		)
+	}
+	writeCollected(w, "mkb_model_tokens_total", "Model tokens consumed by chat and search, by model and token type.", "counter",
+		[]string{"model", "type"}, tokens)
+}
+
+// 以Prometheus文本格式输出指标
+func metricsHandler(ctx context.Context, c *app.RequestContext) {
+	var buf bytes.Buffer
+	writeMetrics(&buf)
+	c.Data(consts.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
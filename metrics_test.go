@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMetricsTextFormat(t *testing.T) {
+	requests := newCounterVec("test_requests_total", "Test requests.", "route", "status")
+	requests.Inc("/api/chat", "200")
+	requests.Inc("/api/chat", "200")
+	requests.Inc(`/api/"quoted"`, "500")
+
+	latency := newHistogramVec("test_duration_seconds", "Test latency.", []float64{0.1, 1}, "route")
+	latency.Observe(0.05, "/api/chat")
+	latency.Observe(0.5, "/api/chat")
+	latency.Observe(3, "/api/chat")
+
+	var buf bytes.Buffer
+	requests.write(&buf)
+	latency.write(&buf)
+
+	want := `# HELP test_requests_total Test requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/api/\"quoted\"",status="500"} 1
+test_requests_total{route="/api/chat",status="200"} 2
+# HELP test_duration_seconds Test latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/api/chat",le="0.1"} 1
+test_duration_seconds_bucket{route="/api/chat",le="1"} 2
+test_duration_seconds_bucket{route="/api/chat",le="+Inf"} 3
+test_duration_seconds_sum{route="/api/chat"} 3.55
+test_duration_seconds_count{route="/api/chat"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("metrics output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestOutboundObserver(t *testing.T) {
+	observe := outboundObserver(outboundSystemTOS)
+	_, done := observe(context.Background(), "TestOp")
+	done(nil)
+	_, done = observe(context.Background(), "TestOp")
+	done(errors.New("request failed"))
+
+	var buf bytes.Buffer
+	outboundRequests.write(&buf)
+	for _, line := range []string{
+		`mkb_outbound_requests_total{system="tos",operation="TestOp",result="ok"} 1`,
+		`mkb_outbound_requests_total{system="tos",operation="TestOp",result="error"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("missing %s in:\n%s", line, buf.String())
+		}
+	}
+}
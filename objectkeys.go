@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"tos_tool"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// 用户ID和文件名都会成为TOS对象键中的一级路径，所有对象键都应通过本文件中的函数构建
+const (
+	maxUserIDLength   = 64
+	maxFilenameLength = 255
+)
+
+// 用户的对象所在的顶级前缀
+var userObjectRoots = []string{"uploads", "versions", "processed", "trash"}
+
+// validateUserID 检查用户ID只占对象键中的一级路径，拒绝路径分隔符、控制字符和过长的用户ID，
+// 中文等其他字符照常接受，已有用户的数据不受影响
+func validateUserID(userID string) error {
+	if userID == "" {
+		return errors.New("User ID is required")
+	}
+	if !utf8.ValidString(userID) {
+		return errors.New("User ID is not valid UTF-8")
+	}
+	if utf8.RuneCountInString(userID) > maxUserIDLength {
+		return fmt.Errorf("User ID is longer than %d characters", maxUserIDLength)
+	}
+	if userID == "." || userID == ".." || strings.ContainsAny(userID, `/\`) {
+		return errors.New("User ID must not contain path separators")
+	}
+	for _, r := range userID {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return fmt.Errorf("User ID contains invalid character %U", r)
+		}
+	}
+	return nil
+}
+
+// validateFilename 检查文件名只占对象键中的一级路径，拒绝路径分隔符、控制字符和过长的文件名
+func validateFilename(filename string) error {
+	if filename == "" {
+		return errors.New("Filename is required")
+	}
+	if len(filename) > maxFilenameLength {
+		return fmt.Errorf("Filename is longer than %d bytes", maxFilenameLength)
+	}
+	if !utf8.ValidString(filename) {
+		return errors.New("Filename is not valid UTF-8")
+	}
+	if filename == "." || filename == ".." || strings.ContainsAny(filename, `/\`) {
+		return errors.New("Filename must not contain path separators")
+	}
+	for _, r := range filename {
+		// 不可见的格式字符（如 U+202E）会让文件名显示得与实际不同
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return fmt.Errorf("Filename contains invalid character %U", r)
+		}
+	}
+	if generateDocID(filename) == "" {
+		return errors.New("Filename must contain at least one ASCII letter or digit")
+	}
+	return nil
+}
+
+// docIDConflictError 表示上传的文件与另一个文件名生成了相同的文档ID
+type docIDConflictError struct {
+	DocID        string
+	ExistingName string
+}
+
+func (e *docIDConflictError) Error() string {
+	return fmt.Sprintf("File %q already uses document ID %s, rename the file and upload again", e.ExistingName, e.DocID)
+}
+
+// checkDocIDConflict 检查文档ID是否已被其他文件使用，包括回收站中的文档。
+// 不同文件名可能生成相同的docID（如 "a b.txt" 和 "ab.txt"），只有记录指向同一个对象时才是同一文件的新版本
+func checkDocIDConflict(userID, docID, objectKey string) error {
+	record, ok := registry.Get(userID, docID)
+	if !ok {
+		record, ok = registry.GetTrashed(userID, docID)
+	}
+	if ok && record.ObjectKey != objectKey {
+		return &docIDConflictError{DocID: docID, ExistingName: record.Name}
+	}
+	return nil
+}
+
+// validateDocID 检查文档ID，文档ID由 generateDocID 生成，只含字母、数字、_ 和 -
+func validateDocID(docID string) error {
+	if docID == "" || docIDPattern.MatchString(docID) {
+		return fmt.Errorf("Invalid document ID: %q", docID)
+	}
+	return nil
+}
+
+// userUploadPrefix 返回用户当前版本文件所在的前缀
+func userUploadPrefix(userID string) string {
+	return "uploads/" + userID + "/"
+}
+
+// uploadObjectKey 返回文件当前版本在TOS中的对象键
+func uploadObjectKey(userID, filename string) string {
+	return userUploadPrefix(userID) + filename
+}
+
+// versionObjectKey 返回文档某个版本在TOS中的对象键
+func versionObjectKey(userID, docID string, version int, filename string) string {
+	return fmt.Sprintf("versions/%s/%s/v%d/%s", userID, docID, version, filename)
+}
+
+// processedObjectKey 返回预处理副本在TOS中的对象键，与原文件分开存放，不出现在文件列表中
+func processedObjectKey(userID, filename, docType string) string {
+	key := fmt.Sprintf("processed/%s/%s", userID, filename)
+	if docType == "txt" && !strings.HasSuffix(strings.ToLower(filename), ".txt") {
+		key += ".txt"
+	}
+	return key
+}
+
+// trashObjectKey 返回文档放入回收站后在TOS中的对象键
+func trashObjectKey(userID, docID, filename string) string {
+	return fmt.Sprintf("trash/%s/%s/%s", userID, docID, filename)
+}
+
+// checkObjectKey 确认对象键属于该用户：位于用户自己的前缀下，且不含 . 或 .. 路径段。
+// 删除、复制和下载对象之前调用，防止记录被篡改时越权访问
+func checkObjectKey(userID, key string) error {
+	if err := validateUserID(userID); err != nil {
+		return err
+	}
+	if path.Clean(key) != key || strings.Contains(key, `\`) {
+		return fmt.Errorf("object key %q is not canonical", key)
+	}
+	for _, root := range userObjectRoots {
+		rest, ok := strings.CutPrefix(key, root+"/"+userID+"/")
+		if ok && rest != "" {
+			for _, part := range strings.Split(rest, "/") {
+				if part == "." || part == ".." {
+					return fmt.Errorf("object key %q is not canonical", key)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("object key %q does not belong to user %s", key, userID)
+}
+
+// requireUserID 校验请求中的用户ID，无效时写入 400 响应
+func requireUserID(c *app.RequestContext, userID string) bool {
+	if err := validateUserID(userID); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// requireFilename 校验请求中的文件名，无效时写入 400 响应
+func requireFilename(c *app.RequestContext, filename string) bool {
+	if err := validateFilename(filename); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// requireDocID 校验路径中的文档ID，无效时写入 400 响应
+func requireDocID(c *app.RequestContext, docID string) bool {
+	if err := validateDocID(docID); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// resolveUserFile 校验用户ID和文件名，返回用户 uploads/ 下该文件对应的文档记录。
+// 有记录时以记录为准核对归属；启用元数据记录之前上传的文件没有记录，确认对象存在后按文件名补一条。
+// 失败时写入错误响应
+func resolveUserFile(ctx context.Context, c *app.RequestContext, userID, filename string) (DocumentRecord, bool) {
+	if !requireUserID(c, userID) || !requireFilename(c, filename) {
+		return DocumentRecord{}, false
+	}
+
+	objectKey := uploadObjectKey(userID, filename)
+	docID := generateDocID(filename)
+	if record, ok := registry.Get(userID, docID); ok {
+		// 不同文件名可能生成相同的docID，只有记录指向同一个对象时才算匹配
+		if record.ObjectKey != objectKey {
+			c.JSON(consts.StatusNotFound, utils.H{
+				"error": "File not found",
+			})
+			return DocumentRecord{}, false
+		}
+		return record, true
+	}
+
+	object, err := tos_tool.StatObjectWithEnvConfig(ctx, objectKey)
+	if errors.Is(err, tos_tool.ErrObjectNotFound) {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "File not found",
+		})
+		return DocumentRecord{}, false
+	}
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to get file from TOS: " + err.Error(),
+		})
+		return DocumentRecord{}, false
+	}
+
+	return DocumentRecord{
+		DocID:     docID,
+		UserID:    userID,
+		Name:      filename,
+		DocType:   getDocTypeByExtension(filename),
+		ObjectKey: objectKey,
+		Source:    documentSourceUpload,
+		Size:      object.Size,
+		Version:   1,
+		CreatedAt: object.LastModified,
+		UpdatedAt: object.LastModified,
+	}, true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 对象键相关的攻击样例，同时作为模糊测试的种子
+var objectKeyAttacks = []struct {
+	userID   string
+	filename string
+}{
+	{"../other", "a.txt"},
+	{"..", "a.txt"},
+	{"user1/../user2", "a.txt"},
+	{"user1", "../user2/a.txt"},
+	{"user1", ".."},
+	{"user1", `..\..\a.txt`},
+	{"user1", "a.txt\x00.pdf"},
+	{"user1", "a\nb.txt"},
+	{"user1", "evil‮txt.exe"},
+	{"user1", strings.Repeat("a", maxFilenameLength+1)},
+	{strings.Repeat("u", maxUserIDLength+1), "a.txt"},
+	{`user1\..`, "a.txt"},
+	{"user\x001", "a.txt"},
+	{"user‮1", "a.txt"},
+	{"user1", "报告"},
+	{"user1", "\xff\xfe.txt"},
+}
+
+func TestValidateObjectKeyParts(t *testing.T) {
+	for _, tt := range objectKeyAttacks {
+		if validateUserID(tt.userID) == nil && validateFilename(tt.filename) == nil {
+			t.Errorf("user %q with file %q passed validation", tt.userID, tt.filename)
+		}
+	}
+
+	for _, tt := range []struct{ userID, filename string }{
+		{"user123", "example.txt"},
+		{"alice@example.com", "报告 2024.pdf"},
+		{"a_b-c.d", ".env"},
+		{"张三", "a.txt"},
+		{"a+b", "a.txt"},
+		{"user%2F..", "a.txt"},
+		{strings.Repeat("张", maxUserIDLength), "a.txt"},
+	} {
+		if err := validateUserID(tt.userID); err != nil {
+			t.Errorf("validateUserID(%q) = %v", tt.userID, err)
+		}
+		if err := validateFilename(tt.filename); err != nil {
+			t.Errorf("validateFilename(%q) = %v", tt.filename, err)
+		}
+	}
+}
+
+func TestCheckObjectKey(t *testing.T) {
+	valid := []string{
+		uploadObjectKey("u1", "a.txt"),
+		versionObjectKey("u1", "atxt", 3, "a.txt"),
+		processedObjectKey("u1", "a.pdf", "txt"),
+		trashObjectKey("u1", "atxt", "a.txt"),
+	}
+	for _, key := range valid {
+		if err := checkObjectKey("u1", key); err != nil {
+			t.Errorf("checkObjectKey(%q) = %v", key, err)
+		}
+		if err := checkObjectKey("u2", key); err == nil {
+			t.Errorf("checkObjectKey accepted %q for another user", key)
+		}
+	}
+
+	for _, key := range []string{
+		"uploads/u1/../u2/a.txt",
+		"uploads/u1/",
+		"uploads/u1",
+		"uploads/u10/a.txt",
+		"other/u1/a.txt",
+		"/uploads/u1/a.txt",
+		`uploads/u1/..\a.txt`,
+	} {
+		if err := checkObjectKey("u1", key); err == nil {
+			t.Errorf("checkObjectKey accepted %q", key)
+		}
+	}
+}
+
+// FuzzObjectKeys 检查通过校验的用户ID和文件名构建出的对象键始终位于该用户自己的前缀下
+func FuzzObjectKeys(f *testing.F) {
+	for _, tt := range objectKeyAttacks {
+		f.Add(tt.userID, tt.filename)
+	}
+	f.Add("user123", "example.txt")
+	f.Add("张三", "example.txt")
+	f.Add("user%2F..", "a.txt")
+
+	f.Fuzz(func(t *testing.T, userID, filename string) {
+		if validateUserID(userID) != nil || validateFilename(filename) != nil {
+			return
+		}
+
+		docID := generateDocID(filename)
+		if validateDocID(docID) != nil {
+			t.Fatalf("generateDocID(%q) = %q is not a valid document ID", filename, docID)
+		}
+
+		keys := map[string]int{
+			uploadObjectKey(userID, filename):            3,
+			versionObjectKey(userID, docID, 1, filename): 5,
+			processedObjectKey(userID, filename, "txt"):  3,
+			trashObjectKey(userID, docID, filename):      4,
+		}
+		for key, segments := range keys {
+			if path.Clean(key) != key {
+				t.Errorf("key %q is not canonical", key)
+			}
+			if got := len(strings.Split(key, "/")); got != segments {
+				t.Errorf("key %q has %d segments, want %d", key, got, segments)
+			}
+			if err := checkObjectKey(userID, key); err != nil {
+				t.Errorf("checkObjectKey(%q, %q) = %v", userID, key, err)
+			}
+		}
+	})
+}
+
+func TestCheckDocIDConflict(t *testing.T) {
+	saved := registry
+	defer func() { registry = saved }()
+	var err error
+	if registry, err = newDocumentRegistry(filepath.Join(t.TempDir(), registryFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	deletedAt := time.Now()
+	for _, rec := range []DocumentRecord{
+		{UserID: "u1", DocID: generateDocID("a b.txt"), Name: "a b.txt", ObjectKey: uploadObjectKey("u1", "a b.txt")},
+		{UserID: "u1", DocID: generateDocID("报告1.pdf"), Name: "报告1.pdf", ObjectKey: uploadObjectKey("u1", "报告1.pdf"), DeletedAt: &deletedAt},
+	} {
+		if err := registry.Put(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 同一文件再次上传是新版本，其他用户不受影响
+	for _, tt := range []struct{ userID, filename string }{{"u1", "a b.txt"}, {"u1", "报告1.pdf"}, {"u2", "ab.txt"}} {
+		if err := checkDocIDConflict(tt.userID, generateDocID(tt.filename), uploadObjectKey(tt.userID, tt.filename)); err != nil {
+			t.Errorf("checkDocIDConflict(%s, %s) = %v", tt.userID, tt.filename, err)
+		}
+	}
+
+	// 生成相同docID的不同文件名，包括与回收站中的文档冲突
+	for filename, existing := range map[string]string{"ab.txt": "a b.txt", "合同1.pdf": "报告1.pdf"} {
+		err := checkDocIDConflict("u1", generateDocID(filename), uploadObjectKey("u1", filename))
+		var conflict *docIDConflictError
+		if !errors.As(err, &conflict) || conflict.ExistingName != existing {
+			t.Errorf("checkDocIDConflict(%s) = %v, want conflict with %s", filename, err, existing)
+		}
+	}
+}
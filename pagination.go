@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// 列表接口支持的排序字段
+var listSortFields = map[string]bool{
+	"name":   true,
+	"size":   true,
+	"time":   true,
+	"status": true,
+}
+
+// listQuery 是列表接口的分页、排序和过滤参数
+type listQuery struct {
+	Offset   int
+	Limit    int
+	Sort     string          // name、size、time、status
+	Desc     bool            // order=desc 时倒序
+	Types    map[string]bool // type，文档类型，逗号分隔
+	Statuses map[string]bool // status，处理状态，逗号分隔
+	Name     string          // name，文件名包含的子串，不区分大小写
+}
+
+// NeedsStatus 返回是否需要文档的处理状态来过滤或排序
+func (q listQuery) NeedsStatus() bool {
+	return len(q.Statuses) > 0 || q.Sort == "status"
+}
+
+// listEntry 是参与过滤和排序的字段
+type listEntry struct {
+	Name    string
+	DocType string
+	Size    int64
+	Time    time.Time
+	Status  string
+}
+
+// listPage 是分页结果的元信息
+type listPage struct {
+	Total      int    `json:"total"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parseListQuery 解析列表接口的查询参数。cursor 优先于 offset
+func parseListQuery(c *app.RequestContext) (listQuery, error) {
+	q := listQuery{
+		Limit: defaultPageSize,
+		Sort:  strings.ToLower(c.DefaultQuery("sort", "name")),
+		Desc:  strings.EqualFold(c.Query("order"), "desc"),
+		Name:  strings.ToLower(c.Query("name")),
+	}
+
+	if !listSortFields[q.Sort] {
+		return q, fmt.Errorf("Invalid sort field %q, use name, size, time or status", q.Sort)
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("Invalid limit: %s", value)
+		}
+		q.Limit = min(limit, maxPageSize)
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		offset, err := decodeCursor(cursor)
+		if err != nil {
+			return q, fmt.Errorf("Invalid cursor")
+		}
+		q.Offset = offset
+	} else if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return q, fmt.Errorf("Invalid offset: %s", value)
+		}
+		q.Offset = offset
+	}
+
+	q.Types = queryList(c.Query("type"))
+	q.Statuses = queryList(c.Query("status"))
+	return q, nil
+}
+
+// queryList 解析逗号分隔的参数值为集合，值统一转为小写
+func queryList(value string) map[string]bool {
+	if value == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// encodeCursor 把偏移量编码为不透明的游标
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := strings.CutPrefix(string(data), "o:")
+	if !ok {
+		return 0, fmt.Errorf("malformed cursor")
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("malformed cursor")
+	}
+	return offset, nil
+}
+
+// applyListQuery 对列表过滤、排序并取出请求的一页，entry 返回每一项参与过滤和排序的字段
+func applyListQuery[T any](items []T, q listQuery, entry func(T) listEntry) ([]T, listPage) {
+	type keyed struct {
+		item  T
+		entry listEntry
+	}
+
+	var matched []keyed
+	for _, item := range items {
+		e := entry(item)
+		if len(q.Types) > 0 && !q.Types[e.DocType] {
+			continue
+		}
+		if len(q.Statuses) > 0 && !q.Statuses[e.Status] {
+			continue
+		}
+		if q.Name != "" && !strings.Contains(strings.ToLower(e.Name), q.Name) {
+			continue
+		}
+		matched = append(matched, keyed{item: item, entry: e})
+	}
+
+	// 按名称作为第二排序键，保证翻页时顺序稳定
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i].entry, matched[j].entry
+		if q.Desc {
+			a, b = b, a
+		}
+		switch q.Sort {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "time":
+			if !a.Time.Equal(b.Time) {
+				return a.Time.Before(b.Time)
+			}
+		case "status":
+			if a.Status != b.Status {
+				return a.Status < b.Status
+			}
+		}
+		return a.Name < b.Name
+	})
+
+	page := listPage{
+		Total:  len(matched),
+		Offset: q.Offset,
+		Limit:  q.Limit,
+	}
+	start := min(q.Offset, len(matched))
+	end := min(start+q.Limit, len(matched))
+	if end < len(matched) {
+		page.HasMore = true
+		page.NextCursor = encodeCursor(end)
+	}
+
+	result := make([]T, 0, end-start)
+	for _, k := range matched[start:end] {
+		result = append(result, k.item)
+	}
+	return result, page
+}
+
+// docStatusKey 把知识库的处理状态码转换为过滤和排序使用的状态名
+func docStatusKey(processStatus int) string {
+	switch processStatus {
+	case 0:
+		return "completed"
+	case docStatusFailed:
+		return "failed"
+	case 2, 3:
+		return "queued"
+	case docStatusDeleting:
+		return "deleting"
+	case 6:
+		return "processing"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyListQuery(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []listEntry{
+		{Name: "b.pdf", DocType: "pdf", Size: 30, Time: base.Add(2 * time.Hour), Status: "completed"},
+		{Name: "a.md", DocType: "markdown", Size: 10, Time: base.Add(3 * time.Hour), Status: "failed"},
+		{Name: "c.md", DocType: "markdown", Size: 20, Time: base.Add(1 * time.Hour), Status: "completed"},
+		{Name: "report.txt", DocType: "txt", Size: 40, Time: base, Status: "processing"},
+	}
+	identity := func(e listEntry) listEntry { return e }
+	names := func(entries []listEntry) string {
+		var s string
+		for _, e := range entries {
+			s += e.Name + " "
+		}
+		return s
+	}
+
+	tests := []struct {
+		name string
+		q    listQuery
+		want string
+	}{
+		{"name asc", listQuery{Limit: 10, Sort: "name"}, "a.md b.pdf c.md report.txt "},
+		{"size desc", listQuery{Limit: 10, Sort: "size", Desc: true}, "report.txt b.pdf c.md a.md "},
+		{"time", listQuery{Limit: 10, Sort: "time"}, "report.txt c.md b.pdf a.md "},
+		{"status then name", listQuery{Limit: 10, Sort: "status"}, "b.pdf c.md a.md report.txt "},
+		{"type filter", listQuery{Limit: 10, Sort: "name", Types: map[string]bool{"markdown": true}}, "a.md c.md "},
+		{"status filter", listQuery{Limit: 10, Sort: "name", Statuses: map[string]bool{"completed": true}}, "b.pdf c.md "},
+		{"name filter", listQuery{Limit: 10, Sort: "name", Name: "rep"}, "report.txt "},
+		{"offset past end", listQuery{Offset: 10, Limit: 10, Sort: "name"}, ""},
+	}
+	for _, tt := range tests {
+		got, _ := applyListQuery(items, tt.q, identity)
+		if names(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, names(got), tt.want)
+		}
+	}
+
+	// 按游标翻页直到最后一页
+	q := listQuery{Limit: 3, Sort: "name"}
+	first, page := applyListQuery(items, q, identity)
+	if names(first) != "a.md b.pdf c.md " || !page.HasMore || page.Total != 4 {
+		t.Fatalf("first page = %q, %+v", names(first), page)
+	}
+	offset, err := decodeCursor(page.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Offset = offset
+	second, page := applyListQuery(items, q, identity)
+	if names(second) != "report.txt " || page.HasMore || page.NextCursor != "" {
+		t.Errorf("second page = %q, %+v", names(second), page)
+	}
+
+	if _, err := decodeCursor("not-a-cursor"); err == nil {
+		t.Error("expected error for malformed cursor")
+	}
+}
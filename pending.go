@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const pendingWorkFile = "pending_work.json"
+
+// pendingIngest 是已保存到TOS、尚未写入知识库的文档版本。Record 为写入完成后应保存的记录
+type pendingIngest struct {
+	Record    DocumentRecord  `json:"record"`
+	Version   DocumentVersion `json:"version"`
+	StartedAt time.Time       `json:"started_at"`
+}
+
+// pendingWatch 是退出时仍在知识库中处理、需要继续跟踪状态的文档
+type pendingWatch struct {
+	UserID        string `json:"user_id"`
+	ResourceID    string `json:"resource_id"`
+	DocID         string `json:"doc_id"`
+	DocName       string `json:"doc_name"`
+	ProcessStatus int    `json:"process_status"`
+}
+
+// pendingWorkStore 以 JSON 文件持久化未完成的工作，服务启动时继续处理
+type pendingWorkStore struct {
+	mu   sync.Mutex
+	path string
+	work struct {
+		Ingests map[string]*pendingIngest `json:"ingests"` // 按 registryKey 索引
+		Watches []pendingWatch            `json:"watches,omitempty"`
+	}
+}
+
+// pending 是服务使用的全局未完成工作表，在 main 中初始化
+var pending *pendingWorkStore
+
+// newPendingWorkStore 从指定文件加载未完成的工作，文件不存在时创建空表
+func newPendingWorkStore(path string) (*pendingWorkStore, error) {
+	s := &pendingWorkStore{path: path}
+	s.work.Ingests = make(map[string]*pendingIngest)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending work: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.work); err != nil {
+		return nil, fmt.Errorf("failed to parse pending work: %w", err)
+	}
+	if s.work.Ingests == nil {
+		s.work.Ingests = make(map[string]*pendingIngest)
+	}
+	return s, nil
+}
+
+// BeginIngest 在写入知识库之前记录待完成的写入
+func (s *pendingWorkStore) BeginIngest(record DocumentRecord, v DocumentVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.work.Ingests[registryKey(record.UserID, record.DocID)] = &pendingIngest{
+		Record:    record,
+		Version:   v,
+		StartedAt: time.Now(),
+	}
+	return s.saveLocked()
+}
+
+// FinishIngest 在写入知识库并保存记录后删除待完成的写入
+func (s *pendingWorkStore) FinishIngest(userID, docID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := registryKey(userID, docID)
+	if _, ok := s.work.Ingests[key]; !ok {
+		return nil
+	}
+	delete(s.work.Ingests, key)
+	return s.saveLocked()
+}
+
+// Ingests 返回所有待完成的写入，按开始时间排序
+func (s *pendingWorkStore) Ingests() []pendingIngest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ingests := make([]pendingIngest, 0, len(s.work.Ingests))
+	for _, ingest := range s.work.Ingests {
+		ingests = append(ingests, *ingest)
+	}
+	sort.Slice(ingests, func(i, j int) bool {
+		return ingests[i].StartedAt.Before(ingests[j].StartedAt)
+	})
+	return ingests
+}
+
+// SaveWatches 保存退出时仍在跟踪的文档，覆盖之前保存的列表
+func (s *pendingWorkStore) SaveWatches(watches []pendingWatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.work.Watches = watches
+	return s.saveLocked()
+}
+
+// TakeWatches 取出上次退出时保存的跟踪文档并清空列表
+func (s *pendingWorkStore) TakeWatches() ([]pendingWatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watches := s.work.Watches
+	if len(watches) == 0 {
+		return nil, nil
+	}
+	s.work.Watches = nil
+	return watches, s.saveLocked()
+}
+
+func (s *pendingWorkStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.work, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal pending work: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create pending work directory: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write pending work: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace pending work: %w", err)
+	}
+	return nil
+}
+
+// indexAndSave 把已保存到TOS的版本写入知识库并保存记录。写入前记入未完成工作表，
+// 服务在写入完成前退出时，下次启动由 resumePendingWork 继续写入；写入失败时同样保存记录。
+// 新版本记入已保存的最新记录，不覆盖写入期间并发保存的字段，record 更新为保存后的记录
+func indexAndSave(ctx context.Context, resourceID string, record *DocumentRecord, v DocumentVersion, raw []byte, previous *DocumentVersion) (*preprocessResult, error) {
+	if err := pending.BeginIngest(*record, v); err != nil {
+		slog.ErrorContext(ctx, "Failed to record pending ingestion", "doc_id", record.DocID, "error", err)
+	}
+
+	attempt := indexVersion(ctx, resourceID, *record, v, raw, previous)
+	saved, ok, err := registry.Update(record.UserID, record.DocID, func(rec *DocumentRecord) {
+		applyNewVersion(rec, *record, v)
+		attempt.apply(rec)
+	})
+	if !ok && err == nil {
+		// 新文档
+		attempt.apply(record)
+		saved, err = *record, registry.Put(*record)
+	}
+	if err != nil {
+		// 记录没有保存时保留未完成的写入，下次启动重新写入
+		slog.ErrorContext(ctx, "Failed to save document record", "doc_id", record.DocID, "error", err)
+	} else {
+		*record = saved
+		if err := pending.FinishIngest(record.UserID, record.DocID); err != nil {
+			slog.ErrorContext(ctx, "Failed to clear pending ingestion", "doc_id", record.DocID, "error", err)
+		}
+	}
+	return attempt.processed, attempt.err
+}
+
+// applyNewVersion 把 src 中新生效的版本 v 记入已保存的记录 rec，保留 rec 中并发写入的其他字段，
+// 如同时保存的其他版本、提交次数和token数。从回收站中恢复的文档同时清除删除标记
+func applyNewVersion(rec *DocumentRecord, src DocumentRecord, v DocumentVersion) {
+	if _, ok := rec.FindVersion(v.Version); !ok {
+		rec.Versions = append(rec.Versions, v)
+	}
+	rec.Version = v.Version
+	rec.Size = v.Size
+	rec.DocType = src.DocType
+	rec.Source = src.Source
+	rec.Metadata = src.Metadata
+	rec.TrashKey = src.TrashKey
+	rec.DeletedAt = src.DeletedAt
+	rec.UpdatedAt = src.UpdatedAt
+}
+
+// resumePendingWork 继续上次退出时未完成的工作：重新跟踪处理中的文档，把已保存到TOS但未写入知识库的版本写入知识库
+func resumePendingWork(ctx context.Context) {
+	watches, err := pending.TakeWatches()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to clear pending watches", "error", err)
+	}
+	for _, watch := range watches {
+		statusWatcher.track(watch.UserID, watch.ResourceID, watch.DocID, watch.DocName, watch.ProcessStatus)
+	}
+
+	for _, ingest := range pending.Ingests() {
+		if ctx.Err() != nil {
+			return
+		}
+		record := ingest.Record
+		resourceID, err := ensureKnowledgeBase(ctx, record.UserID)
+		if err != nil {
+			// 知识库不可用时保留剩余的工作，下次启动再继续
+			slog.ErrorContext(ctx, "Failed to resume pending ingestion", "user_id", record.UserID, "doc_id", record.DocID, "error", err)
+			return
+		}
+		// 退出前可能已经提交到知识库，先删除再重新写入，写入失败时恢复退出前已保存的版本；
+		// 写入失败的原因保存在记录中，可以通过重新处理接口重试
+		previous := ingest.Version
+		if saved, ok := registry.Get(record.UserID, record.DocID); ok {
+			previous = saved.ActiveVersion()
+		}
+		if _, err := indexAndSave(ctx, resourceID, &record, ingest.Version, nil, &previous); err != nil {
+			slog.ErrorContext(ctx, "Failed to resume pending ingestion", "user_id", record.UserID, "doc_id", record.DocID, "version", ingest.Version.Version, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Resumed pending ingestion", "user_id", record.UserID, "doc_id", record.DocID, "version", ingest.Version.Version)
+	}
+}
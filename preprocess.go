@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"preprocess_tool"
+	"tos_tool"
+)
+
+// preprocessPipeline 在文档写入知识库前运行，为nil时不做预处理
+var preprocessPipeline *preprocess_tool.Pipeline
+
+// newPreprocessPipeline 根据配置构建预处理流水线：文本抽取、编码与空白规范化、敏感信息脱敏
+func newPreprocessPipeline(c serverConfig) (*preprocess_tool.Pipeline, error) {
+	if !c.PreprocessEnabled {
+		return nil, nil
+	}
+
+	rules, err := preprocess_tool.SelectRedactionRules(c.RedactRules)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.CustomRedactRules != "" {
+		var configs []preprocess_tool.RedactionRuleConfig
+		if err := json.Unmarshal([]byte(c.CustomRedactRules), &configs); err != nil {
+			return nil, fmt.Errorf("invalid MKB_REDACT_CUSTOM: %w", err)
+		}
+		custom, err := preprocess_tool.CompileRedactionRules(configs)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, custom...)
+	}
+
+	return preprocess_tool.DefaultPipeline(rules), nil
+}
+
+// shouldPreprocess 判断文档是否需要经过预处理流水线
+func shouldPreprocess(docType string) bool {
+	return preprocessPipeline != nil && preprocess_tool.Supports(docType)
+}
+
+// preprocessResult 描述预处理后保存的文档副本
+type preprocessResult struct {
+	ObjectKey  string         `json:"object_key"`
+	DocType    string         `json:"doc_type"`
+	Stages     []string       `json:"stages"`
+	Redactions map[string]int `json:"redactions,omitempty"`
+
+	Text string `json:"-"`
+}
+
+// preprocessDocument 对文档运行预处理流水线，并把处理后的文本保存到TOS
+func preprocessDocument(ctx context.Context, userID, filename, docType string, raw []byte) (*preprocessResult, error) {
+	doc := &preprocess_tool.Document{
+		Name:    filename,
+		DocType: docType,
+		Raw:     raw,
+	}
+	if err := preprocessPipeline.Run(doc); err != nil {
+		return nil, err
+	}
+
+	objectKey := processedObjectKey(userID, filename, doc.OutputDocType)
+	if err := tos_tool.UploadContentWithEnvConfig(ctx, []byte(doc.Text), objectKey); err != nil {
+		return nil, fmt.Errorf("failed to upload processed document to TOS: %w", err)
+	}
+
+	if len(doc.Redactions) > 0 {
+		slog.InfoContext(ctx, "Redacted document", "user_id", userID, "filename", filename, "redactions", doc.Redactions)
+	}
+
+	return &preprocessResult{
+		ObjectKey:  objectKey,
+		DocType:    doc.OutputDocType,
+		Stages:     doc.Applied,
+		Redactions: doc.Redactions,
+		Text:       doc.Text,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package preprocess_tool
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDocxXMLSize bounds the size of word/document.xml to guard against zip bombs
+const maxDocxXMLSize = 64 << 20
+
+// ExtractStage turns the raw file content into text
+type ExtractStage struct{}
+
+// Name returns the stage name
+func (ExtractStage) Name() string { return "extract" }
+
+// Process extracts text from txt, markdown, csv, jsonl and docx files
+func (ExtractStage) Process(doc *Document) error {
+	docType := strings.ToLower(doc.DocType)
+	doc.OutputDocType = outputDocTypes[docType]
+
+	if docType == "docx" {
+		text, err := ExtractDocxText(doc.Raw)
+		if err != nil {
+			return err
+		}
+		doc.Text = text
+		return nil
+	}
+
+	// Plain text formats are kept byte-for-byte here, encoding is fixed by the normalize stage
+	doc.Text = string(doc.Raw)
+	return nil
+}
+
+// ExtractDocxText extracts paragraph text from a .docx file
+func ExtractDocxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid docx file: %w", err)
+	}
+
+	var documentXML *zip.File
+	for _, f := range zr.File {
+		if f.Name == "word/document.xml" {
+			documentXML = f
+			break
+		}
+	}
+	if documentXML == nil {
+		return "", fmt.Errorf("invalid docx file: word/document.xml not found")
+	}
+
+	rc, err := documentXML.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open word/document.xml: %w", err)
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxDocxXMLSize))
+	var builder strings.Builder
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse word/document.xml: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				builder.WriteString("\t")
+			case "br", "cr":
+				builder.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				builder.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				builder.Write(t)
+			}
+		}
+	}
+
+	return builder.String(), nil
+}
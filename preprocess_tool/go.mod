@@ -0,0 +1,5 @@
+module preprocess_tool
+
+go 1.23.0
+
+require golang.org/x/text v0.14.0
@@ -0,0 +1,58 @@
+package preprocess_tool
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+var (
+	trailingSpacePattern = regexp.MustCompile(`[ \t]+\n`)
+	blankLinesPattern    = regexp.MustCompile(`\n{3,}`)
+)
+
+// NormalizeStage fixes encoding and whitespace of the extracted text
+type NormalizeStage struct{}
+
+// Name returns the stage name
+func (NormalizeStage) Name() string { return "normalize" }
+
+// Process converts GBK text to UTF-8, strips the BOM and normalizes line endings.
+// For unstructured text it also trims trailing spaces and collapses runs of blank lines;
+// csv and jsonl keep their whitespace so field values are not altered.
+func (NormalizeStage) Process(doc *Document) error {
+	text, err := DecodeToUTF8([]byte(doc.Text))
+	if err != nil {
+		return err
+	}
+
+	text = strings.TrimPrefix(text, "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	if doc.OutputDocType != "csv" && doc.OutputDocType != "jsonl" {
+		text = strings.ReplaceAll(text, "\u00a0", " ")
+		text = trailingSpacePattern.ReplaceAllString(text, "\n")
+		text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+		text = strings.TrimSpace(text) + "\n"
+	}
+
+	doc.Text = text
+	return nil
+}
+
+// DecodeToUTF8 returns data as UTF-8, decoding it as GB18030 (a superset of GBK) when it is not valid UTF-8
+func DecodeToUTF8(data []byte) (string, error) {
+	if utf8.Valid(data) {
+		return string(data), nil
+	}
+
+	decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode text as GBK: %w", err)
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,79 @@
+package preprocess_tool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Document is the unit of work passed through a Pipeline
+type Document struct {
+	Name    string // original file name
+	DocType string // knowledge base doc_type of the original file, e.g. txt, markdown, docx
+	Raw     []byte // original file content
+
+	// Text is filled in by the extract stage and rewritten by later stages
+	Text string
+	// OutputDocType is the doc_type the processed Text should be indexed as
+	OutputDocType string
+	// Redactions counts how many matches each redaction rule replaced
+	Redactions map[string]int
+	// Applied lists the names of the stages that ran, in order
+	Applied []string
+}
+
+// Stage is a single step of the pre-processing pipeline
+type Stage interface {
+	Name() string
+	Process(doc *Document) error
+}
+
+// Pipeline runs its stages in order over a document
+type Pipeline struct {
+	Stages []Stage
+}
+
+// NewPipeline creates a pipeline from the given stages
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{Stages: stages}
+}
+
+// DefaultPipeline extracts text, normalizes it and redacts PII using the given rules
+func DefaultPipeline(rules []RedactionRule) *Pipeline {
+	return NewPipeline(
+		ExtractStage{},
+		NormalizeStage{},
+		NewRedactStage(rules),
+	)
+}
+
+// Supports reports whether the pipeline knows how to extract text from the doc type
+func Supports(docType string) bool {
+	_, ok := outputDocTypes[strings.ToLower(docType)]
+	return ok
+}
+
+// outputDocTypes maps supported input doc types to the doc type of their processed text
+var outputDocTypes = map[string]string{
+	"txt":      "txt",
+	"markdown": "markdown",
+	"csv":      "csv",
+	"jsonl":    "jsonl",
+	"docx":     "txt",
+}
+
+// Run applies every stage to the document, stopping at the first error
+func (p *Pipeline) Run(doc *Document) error {
+	if !Supports(doc.DocType) {
+		return fmt.Errorf("unsupported doc type: %s", doc.DocType)
+	}
+	if doc.Redactions == nil {
+		doc.Redactions = make(map[string]int)
+	}
+	for _, stage := range p.Stages {
+		if err := stage.Process(doc); err != nil {
+			return fmt.Errorf("%s stage failed: %w", stage.Name(), err)
+		}
+		doc.Applied = append(doc.Applied, stage.Name())
+	}
+	return nil
+}
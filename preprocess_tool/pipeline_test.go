@@ -0,0 +1,102 @@
+package preprocess_tool
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestDefaultPipelineRedactsPII(t *testing.T) {
+	raw := "联系人：张三\r\n身份证：110101199003071234，手机 13812345678\r\n邮箱 zhangsan@example.com   \r\n\r\n\r\n\r\n订单号 2099013912345678000 不是手机号\r\n"
+	doc := &Document{Name: "contact.txt", DocType: "txt", Raw: []byte(raw)}
+
+	if err := DefaultPipeline(DefaultRedactionRules()).Run(doc); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	for _, leaked := range []string{"110101199003071234", "13812345678", "zhangsan@example.com"} {
+		if strings.Contains(doc.Text, leaked) {
+			t.Errorf("text still contains %q:\n%s", leaked, doc.Text)
+		}
+	}
+	if !strings.Contains(doc.Text, "2099013912345678000") {
+		t.Errorf("order number should not be redacted:\n%s", doc.Text)
+	}
+	if strings.Contains(doc.Text, "\r") || strings.Contains(doc.Text, "\n\n\n") {
+		t.Errorf("whitespace not normalized: %q", doc.Text)
+	}
+	for _, rule := range []string{RuleIDCard, RulePhone, RuleEmail} {
+		if doc.Redactions[rule] != 1 {
+			t.Errorf("Redactions[%s] = %d, want 1", rule, doc.Redactions[rule])
+		}
+	}
+	if doc.OutputDocType != "txt" {
+		t.Errorf("OutputDocType = %q, want txt", doc.OutputDocType)
+	}
+}
+
+func TestNormalizeDecodesGBK(t *testing.T) {
+	gbk, err := simplifiedchinese.GBK.NewEncoder().String("知识库文档")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := &Document{Name: "gbk.csv", DocType: "csv", Raw: []byte(gbk)}
+	if err := NewPipeline(ExtractStage{}, NormalizeStage{}).Run(doc); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if doc.Text != "知识库文档" {
+		t.Errorf("Text = %q, want 知识库文档", doc.Text)
+	}
+}
+
+func TestExtractDocxText(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("word/document.xml")
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>第一段</w:t></w:r><w:r><w:tab/><w:t xml:space="preserve">续写</w:t></w:r></w:p>
+<w:p><w:r><w:t>电话 13900001111</w:t></w:r></w:p>
+</w:body></w:document>`))
+	zw.Close()
+
+	doc := &Document{Name: "a.docx", DocType: "docx", Raw: buf.Bytes()}
+	if err := DefaultPipeline(DefaultRedactionRules()).Run(doc); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := "第一段\t续写\n电话 [手机号已脱敏]\n"
+	if doc.Text != want {
+		t.Errorf("Text = %q, want %q", doc.Text, want)
+	}
+	if doc.OutputDocType != "txt" {
+		t.Errorf("OutputDocType = %q, want txt", doc.OutputDocType)
+	}
+}
+
+func TestCustomRedactionRules(t *testing.T) {
+	rules, err := CompileRedactionRules([]RedactionRuleConfig{{Name: "qq", Pattern: `QQ[:：]?\d{5,11}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, count := rules[0].Apply("我的QQ：123456789")
+	if count != 1 || text != "我的[qq已脱敏]" {
+		t.Errorf("Apply = %q, %d", text, count)
+	}
+
+	if _, err := SelectRedactionRules([]string{"phone", "unknown"}); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+}
+
+func TestUnsupportedDocType(t *testing.T) {
+	if Supports("pdf") {
+		t.Error("pdf should not be supported")
+	}
+	if err := DefaultPipeline(nil).Run(&Document{DocType: "pdf"}); err == nil {
+		t.Error("expected error for unsupported doc type")
+	}
+}
@@ -0,0 +1,152 @@
+package preprocess_tool
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Names of the built-in redaction rules
+const (
+	RuleIDCard = "id_card"
+	RulePhone  = "phone"
+	RuleEmail  = "email"
+)
+
+// RedactionRule replaces every match of Pattern with Replacement
+type RedactionRule struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Replacement string
+	// DigitBoundary skips matches that are directly preceded or followed by a digit,
+	// so a phone number pattern does not fire inside a longer number
+	DigitBoundary bool
+}
+
+// RedactionRuleConfig is the serializable form of a custom redaction rule
+type RedactionRuleConfig struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// DefaultRedactionRules returns the built-in rules for Chinese ID numbers, mobile phone numbers and emails
+func DefaultRedactionRules() []RedactionRule {
+	return []RedactionRule{
+		{
+			Name:          RuleIDCard,
+			Pattern:       regexp.MustCompile(`[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]`),
+			Replacement:   "[身份证号已脱敏]",
+			DigitBoundary: true,
+		},
+		{
+			Name:          RulePhone,
+			Pattern:       regexp.MustCompile(`(?:\+?86[- ]?)?1[3-9]\d{9}`),
+			Replacement:   "[手机号已脱敏]",
+			DigitBoundary: true,
+		},
+		{
+			Name:        RuleEmail,
+			Pattern:     regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+			Replacement: "[邮箱已脱敏]",
+		},
+	}
+}
+
+// SelectRedactionRules returns the built-in rules with the given names, in built-in order
+func SelectRedactionRules(names []string) ([]RedactionRule, error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[strings.TrimSpace(name)] = true
+	}
+
+	var rules []RedactionRule
+	for _, rule := range DefaultRedactionRules() {
+		if wanted[rule.Name] {
+			rules = append(rules, rule)
+			delete(wanted, rule.Name)
+		}
+	}
+	for name := range wanted {
+		if name != "" {
+			return nil, fmt.Errorf("unknown redaction rule: %s", name)
+		}
+	}
+	return rules, nil
+}
+
+// CompileRedactionRules compiles custom rules, defaulting the replacement to [<name>已脱敏]
+func CompileRedactionRules(configs []RedactionRuleConfig) ([]RedactionRule, error) {
+	rules := make([]RedactionRule, 0, len(configs))
+	for _, config := range configs {
+		if config.Name == "" || config.Pattern == "" {
+			return nil, fmt.Errorf("redaction rule requires name and pattern")
+		}
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for rule %s: %w", config.Name, err)
+		}
+		replacement := config.Replacement
+		if replacement == "" {
+			replacement = "[" + config.Name + "已脱敏]"
+		}
+		rules = append(rules, RedactionRule{
+			Name:        config.Name,
+			Pattern:     pattern,
+			Replacement: replacement,
+		})
+	}
+	return rules, nil
+}
+
+// Apply redacts every match in text and returns the result with the number of replacements
+func (r RedactionRule) Apply(text string) (string, int) {
+	locs := r.Pattern.FindAllStringIndex(text, -1)
+	if len(locs) == 0 {
+		return text, 0
+	}
+
+	var builder strings.Builder
+	last, count := 0, 0
+	for _, loc := range locs {
+		start, end := loc[0], loc[1]
+		if r.DigitBoundary && (isDigitAt(text, start-1) || isDigitAt(text, end)) {
+			continue
+		}
+		builder.WriteString(text[last:start])
+		builder.WriteString(r.Replacement)
+		last = end
+		count++
+	}
+	builder.WriteString(text[last:])
+	return builder.String(), count
+}
+
+func isDigitAt(text string, i int) bool {
+	return i >= 0 && i < len(text) && text[i] >= '0' && text[i] <= '9'
+}
+
+// RedactStage replaces PII in the text according to its rules
+type RedactStage struct {
+	Rules []RedactionRule
+}
+
+// NewRedactStage creates a redact stage with the given rules
+func NewRedactStage(rules []RedactionRule) *RedactStage {
+	return &RedactStage{Rules: rules}
+}
+
+// Name returns the stage name
+func (s *RedactStage) Name() string { return "redact" }
+
+// Process applies each rule in order and records the number of replacements per rule
+func (s *RedactStage) Process(doc *Document) error {
+	for _, rule := range s.Rules {
+		text, count := rule.Apply(doc.Text)
+		doc.Text = text
+		if count > 0 {
+			doc.Redactions[rule.Name] += count
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// 查询缓存的存储方式
+const (
+	queryCacheOff    = "off"
+	queryCacheMemory = "memory"
+	queryCacheDisk   = "disk"
+)
+
+const (
+	queryCacheDir    = "query_cache"
+	kbVersionsFile   = "kb_versions.json"
+	queryCacheSearch = "search"
+	queryCacheChat   = "chat"
+)
+
+// cacheBackend 是查询缓存的存储，过期的条目视为不存在
+type cacheBackend interface {
+	Get(key string, now time.Time) ([]byte, bool)
+	Set(key string, value []byte, expires time.Time)
+}
+
+// lruCache 是容量固定的内存缓存，超出容量时淘汰最久未使用的条目
+type lruCache struct {
+	capacity int
+
+	mu    sync.Mutex
+	order *list.List // 最近使用的在前
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{capacity: capacity, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) Get(key string, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *lruCache) Set(key string, value []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value = &lruEntry{key: key, value: value, expires: expires}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// diskCache 把每个条目保存为目录中的一个文件，服务重启后仍然有效。
+// 条目数超出容量时按修改时间删除最旧的条目
+type diskCache struct {
+	dir      string
+	capacity int
+
+	mu    sync.Mutex
+	count int
+}
+
+type diskEntry struct {
+	Expires time.Time `json:"expires"`
+	Value   []byte    `json:"value"`
+}
+
+func newDiskCache(dir string, capacity int) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create query cache directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query cache directory: %w", err)
+	}
+	return &diskCache{dir: dir, capacity: capacity, count: len(entries)}, nil
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *diskCache) Get(key string, now time.Time) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry diskEntry
+	if err := json.Unmarshal(data, &entry); err != nil || !now.Before(entry.Expires) {
+		c.remove(key)
+		return nil, false
+	}
+	return entry.Value, true
+}
+
+func (c *diskCache) Set(key string, value []byte, expires time.Time) {
+	data, err := json.Marshal(diskEntry{Expires: expires, Value: value})
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(key)
+	_, statErr := os.Stat(path)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		slog.Warn("Failed to write query cache entry", "error", err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		slog.Warn("Failed to write query cache entry", "error", err)
+		return
+	}
+	if os.IsNotExist(statErr) {
+		c.count++
+	}
+	if c.count > c.capacity {
+		c.pruneLocked()
+	}
+}
+
+func (c *diskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if os.Remove(c.path(key)) == nil {
+		c.count--
+	}
+}
+
+// pruneLocked 删除最旧的条目，直到条目数降到容量的九成，避免每次写入都清理
+func (c *diskCache) pruneLocked() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type file struct {
+		name    string
+		modTime time.Time
+	}
+	files := make([]file, 0, len(entries))
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			files = append(files, file{name: entry.Name(), modTime: info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	target := c.capacity * 9 / 10
+	removed := 0
+	for len(files)-removed > target {
+		os.Remove(filepath.Join(c.dir, files[removed].name))
+		removed++
+	}
+	c.count = len(files) - removed
+}
+
+// kbVersionStore 记录每个知识库的内容版本，知识库中的文档增删或处理完成时加一，
+// 缓存键包含版本号，旧版本的缓存条目不再命中，由容量和有效期自然淘汰。
+// path 为空时只保存在内存中，使用磁盘缓存时需要持久化，避免重启后命中旧条目
+type kbVersionStore struct {
+	mu       sync.Mutex
+	path     string
+	versions map[string]int64
+}
+
+func newKBVersionStore(path string) (*kbVersionStore, error) {
+	s := &kbVersionStore{path: path, versions: make(map[string]int64)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read knowledge base versions: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.versions); err != nil {
+		return nil, fmt.Errorf("failed to parse knowledge base versions: %w", err)
+	}
+	return s, nil
+}
+
+// Version 返回知识库当前的内容版本
+func (s *kbVersionStore) Version(kb string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versions[kb]
+}
+
+// Bump 把知识库的内容版本加一
+func (s *kbVersionStore) Bump(kb string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.versions[kb]++
+	if s.path == "" {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+func (s *kbVersionStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.versions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal knowledge base versions: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create knowledge base versions directory: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write knowledge base versions: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace knowledge base versions: %w", err)
+	}
+	return nil
+}
+
+// queryResultCache 缓存检索和对话的结果，键由知识库、知识库内容版本、规范化的问题和请求参数计算
+type queryResultCache struct {
+	backend  cacheBackend // 为空表示关闭缓存
+	ttl      time.Duration
+	versions *kbVersionStore
+}
+
+// queryCache 是服务使用的全局查询缓存，在 main 中初始化
+var queryCache *queryResultCache
+
+// newQueryResultCache 按配置创建查询缓存，磁盘缓存和知识库版本保存在 dir 下
+func newQueryResultCache(mode string, capacity int, ttl time.Duration, dir string) (*queryResultCache, error) {
+	var backend cacheBackend
+	versionsPath := ""
+	switch strings.ToLower(mode) {
+	case queryCacheOff:
+	case queryCacheMemory, "":
+		backend = newLRUCache(capacity)
+	case queryCacheDisk:
+		disk, err := newDiskCache(filepath.Join(dir, queryCacheDir), capacity)
+		if err != nil {
+			return nil, err
+		}
+		backend = disk
+		versionsPath = filepath.Join(dir, kbVersionsFile)
+	default:
+		return nil, fmt.Errorf("invalid MKB_QUERY_CACHE %q, use memory, disk or off", mode)
+	}
+	if backend != nil && (capacity <= 0 || ttl <= 0) {
+		return nil, fmt.Errorf("query cache size and TTL must be positive")
+	}
+
+	versions, err := newKBVersionStore(versionsPath)
+	if err != nil {
+		return nil, err
+	}
+	return &queryResultCache{backend: backend, ttl: ttl, versions: versions}, nil
+}
+
+// normalizeQuery 规范化问题：去掉首尾空白，连续空白合并为一个空格，英文字母转为小写
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(query, unicode.IsSpace), " "))
+}
+
+// Key 计算缓存键，params 为影响结果的请求参数，需要能序列化为JSON
+func (q *queryResultCache) Key(kind, kb string, params any) (string, error) {
+	if q == nil || q.backend == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00", kind, kb, q.versions.Version(kb))
+	h.Write(data)
+	return kind + "-" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Get 读取缓存的结果并统计命中率，命中时把结果解析到 v
+func (q *queryResultCache) Get(kind, key string, v any) bool {
+	if q == nil || q.backend == nil {
+		return false
+	}
+	data, ok := q.backend.Get(key, time.Now())
+	if ok && json.Unmarshal(data, v) == nil {
+		queryCacheRequests.Inc(kind, "hit")
+		return true
+	}
+	queryCacheRequests.Inc(kind, "miss")
+	return false
+}
+
+// Set 缓存结果
+func (q *queryResultCache) Set(key string, v any) {
+	if q == nil || q.backend == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	q.backend.Set(key, data, time.Now().Add(q.ttl))
+}
+
+// Invalidate 在用户知识库中的文档增删或处理完成时调用，使该知识库的缓存失效
+func (q *queryResultCache) Invalidate(ctx context.Context, userID string) {
+	if q == nil {
+		return
+	}
+	if err := q.versions.Bump(knowledgeBaseName(userID)); err != nil {
+		slog.ErrorContext(ctx, "Failed to save knowledge base version", "user_id", userID, "error", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache(2)
+	now := time.Now()
+	cache.Set("a", []byte("1"), now.Add(time.Minute))
+	cache.Set("b", []byte("2"), now.Add(time.Minute))
+	cache.Get("a", now) // a 最近使用过，容量满时淘汰 b
+	cache.Set("c", []byte("3"), now.Add(time.Minute))
+
+	if _, ok := cache.Get("b", now); ok {
+		t.Fatal("b should have been evicted")
+	}
+	if got, ok := cache.Get("a", now); !ok || string(got) != "1" {
+		t.Fatalf("Get(a) = %q, %v", got, ok)
+	}
+	if _, ok := cache.Get("c", now.Add(time.Minute)); ok {
+		t.Fatal("c should have expired")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache.Set("kept", []byte(`{"answer":"ok"}`), now.Add(time.Hour))
+	cache.Set("expired", []byte("x"), now.Add(-time.Second))
+
+	// 重新打开后仍能读到未过期的条目
+	reopened, err := newDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reopened.Get("kept", now); !ok || string(got) != `{"answer":"ok"}` {
+		t.Fatalf("Get(kept) = %q, %v", got, ok)
+	}
+	if _, ok := reopened.Get("expired", now); ok {
+		t.Fatal("expired entry should not be returned")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "expired.json")); !os.IsNotExist(err) {
+		t.Fatalf("expired entry should be removed, stat error = %v", err)
+	}
+
+	// 超出容量时清理最旧的条目
+	for i := 0; i < 20; i++ {
+		reopened.Set(string(rune('a'+i)), []byte("x"), now.Add(time.Hour))
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) > 10 {
+		t.Fatalf("disk cache holds %d entries, want at most 10", len(entries))
+	}
+}
+
+func TestQueryResultCacheInvalidate(t *testing.T) {
+	cache, err := newQueryResultCache(queryCacheMemory, 10, time.Minute, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]any{"query": normalizeQuery("  What is   MKB?\n"), "limit": 5}
+	key, err := cache.Key(queryCacheSearch, knowledgeBaseName("u1"), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _ := cache.Key(queryCacheSearch, knowledgeBaseName("u1"), map[string]any{"query": normalizeQuery("what is mkb?"), "limit": 5})
+	if key != same {
+		t.Fatal("questions differing only in case and whitespace should share a cache key")
+	}
+	other, _ := cache.Key(queryCacheSearch, knowledgeBaseName("u2"), params)
+	if key == other {
+		t.Fatal("different knowledge bases should not share a cache key")
+	}
+
+	cache.Set(key, map[string]string{"answer": "cached"})
+	var got map[string]string
+	if !cache.Get(queryCacheSearch, key, &got) || got["answer"] != "cached" {
+		t.Fatalf("Get = %v, want cached answer", got)
+	}
+
+	// 知识库内容变化后旧的结果不再命中
+	cache.Invalidate(context.Background(), "u1")
+	newKey, _ := cache.Key(queryCacheSearch, knowledgeBaseName("u1"), params)
+	if newKey == key || cache.Get(queryCacheSearch, newKey, &got) {
+		t.Fatal("cache should miss after the knowledge base changed")
+	}
+	if otherAfter, _ := cache.Key(queryCacheSearch, knowledgeBaseName("u2"), params); otherAfter != other {
+		t.Fatal("invalidating one knowledge base should not affect others")
+	}
+}
+
+func TestQueryResultCacheOff(t *testing.T) {
+	cache, err := newQueryResultCache(queryCacheOff, 0, 0, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cache.Key(queryCacheChat, "kb_u1", "q")
+	if err != nil || key != "" {
+		t.Fatalf("Key = %q, %v, want empty key when the cache is off", key, err)
+	}
+	if _, err := newQueryResultCache("redis", 10, time.Minute, t.TempDir()); err == nil {
+		t.Fatal("unknown cache mode should be rejected")
+	}
+}
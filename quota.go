@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+const quotaFile = "quotas.json"
+
+// 配额限制的资源
+const (
+	quotaResourceBytes     = "bytes"
+	quotaResourceDocuments = "documents"
+	quotaResourceTokens    = "tokens"
+)
+
+// quotaLimits 是一个用户的配额，0 表示不限制
+type quotaLimits struct {
+	MaxBytes     int64 `json:"max_bytes"`
+	MaxDocuments int64 `json:"max_documents"`
+	MaxTokens    int64 `json:"max_tokens"`
+
+	// 每月对话和检索消耗的模型token数，超出后拒绝对话，与存储配额分开检查
+	MaxMonthlyTokens int64 `json:"max_monthly_tokens"`
+}
+
+// defaultQuotaLimits 返回配置中的默认配额
+func defaultQuotaLimits() quotaLimits {
+	return quotaLimits{
+		MaxBytes:     cfg.QuotaBytes,
+		MaxDocuments: cfg.QuotaDocuments,
+		MaxTokens:    cfg.QuotaTokens,
+
+		MaxMonthlyTokens: cfg.QuotaMonthlyTokens,
+	}
+}
+
+// quotaExceededError 表示写入会超出用户配额
+type quotaExceededError struct {
+	Resource  string `json:"resource"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+	Requested int64  `json:"requested"`
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("Quota exceeded for %s: used %d, requested %d, limit %d", e.Resource, e.Used, e.Requested, e.Limit)
+}
+
+// Status 返回对应的HTTP状态码：存储空间不足为 413，文档数或token数超限为 429
+func (e *quotaExceededError) Status() int {
+	if e.Resource == quotaResourceBytes {
+		return consts.StatusRequestEntityTooLarge
+	}
+	return consts.StatusTooManyRequests
+}
+
+// check 检查在当前用量上再写入 addBytes 字节、addDocuments 个文档是否超出配额。
+// 新文档的token数在知识库处理完成前无法得知，token配额只在已经用满时拒绝
+func (l quotaLimits) check(usage documentUsage, addBytes int64, addDocuments int) error {
+	if l.MaxBytes > 0 && usage.Bytes+addBytes > l.MaxBytes {
+		return &quotaExceededError{Resource: quotaResourceBytes, Limit: l.MaxBytes, Used: usage.Bytes, Requested: addBytes}
+	}
+	if l.MaxDocuments > 0 && int64(usage.Documents+addDocuments) > l.MaxDocuments {
+		return &quotaExceededError{Resource: quotaResourceDocuments, Limit: l.MaxDocuments, Used: int64(usage.Documents), Requested: int64(addDocuments)}
+	}
+	if l.MaxTokens > 0 && usage.Tokens >= l.MaxTokens {
+		return &quotaExceededError{Resource: quotaResourceTokens, Limit: l.MaxTokens, Used: usage.Tokens}
+	}
+	return nil
+}
+
+// quotaStore 保存管理员为单个用户调整的配额，以JSON文件持久化
+type quotaStore struct {
+	mu        sync.RWMutex
+	path      string
+	overrides map[string]quotaLimits
+}
+
+// quotas 是服务使用的全局配额表，在 main 中初始化
+var quotas *quotaStore
+
+// newQuotaStore 从指定文件加载配额表，文件不存在时创建空表
+func newQuotaStore(path string) (*quotaStore, error) {
+	s := &quotaStore{
+		path:      path,
+		overrides: make(map[string]quotaLimits),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quotas: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse quotas: %w", err)
+	}
+	return s, nil
+}
+
+// Limits 返回用户生效的配额，没有单独调整时使用默认配额
+func (s *quotaStore) Limits(userID string) (quotaLimits, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if limits, ok := s.overrides[userID]; ok {
+		return limits, true
+	}
+	return defaultQuotaLimits(), false
+}
+
+// Set 调整用户的配额并落盘
+func (s *quotaStore) Set(userID string, limits quotaLimits) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.overrides[userID] = limits
+	return s.saveLocked()
+}
+
+// Reset 恢复用户的默认配额并落盘
+func (s *quotaStore) Reset(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.overrides[userID]; !ok {
+		return nil
+	}
+	delete(s.overrides, userID)
+	return s.saveLocked()
+}
+
+func (s *quotaStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quotas: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create quota directory: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write quotas: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace quotas: %w", err)
+	}
+	return nil
+}
+
+// checkQuota 检查用户再写入 addBytes 字节、addDocuments 个文档是否超出配额
+func checkQuota(userID string, addBytes int64, addDocuments int) error {
+	limits, _ := quotas.Limits(userID)
+	return limits.check(registry.Usage(userID), addBytes, addDocuments)
+}
+
+// writeQuotaError 写入失败响应，超出配额时返回 413 或 429 及配额详情，其他错误返回 500
+func writeQuotaError(c *app.RequestContext, err error) {
+	var quotaErr *quotaExceededError
+	if errors.As(err, &quotaErr) {
+		c.JSON(quotaErr.Status(), utils.H{
+			"error": err.Error(),
+			"quota": quotaErr,
+		})
+		return
+	}
+
+	c.JSON(consts.StatusInternalServerError, utils.H{
+		"error": err.Error(),
+	})
+}
+
+// 查询用户的存储用量和配额
+func getUsage(ctx context.Context, c *app.RequestContext) {
+	userID := c.Query("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	limits, custom := quotas.Limits(userID)
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id": userID,
+		"usage":   registry.Usage(userID),
+		"limits":  limits,
+		"custom":  custom,
+	})
+}
+
+// 管理接口：查看用户的配额
+func getUserQuota(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	limits, custom := quotas.Limits(userID)
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id": userID,
+		"limits":  limits,
+		"custom":  custom,
+		"usage":   registry.Usage(userID),
+	})
+}
+
+// 管理接口：调整用户的配额，未提供的字段保持当前值，0 表示不限制
+func setUserQuota(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	var request struct {
+		MaxBytes     *int64 `json:"max_bytes"`
+		MaxDocuments *int64 `json:"max_documents"`
+		MaxTokens    *int64 `json:"max_tokens"`
+
+		MaxMonthlyTokens *int64 `json:"max_monthly_tokens"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	limits, _ := quotas.Limits(userID)
+	for _, field := range []struct {
+		value  *int64
+		target *int64
+	}{
+		{request.MaxBytes, &limits.MaxBytes},
+		{request.MaxDocuments, &limits.MaxDocuments},
+		{request.MaxTokens, &limits.MaxTokens},
+		{request.MaxMonthlyTokens, &limits.MaxMonthlyTokens},
+	} {
+		if field.value == nil {
+			continue
+		}
+		if *field.value < 0 {
+			c.JSON(consts.StatusBadRequest, utils.H{
+				"error": "Quota limits must not be negative",
+			})
+			return
+		}
+		*field.target = *field.value
+	}
+
+	if err := quotas.Set(userID, limits); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to save quota: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id": userID,
+		"limits":  limits,
+		"custom":  true,
+	})
+}
+
+// 管理接口：恢复用户的默认配额
+func resetUserQuota(ctx context.Context, c *app.RequestContext) {
+	userID := c.Param("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	if err := quotas.Reset(userID); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to save quota: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id": userID,
+		"limits":  defaultQuotaLimits(),
+		"custom":  false,
+	})
+}
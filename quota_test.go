@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+func TestQuotaLimitsCheck(t *testing.T) {
+	limits := quotaLimits{MaxBytes: 100, MaxDocuments: 2, MaxTokens: 1000}
+	usage := documentUsage{Bytes: 60, Documents: 1, Tokens: 500}
+
+	tests := []struct {
+		name         string
+		usage        documentUsage
+		addBytes     int64
+		addDocuments int
+		resource     string
+		status       int
+	}{
+		{"within limits", usage, 40, 1, "", 0},
+		{"too many bytes", usage, 41, 0, quotaResourceBytes, consts.StatusRequestEntityTooLarge},
+		{"too many documents", usage, 0, 2, quotaResourceDocuments, consts.StatusTooManyRequests},
+		{"tokens used up", documentUsage{Tokens: 1000}, 1, 1, quotaResourceTokens, consts.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		err := limits.check(tt.usage, tt.addBytes, tt.addDocuments)
+		var quotaErr *quotaExceededError
+		switch {
+		case tt.resource == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.resource == "":
+		case !errors.As(err, &quotaErr):
+			t.Errorf("%s: error = %v, want quota error", tt.name, err)
+		case quotaErr.Resource != tt.resource || quotaErr.Status() != tt.status:
+			t.Errorf("%s: got %s/%d, want %s/%d", tt.name, quotaErr.Resource, quotaErr.Status(), tt.resource, tt.status)
+		}
+	}
+
+	// 0 表示不限制
+	if err := (quotaLimits{}).check(documentUsage{Bytes: 1 << 40, Documents: 1 << 20}, 1, 1); err != nil {
+		t.Errorf("unlimited quota returned %v", err)
+	}
+}
+
+func TestQuotaStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), quotaFile)
+	s, err := newQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limits, custom := s.Limits("u1"); custom || limits != defaultQuotaLimits() {
+		t.Errorf("Limits = %+v, %v, want defaults", limits, custom)
+	}
+
+	want := quotaLimits{MaxBytes: 10, MaxDocuments: 2}
+	if err := s.Set("u1", want); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载后配额保持不变
+	s, err = newQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits, custom := s.Limits("u1"); !custom || limits != want {
+		t.Errorf("Limits after reload = %+v, %v, want %+v", limits, custom, want)
+	}
+
+	if err := s.Reset("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, custom := s.Limits("u1"); custom {
+		t.Error("Reset should restore default limits")
+	}
+}
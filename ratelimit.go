@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// rateLimit 表示每 Per 时间内最多 Count 个请求，Count 为 0 表示不限制
+type rateLimit struct {
+	Count int
+	Per   time.Duration
+}
+
+func (r rateLimit) Enabled() bool {
+	return r.Count > 0 && r.Per > 0
+}
+
+func (r rateLimit) String() string {
+	if !r.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Count, r.Per)
+}
+
+// parseRateLimit 解析 次数/时间 格式的限流配置，如 20/1m；0 或 off 表示不限制
+func parseRateLimit(value string) (rateLimit, error) {
+	value = strings.TrimSpace(value)
+	if value == "0" || strings.EqualFold(value, "off") {
+		return rateLimit{}, nil
+	}
+
+	countText, perText, ok := strings.Cut(value, "/")
+	if !ok {
+		return rateLimit{}, fmt.Errorf("invalid rate limit %q, use count/duration such as 20/1m", value)
+	}
+	count, err := strconv.Atoi(countText)
+	if err != nil || count < 0 {
+		return rateLimit{}, fmt.Errorf("invalid rate limit count %q", countText)
+	}
+	per, err := time.ParseDuration(perText)
+	if err != nil || per <= 0 {
+		return rateLimit{}, fmt.Errorf("invalid rate limit duration %q", perText)
+	}
+	return rateLimit{Count: count, Per: per}, nil
+}
+
+// tokenBucket 是单个用户的令牌桶
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// tokenBucketLimiter 按key分别限流：桶容量为 Count，每 Per 时间匀速补满
+type tokenBucketLimiter struct {
+	limit rateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newTokenBucketLimiter(limit rateLimit) *tokenBucketLimiter {
+	return &tokenBucketLimiter{
+		limit:   limit,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow 尝试为 key 取一个令牌，失败时返回需要等待的时间
+func (l *tokenBucketLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if !l.limit.Enabled() {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(l.limit.Count)
+	perToken := l.limit.Per / time.Duration(l.limit.Count)
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(perToken))
+		b.updated = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * float64(perToken))
+}
+
+// sweepLocked 每个周期清理一次已经补满的桶，避免长期不活跃的用户占用内存
+func (l *tokenBucketLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < l.limit.Per {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitKey 返回限流使用的用户标识：依次从查询参数、表单和JSON请求体中读取 user_id，
+// 都没有或无效时按客户端IP限流
+func rateLimitKey(c *app.RequestContext) string {
+	userID := c.Query("user_id")
+	if userID == "" {
+		if strings.HasPrefix(string(c.ContentType()), "application/json") {
+			var body struct {
+				UserID string `json:"user_id"`
+			}
+			if json.Unmarshal(c.Request.Body(), &body) == nil {
+				userID = body.UserID
+			}
+		} else {
+			userID = string(c.FormValue("user_id"))
+		}
+	}
+
+	if validateUserID(userID) != nil {
+		return "ip:" + c.ClientIP()
+	}
+	return "user:" + userID
+}
+
+// rateLimitMiddleware 返回按用户限流的中间件。每个中间件实例有独立的令牌桶，
+// 挂到多个路由上时这些路由共享同一个限额
+func rateLimitMiddleware(limit rateLimit) app.HandlerFunc {
+	limiter := newTokenBucketLimiter(limit)
+	return func(ctx context.Context, c *app.RequestContext) {
+		ok, wait := limiter.Allow(rateLimitKey(c), time.Now())
+		if ok {
+			c.Next(ctx)
+			return
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.AbortWithStatusJSON(consts.StatusTooManyRequests, utils.H{
+			"error":       fmt.Sprintf("Too many requests, retry after %d seconds", retryAfter),
+			"retry_after": retryAfter,
+		})
+	}
+}
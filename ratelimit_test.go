@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    rateLimit
+		wantErr bool
+	}{
+		{"20/1m", rateLimit{Count: 20, Per: time.Minute}, false},
+		{" 5/10s ", rateLimit{Count: 5, Per: 10 * time.Second}, false},
+		{"0", rateLimit{}, false},
+		{"off", rateLimit{}, false},
+		{"20", rateLimit{}, true},
+		{"x/1m", rateLimit{}, true},
+		{"20/0s", rateLimit{}, true},
+		{"-1/1m", rateLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRateLimit(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRateLimit(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := newTokenBucketLimiter(rateLimit{Count: 2, Per: 10 * time.Second})
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("u1", now); !ok {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+	}
+	ok, wait := l.Allow("u1", now)
+	if ok || wait != 5*time.Second {
+		t.Fatalf("third request = %v, wait %v, want rejected with 5s wait", ok, wait)
+	}
+
+	// 其他用户有独立的令牌桶
+	if ok, _ := l.Allow("u2", now); !ok {
+		t.Fatal("u2 rejected by u1's bucket")
+	}
+
+	if ok, _ := l.Allow("u1", now.Add(5*time.Second)); !ok {
+		t.Fatal("u1 rejected after refill")
+	}
+
+	// 补满的桶在下一个周期被清理
+	l.Allow("u3", now.Add(30*time.Second))
+	if len(l.buckets) != 1 {
+		t.Fatalf("buckets = %d after sweep, want 1", len(l.buckets))
+	}
+
+	off := newTokenBucketLimiter(rateLimit{})
+	for i := 0; i < 100; i++ {
+		if ok, _ := off.Allow("u1", now); !ok {
+			t.Fatal("disabled limiter rejected a request")
+		}
+	}
+}
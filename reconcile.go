@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tos_tool"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// 对账发现的不一致类型
+const (
+	driftObjectWithoutDoc = "object_without_doc" // TOS中有文件，知识库中没有对应文档
+	driftDocWithoutObject = "doc_without_object" // 知识库中有文档，TOS中没有对应文件
+	driftFailedDoc        = "failed_doc"         // 知识库中的文档处理失败
+)
+
+const (
+	// 刚上传的文件可能还在写入知识库，修改时间在宽限期内的文件不参与对账
+	reconcileGracePeriod = 10 * time.Minute
+
+	// 知识库文档处理状态
+	docStatusFailed   = 1
+	docStatusDeleting = 5
+)
+
+// driftItem 是对账发现的一处不一致
+type driftItem struct {
+	Kind      string `json:"kind"`
+	UserID    string `json:"user_id"`
+	DocID     string `json:"doc_id"`
+	Name      string `json:"name"`
+	ObjectKey string `json:"object_key,omitempty"`
+	Repaired  bool   `json:"repaired"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reconcileReport 是一次对账的结果
+type reconcileReport struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Repair     bool           `json:"repair"`
+	Users      int            `json:"users"`
+	Objects    int            `json:"objects"`
+	Documents  int            `json:"documents"`
+	Summary    map[string]int `json:"summary"`
+	Drift      []driftItem    `json:"drift"`
+	Errors     []string       `json:"errors,omitempty"`
+}
+
+// Clean 返回对账是否没有错误且没有未修复的不一致
+func (r *reconcileReport) Clean() bool {
+	if len(r.Errors) > 0 {
+		return false
+	}
+	for _, item := range r.Drift {
+		if !item.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// storedObject 是TOS中 uploads/ 下的一个文件
+type storedObject struct {
+	Name    string
+	Key     string
+	ModTime time.Time
+}
+
+var (
+	// reconcileMu 保证同一时间只有一次对账在运行
+	reconcileMu sync.Mutex
+
+	lastReconcileMu sync.RWMutex
+	lastReconcile   *reconcileReport
+)
+
+// listStoredObjects 列出TOS中所有用户上传的文件，按 用户ID -> docID 分组
+func listStoredObjects(ctx context.Context) (map[string]map[string]storedObject, error) {
+	objects := make(map[string]map[string]storedObject)
+	for object, err := range tos_tool.ObjectsWithEnvConfig(ctx, "uploads/") {
+		if err != nil {
+			return nil, err
+		}
+
+		userID, filename, ok := strings.Cut(object.Name, "/")
+		if !ok || validateFilename(filename) != nil {
+			continue
+		}
+
+		if objects[userID] == nil {
+			objects[userID] = make(map[string]storedObject)
+		}
+		objects[userID][generateDocID(filename)] = storedObject{
+			Name:    filename,
+			Key:     object.Key,
+			ModTime: object.LastModified,
+		}
+	}
+	return objects, nil
+}
+
+// reconcile 对比TOS中的文件和知识库中的文档，报告并可选地修复不一致。
+// userIDs 为空时检查所有用户
+func reconcile(ctx context.Context, userIDs []string, repair bool) *reconcileReport {
+	report := &reconcileReport{
+		StartedAt: time.Now(),
+		Repair:    repair,
+		Summary:   make(map[string]int),
+		Drift:     []driftItem{},
+	}
+	defer func() {
+		for _, item := range report.Drift {
+			report.Summary[item.Kind]++
+		}
+		report.FinishedAt = time.Now()
+	}()
+
+	objects, err := listStoredObjects(ctx)
+	if err != nil {
+		report.Errors = append(report.Errors, "Failed to list files from TOS: "+err.Error())
+		return report
+	}
+
+	if len(userIDs) == 0 {
+		seen := make(map[string]bool)
+		for userID := range objects {
+			seen[userID] = true
+		}
+		for _, userID := range registry.Users() {
+			seen[userID] = true
+		}
+		for userID := range seen {
+			userIDs = append(userIDs, userID)
+		}
+		sort.Strings(userIDs)
+	}
+
+	for _, userID := range userIDs {
+		// TOS中不符合规则的前缀无法安全地构建对象键，只报告不处理
+		if err := validateUserID(userID); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%q: %v", userID, err))
+			continue
+		}
+		if err := reconcileUser(ctx, report, userID, objects[userID], repair); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", userID, err))
+		}
+	}
+	return report
+}
+
+// reconcileUser 对账单个用户的知识库
+func reconcileUser(ctx context.Context, report *reconcileReport, userID string, objects map[string]storedObject, repair bool) error {
+	report.Users++
+	report.Objects += len(objects)
+
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(userID), knowledgeBaseProject)
+	if err != nil {
+		return fmt.Errorf("failed to check knowledge base: %w", err)
+	}
+
+	var docs []viking_db_tool.DocumentInfo
+	if exists {
+		if docs, err = viking_db_tool.ListAllDocuments(ctx, resourceID); err != nil {
+			return fmt.Errorf("failed to list documents: %w", err)
+		}
+	}
+	report.Documents += len(docs)
+
+	indexed := make(map[string]bool)
+	var drift []driftItem
+	for _, doc := range docs {
+		indexed[doc.DocID] = true
+		object, ok := objects[doc.DocID]
+		switch {
+		case !ok && doc.Status.ProcessStatus != docStatusDeleting:
+			drift = append(drift, driftItem{Kind: driftDocWithoutObject, UserID: userID, DocID: doc.DocID, Name: doc.DocName})
+		case ok && doc.Status.ProcessStatus == docStatusFailed:
+			drift = append(drift, driftItem{Kind: driftFailedDoc, UserID: userID, DocID: doc.DocID, Name: object.Name, ObjectKey: object.Key})
+		}
+	}
+
+	cutoff := time.Now().Add(-reconcileGracePeriod)
+	for docID, object := range objects {
+		if !indexed[docID] && object.ModTime.Before(cutoff) {
+			drift = append(drift, driftItem{Kind: driftObjectWithoutDoc, UserID: userID, DocID: docID, Name: object.Name, ObjectKey: object.Key})
+		}
+	}
+	sort.Slice(drift, func(i, j int) bool {
+		return drift[i].Name < drift[j].Name
+	})
+
+	for i := range drift {
+		if !repair {
+			continue
+		}
+		if err := repairDrift(ctx, &resourceID, drift[i]); err != nil {
+			drift[i].Error = err.Error()
+			continue
+		}
+		drift[i].Repaired = true
+	}
+	report.Drift = append(report.Drift, drift...)
+	return nil
+}
+
+// repairDrift 修复一处不一致：多余的文档从知识库删除，缺失或失败的文档重新写入知识库
+func repairDrift(ctx context.Context, resourceID *string, item driftItem) error {
+	if item.Kind == driftDocWithoutObject {
+		resp, err := viking_db_tool.DeleteDocumentByResourceID(ctx, *resourceID, item.DocID)
+		if err != nil {
+			return err
+		}
+		if resp.Code != 0 {
+			return fmt.Errorf("delete failed with code %d: %s", resp.Code, resp.Message)
+		}
+		queryCache.Invalidate(ctx, item.UserID)
+		return nil
+	}
+
+	if _, inTrash := registry.GetTrashed(item.UserID, item.DocID); inTrash {
+		return fmt.Errorf("document is in trash, restore or purge it first")
+	}
+
+	// 知识库不存在时先创建
+	if *resourceID == "" {
+		id, err := ensureKnowledgeBase(ctx, item.UserID)
+		if err != nil {
+			return err
+		}
+		*resourceID = id
+	}
+
+	// 启用元数据记录之前上传的文件没有记录，按文件名补一条
+	record, ok := registry.Get(item.UserID, item.DocID)
+	if !ok {
+		now := time.Now()
+		record = DocumentRecord{
+			DocID:     item.DocID,
+			UserID:    item.UserID,
+			Name:      item.Name,
+			DocType:   getDocTypeByExtension(item.Name),
+			ObjectKey: item.ObjectKey,
+			Source:    documentSourceUpload,
+			Version:   1,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+	}
+
+	// 失败时同样保存记录，以便查看失败原因
+	// 处理失败的文档先删除知识库中的旧文档再重新写入
+	active := record.ActiveVersion()
+	var previous *DocumentVersion
+	if item.Kind == driftFailedDoc {
+		previous = &active
+	}
+	attempt := indexVersion(ctx, *resourceID, record, active, nil, previous)
+	_, ok, err := registry.Update(record.UserID, record.DocID, attempt.apply)
+	if !ok && err == nil {
+		// 没有记录的文件新建记录
+		attempt.apply(&record)
+		err = registry.Put(record)
+	}
+	if err != nil && attempt.err == nil {
+		return err
+	}
+	return attempt.err
+}
+
+// runReconcile 执行一次对账并保存结果，已有对账在运行时返回 false
+func runReconcile(ctx context.Context, userIDs []string, repair bool) (*reconcileReport, bool) {
+	if !reconcileMu.TryLock() {
+		return nil, false
+	}
+	defer reconcileMu.Unlock()
+
+	report := reconcile(ctx, userIDs, repair)
+
+	lastReconcileMu.Lock()
+	lastReconcile = report
+	lastReconcileMu.Unlock()
+
+	slog.InfoContext(ctx, "Reconciliation finished",
+		"users", report.Users, "objects", report.Objects, "documents", report.Documents, "drift", report.Summary, "errors", len(report.Errors))
+	return report, true
+}
+
+// startReconciler 启动后台任务，定期对账；对账间隔为 0 时不启动
+func startReconciler(ctx context.Context) {
+	if cfg.ReconcileInterval <= 0 {
+		return
+	}
+	inflight.Go(func() {
+		ticker := time.NewTicker(cfg.ReconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runReconcile(ctx, nil, cfg.ReconcileRepair)
+			}
+		}
+	})
+}
+
+// splitUserIDs 解析逗号分隔的用户ID列表
+func splitUserIDs(value string) ([]string, error) {
+	var userIDs []string
+	for _, userID := range strings.Split(value, ",") {
+		if userID = strings.TrimSpace(userID); userID == "" {
+			continue
+		}
+		if err := validateUserID(userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, nil
+}
+
+// 管理接口：立即执行一次对账
+func reconcileHandler(ctx context.Context, c *app.RequestContext) {
+	repair := c.Query("repair") == "true"
+	userIDs, err := splitUserIDs(c.Query("user_id"))
+	if err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	report, ok := runReconcile(ctx, userIDs, repair)
+	if !ok {
+		c.JSON(consts.StatusConflict, utils.H{
+			"error": "Reconciliation is already running",
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, report)
+}
+
+// 管理接口：查看最近一次对账的结果
+func lastReconcileHandler(ctx context.Context, c *app.RequestContext) {
+	lastReconcileMu.RLock()
+	report := lastReconcile
+	lastReconcileMu.RUnlock()
+
+	if report == nil {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "No reconciliation has run yet",
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, report)
+}
+
+// runReconcileCommand 实现 reconcile 子命令，返回进程退出码：
+// 0 表示一致（或已全部修复），1 表示存在错误或未修复的不一致。
+// 命令行进程只读取文档记录，-repair 时请求正在运行的服务修复：服务在内存中保存文档记录，
+// 由命令行直接改写记录文件会被服务之后的写入覆盖
+func runReconcileCommand(args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "ask the running server to repair drift instead of only reporting it")
+	serverURL := flags.String("server", "http://localhost:8888", "address of the running server used by -repair")
+	users := flags.String("user", "", "comma separated user IDs to check, all users by default")
+	flags.Parse(args)
+
+	userIDs, err := splitUserIDs(*users)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var report *reconcileReport
+	if *repair {
+		if report, err = requestReconcile(context.Background(), *serverURL, cfg.AdminToken, userIDs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		report, _ = runReconcile(context.Background(), userIDs, false)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		return 1
+	}
+	if !report.Clean() {
+		return 1
+	}
+	return 0
+}
+
+// requestReconcile 通过管理接口请求正在运行的服务执行对账并修复，返回服务的对账结果
+func requestReconcile(ctx context.Context, serverURL, adminToken string, userIDs []string) (*reconcileReport, error) {
+	if adminToken == "" {
+		return nil, errors.New("MKB_ADMIN_TOKEN is required to repair through the running server")
+	}
+
+	query := url.Values{"repair": {"true"}}
+	if len(userIDs) > 0 {
+		query.Set("user_id", strings.Join(userIDs, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(serverURL, "/")+"/api/admin/reconcile?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address: %w", err)
+	}
+	req.Header.Set(adminTokenHeader, adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return nil, fmt.Errorf("server returned HTTP %d: %s", resp.StatusCode, body.Error)
+	}
+	var report reconcileReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("invalid reconcile report: %w", err)
+	}
+	return &report, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestReconcile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/admin/reconcile" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get(adminTokenHeader) != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Invalid admin token"}`))
+			return
+		}
+		if r.URL.Query().Get("repair") != "true" || r.URL.Query().Get("user_id") != "u1,u2" {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		json.NewEncoder(w).Encode(reconcileReport{
+			Repair: true,
+			Users:  2,
+			Drift:  []driftItem{{Kind: driftFailedDoc, UserID: "u1", DocID: "atxt", Repaired: true}},
+		})
+	}))
+	defer server.Close()
+
+	// 修复由正在运行的服务执行，命令行只输出服务返回的结果
+	report, err := requestReconcile(context.Background(), server.URL, "secret", []string{"u1", "u2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Repair || report.Users != 2 || !report.Clean() {
+		t.Errorf("report = %+v", report)
+	}
+
+	if _, err := requestReconcile(context.Background(), server.URL, "wrong", nil); err == nil {
+		t.Error("expected error for rejected admin token")
+	}
+	if _, err := requestReconcile(context.Background(), server.URL, "", nil); err == nil {
+		t.Error("expected error without admin token")
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	dataDir      = "./data"
+	registryFile = "documents.json"
+)
+
+// 文档来源
+const (
+	documentSourceUpload = "upload" // 通过 /api/upload 上传的文件
+	documentSourceText   = "text"   // 通过 /api/documents/text 创建的文本文档
+)
+
+// DocumentRecord 记录一个文档在对象存储和知识库中的元数据
+type DocumentRecord struct {
+	DocID         string            `json:"doc_id"`
+	UserID        string            `json:"user_id"`
+	Name          string            `json:"name"`
+	DocType       string            `json:"doc_type"`
+	ObjectKey     string            `json:"object_key"`
+	ProcessedKey  string            `json:"processed_key,omitempty"` // 预处理后副本的对象键，未预处理时为空
+	Source        string            `json:"source"`
+	Size          int64             `json:"size"`
+	Version       int               `json:"version"` // 当前生效（已写入知识库）的版本号
+	Versions      []DocumentVersion `json:"versions,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`        // 额外元数据，如压缩包来源
+	TrashKey      string            `json:"trash_key,omitempty"`       // 放入回收站后文件在TOS中的对象键
+	Tokens        int64             `json:"tokens,omitempty"`          // 知识库中已索引的token数，处理完成时更新
+	Attempts      int               `json:"attempts,omitempty"`        // 提交到知识库的次数
+	LastError     string            `json:"last_error,omitempty"`      // 最近一次提交或处理失败的原因，成功提交后清空
+	LastAttemptAt *time.Time        `json:"last_attempt_at,omitempty"` // 最近一次提交到知识库的时间
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	DeletedAt     *time.Time        `json:"deleted_at,omitempty"` // 放入回收站的时间，为空表示未删除
+}
+
+// Trashed 返回文档是否在回收站中
+func (rec DocumentRecord) Trashed() bool {
+	return rec.DeletedAt != nil
+}
+
+// DocumentVersion 是文档的一个历史版本，每个版本在TOS中有独立的对象
+type DocumentVersion struct {
+	Version   int       `json:"version"`
+	ObjectKey string    `json:"object_key"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FindVersion 返回指定版本号的历史版本
+func (rec DocumentRecord) FindVersion(version int) (DocumentVersion, bool) {
+	for _, v := range rec.Versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return DocumentVersion{}, false
+}
+
+// ActiveVersion 返回当前生效的版本；启用版本管理之前上传的文档没有历史，直接使用当前对象
+func (rec DocumentRecord) ActiveVersion() DocumentVersion {
+	if v, ok := rec.FindVersion(rec.Version); ok {
+		return v
+	}
+	return DocumentVersion{
+		Version:   rec.Version,
+		ObjectKey: rec.ObjectKey,
+		Size:      rec.Size,
+		CreatedAt: rec.UpdatedAt,
+	}
+}
+
+// LatestVersion 返回已使用的最大版本号，新版本号在此基础上加一
+func (rec DocumentRecord) LatestVersion() int {
+	latest := rec.Version
+	for _, v := range rec.Versions {
+		if v.Version > latest {
+			latest = v.Version
+		}
+	}
+	return latest
+}
+
+// documentRegistry 是以 JSON 文件持久化的文档元数据表，按 用户ID/docID 索引
+type documentRegistry struct {
+	mu   sync.RWMutex
+	path string
+	docs map[string]*DocumentRecord
+}
+
+// registry 是服务使用的全局文档元数据表，在 main 中初始化
+var registry *documentRegistry
+
+func registryKey(userID, docID string) string {
+	return userID + "/" + docID
+}
+
+// newDocumentRegistry 从指定文件加载文档元数据表，文件不存在时创建空表
+func newDocumentRegistry(path string) (*documentRegistry, error) {
+	r := &documentRegistry{
+		path: path,
+		docs: make(map[string]*DocumentRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry: %w", err)
+	}
+
+	var records []*DocumentRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse registry: %w", err)
+	}
+	for _, rec := range records {
+		r.docs[registryKey(rec.UserID, rec.DocID)] = rec
+	}
+	return r, nil
+}
+
+// Get 返回指定用户未删除的文档记录
+func (r *documentRegistry) Get(userID, docID string) (DocumentRecord, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rec, ok := r.docs[registryKey(userID, docID)]
+	if !ok || rec.Trashed() {
+		return DocumentRecord{}, false
+	}
+	return *rec, true
+}
+
+// GetTrashed 返回指定用户回收站中的文档记录
+func (r *documentRegistry) GetTrashed(userID, docID string) (DocumentRecord, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rec, ok := r.docs[registryKey(userID, docID)]
+	if !ok || !rec.Trashed() {
+		return DocumentRecord{}, false
+	}
+	return *rec, true
+}
+
+// List 返回指定用户所有未删除的文档记录，按名称排序
+func (r *documentRegistry) List(userID string) []DocumentRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []DocumentRecord
+	for _, rec := range r.docs {
+		if rec.UserID == userID && !rec.Trashed() {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// ListTrash 返回指定用户回收站中的文档记录，最近删除的在前
+func (r *documentRegistry) ListTrash(userID string) []DocumentRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []DocumentRecord
+	for _, rec := range r.docs {
+		if rec.UserID == userID && rec.Trashed() {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].DeletedAt.After(*records[j].DeletedAt)
+	})
+	return records
+}
+
+// ListDeletedBefore 返回所有用户在指定时间之前放入回收站的文档记录
+func (r *documentRegistry) ListDeletedBefore(before time.Time) []DocumentRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var records []DocumentRecord
+	for _, rec := range r.docs {
+		if rec.Trashed() && rec.DeletedAt.Before(before) {
+			records = append(records, *rec)
+		}
+	}
+	return records
+}
+
+// Users 返回所有有文档记录（包括回收站）的用户ID，按字典序排序
+func (r *documentRegistry) Users() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var users []string
+	for _, rec := range r.docs {
+		if !seen[rec.UserID] {
+			seen[rec.UserID] = true
+			users = append(users, rec.UserID)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Put 新增或覆盖一条文档记录并落盘
+func (r *documentRegistry) Put(rec DocumentRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.docs[registryKey(rec.UserID, rec.DocID)] = &rec
+	return r.saveLocked()
+}
+
+// Update 在写锁内修改一条文档记录（包括回收站中的）并落盘，返回修改后的记录；记录不存在时不调用 fn，返回 false。
+// fn 基于记录的最新内容修改，并发的编辑、重新处理和状态更新不会互相覆盖，fn 中不能再调用 registry 的方法
+func (r *documentRegistry) Update(userID, docID string, fn func(*DocumentRecord)) (DocumentRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.docs[registryKey(userID, docID)]
+	if !ok {
+		return DocumentRecord{}, false, nil
+	}
+	updated := *rec
+	fn(&updated)
+	r.docs[registryKey(userID, docID)] = &updated
+	return updated, true, r.saveLocked()
+}
+
+// RecordFailure 记录文档在知识库中处理失败的原因，记录不存在时忽略
+func (r *documentRegistry) RecordFailure(userID, docID, reason string) error {
+	_, _, err := r.Update(userID, docID, func(rec *DocumentRecord) {
+		rec.LastError = reason
+	})
+	return err
+}
+
+// RecordTokens 记录文档处理完成后知识库统计的token数，记录不存在时忽略
+func (r *documentRegistry) RecordTokens(userID, docID string, tokens int64) error {
+	_, _, err := r.Update(userID, docID, func(rec *DocumentRecord) {
+		rec.Tokens = tokens
+	})
+	return err
+}
+
+// documentUsage 是用户当前的存储用量
+type documentUsage struct {
+	Bytes     int64 `json:"bytes"`     // 所有版本和回收站中文件的总大小
+	Documents int   `json:"documents"` // 不含回收站的文档数
+	Tokens    int64 `json:"tokens"`    // 不含回收站的已索引token数
+}
+
+// Usage 统计用户的存储用量。历史版本和回收站中的文件同样占用存储空间，计入字节数
+func (r *documentRegistry) Usage(userID string) documentUsage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var usage documentUsage
+	for _, rec := range r.docs {
+		if rec.UserID != userID {
+			continue
+		}
+		if len(rec.Versions) == 0 {
+			usage.Bytes += rec.Size
+		}
+		for _, v := range rec.Versions {
+			usage.Bytes += v.Size
+		}
+		if !rec.Trashed() {
+			usage.Documents++
+			usage.Tokens += rec.Tokens
+		}
+	}
+	return usage
+}
+
+// Delete 删除一条文档记录并落盘
+func (r *documentRegistry) Delete(userID, docID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := registryKey(userID, docID)
+	if _, ok := r.docs[key]; !ok {
+		return nil
+	}
+	delete(r.docs, key)
+	return r.saveLocked()
+}
+
+// saveLocked 将元数据表写入临时文件后原子替换，调用方需持有写锁
+func (r *documentRegistry) saveLocked() error {
+	records := make([]*DocumentRecord, 0, len(r.docs))
+	for _, rec := range r.docs {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return registryKey(records[i].UserID, records[i].DocID) < registryKey(records[j].UserID, records[j].DocID)
+	})
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal registry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create registry directory: %w", err)
+	}
+	tmpPath := r.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	if err := os.Rename(tmpPath, r.path); err != nil {
+		return fmt.Errorf("failed to replace registry: %w", err)
+	}
+	return nil
+}
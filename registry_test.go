@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistryTrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), registryFile)
+	r, err := newDocumentRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now()
+	for _, rec := range []DocumentRecord{
+		{DocID: "a", UserID: "u1", Name: "a.txt"},
+		{DocID: "b", UserID: "u1", Name: "b.txt", DeletedAt: &old},
+		{DocID: "c", UserID: "u1", Name: "c.txt", DeletedAt: &recent},
+		{DocID: "d", UserID: "u2", Name: "d.txt", DeletedAt: &old},
+	} {
+		if err := r.Put(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := r.Get("u1", "b"); ok {
+		t.Error("Get should not return trashed documents")
+	}
+	if _, ok := r.GetTrashed("u1", "a"); ok {
+		t.Error("GetTrashed should not return live documents")
+	}
+	if got := r.List("u1"); len(got) != 1 || got[0].DocID != "a" {
+		t.Errorf("List = %+v, want only a", got)
+	}
+
+	// 重新加载后回收站状态保持不变
+	r, err = newDocumentRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trash := r.ListTrash("u1")
+	if len(trash) != 2 || trash[0].DocID != "c" || trash[1].DocID != "b" {
+		t.Errorf("ListTrash = %+v, want c, b", trash)
+	}
+	if expired := r.ListDeletedBefore(time.Now().Add(-24 * time.Hour)); len(expired) != 2 {
+		t.Errorf("ListDeletedBefore returned %d records, want 2", len(expired))
+	}
+}
+
+func TestRegistryRecordFailure(t *testing.T) {
+	r, err := newDocumentRegistry(filepath.Join(t.TempDir(), registryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Put(DocumentRecord{DocID: "a", UserID: "u1", Name: "a.txt", Attempts: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.RecordFailure("u1", "a", "parse error"); err != nil {
+		t.Fatal(err)
+	}
+	rec, _ := r.Get("u1", "a")
+	if rec.LastError != "parse error" || rec.Attempts != 2 {
+		t.Errorf("record = %+v, want last_error set and attempts unchanged", rec)
+	}
+
+	// 没有记录的文档不会新建记录
+	if err := r.RecordFailure("u1", "missing", "parse error"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Get("u1", "missing"); ok {
+		t.Error("RecordFailure should not create records")
+	}
+}
+
+func TestRegistryUpdate(t *testing.T) {
+	r, err := newDocumentRegistry(filepath.Join(t.TempDir(), registryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Put(DocumentRecord{DocID: "a", UserID: "u1", Name: "a.txt", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发的修改都基于最新的记录，不会互相覆盖
+	var wg sync.WaitGroup
+	for i := 2; i <= 21; i++ {
+		wg.Add(2)
+		go func(version int) {
+			defer wg.Done()
+			r.Update("u1", "a", func(rec *DocumentRecord) {
+				rec.Attempts++
+				rec.Versions = append(rec.Versions, DocumentVersion{Version: version})
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			r.RecordTokens("u1", "a", 100)
+		}()
+	}
+	wg.Wait()
+
+	rec, _ := r.Get("u1", "a")
+	if rec.Attempts != 20 || len(rec.Versions) != 20 || rec.Tokens != 100 {
+		t.Errorf("record = attempts %d, versions %d, tokens %d, want 20, 20, 100", rec.Attempts, len(rec.Versions), rec.Tokens)
+	}
+
+	if _, ok, err := r.Update("u1", "missing", func(*DocumentRecord) { t.Error("fn called for missing record") }); ok || err != nil {
+		t.Errorf("Update(missing) = %v, %v, want false, nil", ok, err)
+	}
+}
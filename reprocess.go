@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// documentDiagnostics 是文档最近一次写入知识库的诊断信息
+type documentDiagnostics struct {
+	Attempts      int        `json:"attempts,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+}
+
+func newDocumentDiagnostics(record DocumentRecord) documentDiagnostics {
+	return documentDiagnostics{
+		Attempts:      record.Attempts,
+		LastError:     record.LastError,
+		LastAttemptAt: record.LastAttemptAt,
+	}
+}
+
+// documentStatusEntry 是状态接口返回的一条记录，在知识库状态之外附带诊断信息
+type documentStatusEntry struct {
+	viking_db_tool.DocumentStatusInfo
+	documentDiagnostics
+}
+
+// failureReason 返回知识库处理失败的原因
+func failureReason(status viking_db_tool.DocumentProcessingStatus) string {
+	switch {
+	case status.FailedMsg != "" && status.FailedCode != 0:
+		return fmt.Sprintf("%s (code %d)", status.FailedMsg, status.FailedCode)
+	case status.FailedMsg != "":
+		return status.FailedMsg
+	case status.FailedCode != 0:
+		return fmt.Sprintf("知识库处理失败 (code %d)", status.FailedCode)
+	default:
+		return "知识库处理失败"
+	}
+}
+
+// reprocessRecord 用当前版本重新写入知识库，无论成功与否都保存记录，record 更新为保存后的记录
+func reprocessRecord(ctx context.Context, resourceID string, record *DocumentRecord) error {
+	active := record.ActiveVersion()
+	attempt := indexVersion(ctx, resourceID, *record, active, nil, &active)
+	saved, ok, err := registry.Update(record.UserID, record.DocID, attempt.apply)
+	if ok {
+		*record = saved
+	}
+	if err != nil && attempt.err == nil {
+		return err
+	}
+	return attempt.err
+}
+
+// 重新处理单个文档：重新生成预签名URL并提交到知识库
+func reprocessDocument(ctx context.Context, c *app.RequestContext) {
+	var request struct {
+		UserID string `json:"user_id"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	record, ok := lookupDocument(c, request.UserID)
+	if !ok {
+		return
+	}
+
+	resourceID, err := ensureKnowledgeBase(ctx, request.UserID)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := reprocessRecord(ctx, resourceID, &record); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error":       err.Error(),
+			"diagnostics": newDocumentDiagnostics(record),
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"message":     "Document resubmitted for processing",
+		"doc_id":      record.DocID,
+		"version":     record.Version,
+		"diagnostics": newDocumentDiagnostics(record),
+	})
+}
+
+// retryResult 是批量重试中单个文档的结果
+type retryResult struct {
+	DocID   string `json:"doc_id"`
+	Name    string `json:"name"`
+	Retried bool   `json:"retried"`
+	Error   string `json:"error,omitempty"`
+	documentDiagnostics
+}
+
+// 批量重新处理知识库中所有处理失败的文档
+func retryFailedDocuments(ctx context.Context, c *app.RequestContext) {
+	var request struct {
+		UserID string `json:"user_id"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	if !requireUserID(c, request.UserID) {
+		return
+	}
+
+	exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(request.UserID), knowledgeBaseProject)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to check knowledge base existence: " + err.Error(),
+		})
+		return
+	}
+
+	if !exists {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "Knowledge base not found",
+		})
+		return
+	}
+
+	// 先收集失败的文档，避免在翻页过程中修改知识库
+	var failed []viking_db_tool.DocumentInfo
+	for doc, err := range viking_db_tool.Documents(ctx, resourceID) {
+		if err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": "Failed to list documents: " + err.Error(),
+			})
+			return
+		}
+		if doc.Status.ProcessStatus == docStatusFailed {
+			failed = append(failed, doc)
+		}
+	}
+
+	results := []retryResult{}
+	retried := 0
+	for _, doc := range failed {
+		result := retryResult{DocID: doc.DocID, Name: doc.DocName}
+		record, ok := registry.Get(request.UserID, doc.DocID)
+		if !ok {
+			// 没有元数据记录的文档无法确定源文件，交给对账处理
+			result.Error = "no document record, run reconciliation to repair it"
+			results = append(results, result)
+			continue
+		}
+
+		if err := reprocessRecord(ctx, resourceID, &record); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Retried = true
+			retried++
+		}
+		result.documentDiagnostics = newDocumentDiagnostics(record)
+		results = append(results, result)
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"user_id": request.UserID,
+		"failed":  len(failed),
+		"retried": retried,
+		"results": results,
+	})
+}
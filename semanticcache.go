@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"viking_db_tool"
+)
+
+// 语义缓存使用的向量化方式
+const (
+	semanticCacheOff   = "off"
+	semanticCacheLocal = "local"
+)
+
+const (
+	queryCacheSemantic = "semantic"
+	localEmbeddingDims = 512
+)
+
+// Embedder 把问题转换为向量，语义相近的问题向量的余弦相似度高。
+// 可以接入外部的向量化模型，没有配置时使用本地的 hashingEmbedder
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// hashingEmbedder 是不依赖外部服务的本地向量化：把英文单词、汉字及相邻汉字组成的二元组散列到固定维度。
+// 结果是确定的，只能识别用词相近的问法，适合测试和没有向量化模型的部署
+type hashingEmbedder struct {
+	dims int
+}
+
+func (e hashingEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	vector := make([]float32, e.dims)
+	for _, feature := range queryFeatures(normalizeQuery(text)) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// 最高位决定符号，减少散列冲突对相似度的影响
+		if sum>>63 == 0 {
+			vector[sum%uint64(e.dims)]++
+		} else {
+			vector[sum%uint64(e.dims)]--
+		}
+	}
+	normalizeVector(vector)
+	return vector, nil
+}
+
+// queryFeatures 把问题切分为特征：连续的字母和数字为一个词，汉字单独成词并与前一个汉字组成二元组，忽略标点和空白
+func queryFeatures(text string) []string {
+	var features []string
+	var word []rune
+	var prevHan rune
+	flush := func() {
+		if len(word) > 0 {
+			features = append(features, string(word))
+			word = word[:0]
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			features = append(features, string(r))
+			if prevHan != 0 {
+				features = append(features, string([]rune{prevHan, r}))
+			}
+			prevHan = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+		prevHan = 0
+	}
+	flush()
+	return features
+}
+
+func normalizeVector(vector []float32) {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+}
+
+// cosineSimilarity 返回两个向量的余弦相似度，维度不同时返回 0
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// chatCitation 是回答引用的一个检索切片
+type chatCitation struct {
+	KnowledgeBase string  `json:"knowledge_base,omitempty"` // 检索多个知识库时切片所在的知识库
+	DocID         string  `json:"doc_id"`
+	DocName       string  `json:"doc_name"`
+	ChunkID       int     `json:"chunk_id"`
+	ChunkTitle    string  `json:"chunk_title,omitempty"`
+	Score         float64 `json:"score"`
+}
+
+// searchCitations 返回检索结果中各切片的出处
+func searchCitations(resp *viking_db_tool.CollectionSearchKnowledgeResponse) []chatCitation {
+	citations := []chatCitation{}
+	if resp == nil || resp.Data == nil {
+		return citations
+	}
+	for _, item := range resp.Data.ResultList {
+		citations = append(citations, chatCitation{
+			KnowledgeBase: item.SourceCollection,
+			DocID:         item.DocInfo.Docid,
+			DocName:       item.DocInfo.DocName,
+			ChunkID:       item.ChunkId,
+			ChunkTitle:    item.ChunkTitle,
+			Score:         item.Score,
+		})
+	}
+	return citations
+}
+
+// semanticAnswer 是语义缓存中保存的回答
+type semanticAnswer struct {
+	Answer      string                          `json:"answer"`
+	Citations   []chatCitation                  `json:"citations"`
+	Usage       *viking_db_tool.ModelTokenUsage `json:"usage,omitempty"`
+	SearchUsage *viking_db_tool.TotalTokenUsage `json:"search_usage,omitempty"`
+}
+
+// semanticMatch 是语义缓存找到的相似问题
+type semanticMatch struct {
+	Question   string  `json:"question"`
+	Similarity float64 `json:"similarity"`
+	answer     semanticAnswer
+}
+
+type semanticEntry struct {
+	question string
+	vector   []float32
+	answer   semanticAnswer
+	version  int64 // 回答时知识库的内容版本
+	expires  time.Time
+}
+
+// semanticCache 按知识库保存已回答的问题及其向量，新问题与同一知识库中已回答的问题足够相似时直接返回缓存的回答。
+// 与查询缓存共用知识库内容版本，知识库变化后旧的回答不再命中
+type semanticCache struct {
+	embedder  Embedder
+	threshold float64
+	capacity  int // 每个知识库最多保存的问题数
+	ttl       time.Duration
+	versions  *kbVersionStore
+
+	mu      sync.Mutex
+	entries map[string][]*semanticEntry // 知识库名称 -> 问题，按加入顺序
+}
+
+// semantic 是服务使用的全局语义缓存，关闭时为空
+var semantic *semanticCache
+
+// newSemanticCache 按配置创建语义缓存，关闭时返回 nil
+func newSemanticCache(mode string, threshold float64, capacity int, ttl time.Duration, versions *kbVersionStore) (*semanticCache, error) {
+	var embedder Embedder
+	switch strings.ToLower(mode) {
+	case semanticCacheOff, "":
+		return nil, nil
+	case semanticCacheLocal:
+		embedder = hashingEmbedder{dims: localEmbeddingDims}
+	default:
+		return nil, fmt.Errorf("invalid MKB_SEMANTIC_CACHE %q, use local or off", mode)
+	}
+	if threshold <= 0 || threshold > 1 {
+		return nil, fmt.Errorf("invalid MKB_SEMANTIC_CACHE_THRESHOLD %v, use a value in (0, 1]", threshold)
+	}
+	if capacity <= 0 || ttl <= 0 {
+		return nil, fmt.Errorf("semantic cache size and TTL must be positive")
+	}
+	return &semanticCache{
+		embedder:  embedder,
+		threshold: threshold,
+		capacity:  capacity,
+		ttl:       ttl,
+		versions:  versions,
+		entries:   make(map[string][]*semanticEntry),
+	}, nil
+}
+
+// Embed 把问题转换为向量
+func (s *semanticCache) Embed(ctx context.Context, question string) ([]float32, error) {
+	return s.embedder.Embed(ctx, question)
+}
+
+// Version 返回知识库当前的内容版本，回答前读取，保存回答时传给 Store
+func (s *semanticCache) Version(kb string) int64 {
+	return s.versions.Version(kb)
+}
+
+// Lookup 在知识库中查找相似度不低于阈值的最相似问题，同时清理过期和知识库变化前的回答
+func (s *semanticCache) Lookup(kb string, vector []float32, now time.Time) (semanticMatch, bool) {
+	version := s.versions.Version(kb)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *semanticEntry
+	var bestSimilarity float64
+	kept := s.entries[kb][:0]
+	for _, entry := range s.entries[kb] {
+		if entry.version != version || !now.Before(entry.expires) {
+			continue
+		}
+		kept = append(kept, entry)
+		if similarity := cosineSimilarity(vector, entry.vector); similarity >= s.threshold && similarity > bestSimilarity {
+			best, bestSimilarity = entry, similarity
+		}
+	}
+	s.setLocked(kb, kept)
+
+	if best == nil {
+		queryCacheRequests.Inc(queryCacheSemantic, "miss")
+		return semanticMatch{}, false
+	}
+	queryCacheRequests.Inc(queryCacheSemantic, "hit")
+	return semanticMatch{Question: best.question, Similarity: bestSimilarity, answer: best.answer}, true
+}
+
+// Store 保存问题的回答，version 为回答前读取的知识库内容版本；超出容量时淘汰最早的问题
+func (s *semanticCache) Store(kb, question string, vector []float32, answer semanticAnswer, version int64, now time.Time) {
+	if version != s.versions.Version(kb) {
+		return // 回答期间知识库已经变化
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := append(s.entries[kb], &semanticEntry{
+		question: question,
+		vector:   vector,
+		answer:   answer,
+		version:  version,
+		expires:  now.Add(s.ttl),
+	})
+	if len(entries) > s.capacity {
+		entries = append([]*semanticEntry(nil), entries[len(entries)-s.capacity:]...)
+	}
+	s.setLocked(kb, entries)
+}
+
+func (s *semanticCache) setLocked(kb string, entries []*semanticEntry) {
+	if len(entries) == 0 {
+		delete(s.entries, kb)
+		return
+	}
+	s.entries[kb] = entries
+}
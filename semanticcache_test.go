@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHashingEmbedderDeterministic(t *testing.T) {
+	embedder := hashingEmbedder{dims: localEmbeddingDims}
+	a, _ := embedder.Embed(context.Background(), "如何  重置密码？")
+	b, _ := embedder.Embed(context.Background(), "如何 重置密码?")
+	if got := cosineSimilarity(a, b); got < 0.999 {
+		t.Fatalf("similarity of the same question = %v", got)
+	}
+}
+
+func TestSemanticCacheLookup(t *testing.T) {
+	versions, _ := newKBVersionStore("")
+	cache, err := newSemanticCache(semanticCacheLocal, 0.8, 10, time.Minute, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	stored, _ := cache.Embed(ctx, "How do I reset my password")
+	cache.Store("kb_a", "How do I reset my password", stored, semanticAnswer{Answer: "Use the settings page"}, cache.Version("kb_a"), now)
+
+	similar, _ := cache.Embed(ctx, "how do i reset my password?")
+	match, ok := cache.Lookup("kb_a", similar, now)
+	if !ok || match.answer.Answer != "Use the settings page" || match.Question != "How do I reset my password" {
+		t.Fatalf("Lookup() = %+v, %v", match, ok)
+	}
+
+	// 其他知识库和不相关的问题不命中
+	if _, ok := cache.Lookup("kb_b", similar, now); ok {
+		t.Fatal("other knowledge base should not match")
+	}
+	unrelated, _ := cache.Embed(ctx, "Which file formats can be uploaded")
+	if _, ok := cache.Lookup("kb_a", unrelated, now); ok {
+		t.Fatal("unrelated question should not match")
+	}
+
+	// 过期后不命中
+	if _, ok := cache.Lookup("kb_a", similar, now.Add(time.Minute)); ok {
+		t.Fatal("expired answer should not match")
+	}
+}
+
+func TestSemanticCacheInvalidatedByVersion(t *testing.T) {
+	versions, _ := newKBVersionStore("")
+	cache, err := newSemanticCache(semanticCacheLocal, 0.8, 10, time.Minute, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	vector, _ := cache.Embed(ctx, "what is the refund policy")
+
+	// 回答期间知识库变化，不保存回答
+	version := cache.Version("kb_a")
+	versions.Bump("kb_a")
+	cache.Store("kb_a", "what is the refund policy", vector, semanticAnswer{Answer: "stale"}, version, now)
+	if _, ok := cache.Lookup("kb_a", vector, now); ok {
+		t.Fatal("answer computed before the change should not be stored")
+	}
+
+	cache.Store("kb_a", "what is the refund policy", vector, semanticAnswer{Answer: "fresh"}, cache.Version("kb_a"), now)
+	if _, ok := cache.Lookup("kb_a", vector, now); !ok {
+		t.Fatal("answer should match before the knowledge base changes")
+	}
+	versions.Bump("kb_a")
+	if _, ok := cache.Lookup("kb_a", vector, now); ok {
+		t.Fatal("answer should not match after the knowledge base changes")
+	}
+}
+
+func TestNewSemanticCacheConfig(t *testing.T) {
+	versions, _ := newKBVersionStore("")
+	if cache, err := newSemanticCache(semanticCacheOff, 0.9, 10, time.Minute, versions); cache != nil || err != nil {
+		t.Fatalf("off mode = %v, %v", cache, err)
+	}
+	if _, err := newSemanticCache("remote", 0.9, 10, time.Minute, versions); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+	if _, err := newSemanticCache(semanticCacheLocal, 1.5, 10, time.Minute, versions); err == nil {
+		t.Fatal("expected error for invalid threshold")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"viking_db_tool"
+)
+
+// sharedAllUsers 表示所有用户都可以检索该共享知识库
+const sharedAllUsers = "*"
+
+// errKnowledgeBaseForbidden 表示用户请求检索无权访问的知识库
+var errKnowledgeBaseForbidden = errors.New("knowledge base not accessible")
+
+// sharedKnowledgeBases 记录共享知识库（如团队知识库）及可以检索它的用户，在 main 中初始化。
+// 共享知识库由管理员在知识库平台维护，本服务只检索，不写入
+var sharedKnowledgeBases map[string][]string
+
+// searchFusion 是同时检索多个知识库时合并结果的方式，在 main 中初始化
+var searchFusion viking_db_tool.FusionMethod
+
+// parseSharedKnowledgeBases 解析 MKB_SHARED_KNOWLEDGE_BASES 配置，为空时没有共享知识库
+func parseSharedKnowledgeBases(value string) (map[string][]string, error) {
+	shared := make(map[string][]string)
+	if value == "" {
+		return shared, nil
+	}
+	if err := json.Unmarshal([]byte(value), &shared); err != nil {
+		return nil, fmt.Errorf("invalid MKB_SHARED_KNOWLEDGE_BASES: %w", err)
+	}
+	return shared, nil
+}
+
+// canSearchShared 返回用户是否可以检索共享知识库
+func canSearchShared(userID, kb string) bool {
+	for _, allowed := range sharedKnowledgeBases[kb] {
+		if allowed == userID || allowed == sharedAllUsers {
+			return true
+		}
+	}
+	return false
+}
+
+// searchKnowledgeBaseNames 返回对话要检索的知识库名称，个人知识库在前。
+// requested 为空时检索个人知识库和用户可以访问的所有共享知识库；
+// 否则只检索 requested 中的知识库，包含无权访问的知识库时返回 errKnowledgeBaseForbidden
+func searchKnowledgeBaseNames(userID string, requested []string) ([]string, error) {
+	personal := knowledgeBaseName(userID)
+	if len(requested) == 0 {
+		names := []string{personal}
+		var shared []string
+		for kb := range sharedKnowledgeBases {
+			if kb != personal && canSearchShared(userID, kb) {
+				shared = append(shared, kb)
+			}
+		}
+		sort.Strings(shared)
+		return append(names, shared...), nil
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, kb := range requested {
+		if seen[kb] {
+			continue
+		}
+		if kb != personal && !canSearchShared(userID, kb) {
+			return nil, fmt.Errorf("%w: %s", errKnowledgeBaseForbidden, kb)
+		}
+		seen[kb] = true
+		if kb == personal {
+			names = append([]string{kb}, names...)
+		} else {
+			names = append(names, kb)
+		}
+	}
+	return names, nil
+}
+
+// includesShared 返回要检索的知识库中是否有共享知识库。
+// 共享知识库由管理员在知识库平台维护，本服务无法得知其内容变化，检索共享知识库时不使用查询缓存和语义缓存
+func includesShared(names []string, personal string) bool {
+	for _, name := range names {
+		if name != personal {
+			return true
+		}
+	}
+	return false
+}
+
+// knowledgeBaseVersions 返回各知识库的内容版本，作为缓存键的一部分
+func knowledgeBaseVersions(versions *kbVersionStore, names []string) map[string]int64 {
+	result := make(map[string]int64, len(names))
+	for _, name := range names {
+		result[name] = versions.Version(name)
+	}
+	return result
+}
+
+// searchTargets 把知识库名称转换为检索目标，personalResourceID 为个人知识库的 ResourceID
+func searchTargets(names []string, personal, personalResourceID, project string) []viking_db_tool.SearchTarget {
+	targets := make([]viking_db_tool.SearchTarget, 0, len(names))
+	for _, name := range names {
+		target := viking_db_tool.SearchTarget{Name: name, Project: project}
+		if name == personal {
+			target.ResourceId = personalResourceID
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
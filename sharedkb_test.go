@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSearchKnowledgeBaseNames(t *testing.T) {
+	shared, err := parseSharedKnowledgeBases(`{"kb_team_sales":["u1","u2"],"kb_handbook":["*"],"kb_team_ops":["u3"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := sharedKnowledgeBases
+	sharedKnowledgeBases = shared
+	defer func() { sharedKnowledgeBases = saved }()
+
+	// 未指定时检索个人知识库和可以访问的所有共享知识库
+	names, err := searchKnowledgeBaseNames("u1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kb_u1", "kb_handbook", "kb_team_sales"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+
+	// 指定时个人知识库排在前面，重复的只检索一次
+	names, err = searchKnowledgeBaseNames("u1", []string{"kb_team_sales", "kb_u1", "kb_team_sales"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"kb_u1", "kb_team_sales"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+
+	// 其他用户的知识库和无权访问的共享知识库
+	for _, kb := range []string{"kb_u2", "kb_team_ops"} {
+		if _, err := searchKnowledgeBaseNames("u1", []string{kb}); !errors.Is(err, errKnowledgeBaseForbidden) {
+			t.Errorf("searchKnowledgeBaseNames(%s) error = %v, want forbidden", kb, err)
+		}
+	}
+}
+
+func TestParseSharedKnowledgeBasesInvalid(t *testing.T) {
+	if _, err := parseSharedKnowledgeBases(`["kb_team"]`); err == nil {
+		t.Fatal("expected error for invalid config")
+	}
+}
+
+func TestIncludesShared(t *testing.T) {
+	personal := knowledgeBaseName("u1")
+	// 只检索个人知识库时可以使用缓存，共享知识库的内容变化无法得知，包含共享知识库时不使用缓存
+	if includesShared([]string{personal}, personal) {
+		t.Error("personal knowledge base reported as shared")
+	}
+	if !includesShared([]string{personal, "kb_handbook"}, personal) {
+		t.Error("personal and shared knowledge bases not reported as shared")
+	}
+	if !includesShared([]string{"kb_handbook"}, personal) {
+		t.Error("shared knowledge base alone not reported as shared")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// workTracker 跟踪进行中的写请求和后台任务。退出时先停止接受新的工作，再等待已有的工作完成
+type workTracker struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	wg       sync.WaitGroup
+}
+
+// inflight 是服务使用的全局工作跟踪器
+var inflight = &workTracker{}
+
+// Start 登记一项新工作，开始退出后返回 false；返回 true 时调用方需在完成后调用 Done
+func (t *workTracker) Start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.active++
+	t.wg.Add(1)
+	return true
+}
+
+// Done 结束一项工作
+func (t *workTracker) Done() {
+	t.mu.Lock()
+	t.active--
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// Go 在新的 goroutine 中运行后台任务，开始退出后不再启动
+func (t *workTracker) Go(fn func()) bool {
+	if !t.Start() {
+		return false
+	}
+	go func() {
+		defer t.Done()
+		fn()
+	}()
+	return true
+}
+
+// Drain 停止接受新的工作
+func (t *workTracker) Drain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = true
+}
+
+// Draining 返回是否已经开始退出
+func (t *workTracker) Draining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// Active 返回进行中的工作数
+func (t *workTracker) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// Wait 等待所有工作完成，ctx 到期时返回 ctx 的错误
+func (t *workTracker) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainMiddleware 跟踪进行中的写请求，开始退出后拒绝新的写请求；查询请求不受影响
+func drainMiddleware(ctx context.Context, c *app.RequestContext) {
+	switch string(c.Method()) {
+	case consts.MethodGet, consts.MethodHead, consts.MethodOptions:
+		c.Next(ctx)
+		return
+	}
+
+	if !inflight.Start() {
+		c.Header("Retry-After", "5")
+		c.AbortWithStatusJSON(consts.StatusServiceUnavailable, utils.H{
+			"error": "Server is shutting down, retry later",
+		})
+		return
+	}
+	defer inflight.Done()
+	c.Next(ctx)
+}
+
+// waitForShutdownSignal 等待 SIGINT 或 SIGTERM 后开始优雅退出，再次收到信号时立即退出。
+// Hertz 默认收到 SIGTERM 时直接退出，会中断进行中的上传
+func waitForShutdownSignal(errCh chan error) error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-signals:
+		slog.Info("Received signal, shutting down gracefully", "signal", sig.String(), "timeout", cfg.ShutdownTimeout)
+		go func() {
+			sig := <-signals
+			slog.Warn("Received second signal, exiting immediately", "signal", sig.String())
+			os.Exit(1)
+		}()
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+// drainAndPersist 在退出时调用：停止接受新的写请求和后台任务，在 ctx 到期前等待进行中的工作完成，
+// 并保存仍在知识库中处理的文档，下次启动继续跟踪。超时未完成的写入已记录在未完成工作表中
+func drainAndPersist(ctx context.Context, stopBackground context.CancelFunc) {
+	inflight.Drain()
+	stopBackground()
+
+	if err := inflight.Wait(ctx); err != nil {
+		slog.Warn("Shutdown deadline reached before all work finished", "in_flight", inflight.Active(), "error", err)
+	} else {
+		slog.Info("All in-flight work finished")
+	}
+
+	if err := pending.SaveWatches(statusWatcher.Watches()); err != nil {
+		slog.Error("Failed to save tracked documents", "error", err)
+	}
+}
+
+// cleanUploadDir 删除上传目录中上次运行遗留的临时文件，上传目录只存放处理中的临时文件
+func cleanUploadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if len(entries) > 0 {
+		slog.Info("Removed leftover temporary files", "dir", dir, "count", len(entries))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWorkTrackerDrain(t *testing.T) {
+	tracker := &workTracker{}
+	if !tracker.Start() {
+		t.Fatal("Start before draining = false, want true")
+	}
+	release := make(chan struct{})
+	if !tracker.Go(func() { <-release }) {
+		t.Fatal("Go before draining = false, want true")
+	}
+
+	tracker.Drain()
+	if tracker.Start() {
+		t.Fatal("Start after draining = true, want false")
+	}
+	if tracker.Go(func() {}) {
+		t.Fatal("Go after draining = true, want false")
+	}
+	if got := tracker.Active(); got != 2 {
+		t.Fatalf("Active() = %d, want 2", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tracker.Wait(ctx); err == nil {
+		t.Fatal("Wait with work in progress = nil, want deadline error")
+	}
+
+	tracker.Done()
+	close(release)
+	if err := tracker.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait after all work finished = %v", err)
+	}
+}
+
+func TestPendingWorkStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), pendingWorkFile)
+	store, err := newPendingWorkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := DocumentVersion{Version: 2, ObjectKey: "users/u1/uploads/versions/a_md/v2/a.md"}
+	record := DocumentRecord{DocID: "a_md", UserID: "u1", Name: "a.md", Version: 2, Versions: []DocumentVersion{v}}
+	if err := store.BeginIngest(record, v); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.BeginIngest(DocumentRecord{DocID: "b_md", UserID: "u1"}, DocumentVersion{Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.FinishIngest("u1", "b_md"); err != nil {
+		t.Fatal(err)
+	}
+	watches := []pendingWatch{{UserID: "u1", ResourceID: "kb-1", DocID: "c_md", DocName: "c.md", ProcessStatus: 3}}
+	if err := store.SaveWatches(watches); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载后继续未完成的工作
+	reloaded, err := newPendingWorkStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingests := reloaded.Ingests()
+	if len(ingests) != 1 || ingests[0].Record.DocID != "a_md" || ingests[0].Version.Version != 2 {
+		t.Fatalf("Ingests() = %+v, want the unfinished a_md v2", ingests)
+	}
+	got, err := reloaded.TakeWatches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != watches[0] {
+		t.Fatalf("TakeWatches() = %+v, want %+v", got, watches)
+	}
+	if got, _ := reloaded.TakeWatches(); len(got) != 0 {
+		t.Fatalf("second TakeWatches() = %+v, want empty", got)
+	}
+}
+
+func TestCleanUploadDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"report.pdf", "archive-member-123"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cleanUploadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("cleanUploadDir left %d entries", len(entries))
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/hertz/pkg/protocol/http1/resp"
+)
+
+// 状态事件类型
+const (
+	statusEventSnapshot = "snapshot" // 订阅时推送的当前处理中文档
+	statusEventStatus   = "status"   // 处理状态发生变化
+	statusEventReady    = "ready"    // 处理完成，终止事件
+	statusEventFailed   = "failed"   // 处理失败，终止事件
+	statusEventRemoved  = "removed"  // 文档已从知识库删除，终止事件
+	statusEventTimeout  = "timeout"  // 超过最长跟踪时间仍未完成，终止事件
+)
+
+const (
+	watchTick            = time.Second      // 检查到期文档的间隔
+	watchInitialInterval = 2 * time.Second  // 单个文档的初始查询间隔
+	watchMaxInterval     = time.Minute      // 状态没有变化时查询间隔翻倍，直到该上限
+	watchMaxDuration     = 2 * time.Hour    // 单个文档的最长跟踪时间
+	sseHeartbeatInterval = 15 * time.Second // SSE 心跳间隔，防止代理断开空闲连接
+	subscriberBuffer     = 32
+)
+
+// statusEvent 是推送给订阅者的文档状态变化
+type statusEvent struct {
+	Type          string    `json:"type"`
+	UserID        string    `json:"user_id"`
+	DocID         string    `json:"doc_id"`
+	DocName       string    `json:"doc_name,omitempty"`
+	ProcessStatus int       `json:"process_status"`
+	StatusText    string    `json:"status_text,omitempty"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"` // 处理失败的原因，仅 failed 事件
+	Time          time.Time `json:"time"`
+}
+
+// isInFlight 返回处理状态是否为排队中、删除中或处理中
+func isInFlight(processStatus int) bool {
+	switch processStatus {
+	case 2, 3, docStatusDeleting, 6:
+		return true
+	}
+	return false
+}
+
+// trackedDoc 是正在跟踪的处理中文档
+type trackedDoc struct {
+	UserID        string
+	DocID         string
+	DocName       string
+	ResourceID    string
+	ProcessStatus int // 尚未查询到状态时为 -1
+	Interval      time.Duration
+	NextPoll      time.Time
+	Since         time.Time
+}
+
+// documentStatusWatcher 在服务端轮询处理中文档的状态，并把状态变化推送给订阅者
+type documentStatusWatcher struct {
+	mu          sync.Mutex
+	docs        map[string]*trackedDoc
+	subscribers map[string]map[chan statusEvent]bool // 用户ID -> 订阅通道
+	loaded      map[string]bool                      // 已从知识库加载过处理中文档的用户
+}
+
+// statusWatcher 是服务使用的全局文档状态监视器
+var statusWatcher = newDocumentStatusWatcher()
+
+func newDocumentStatusWatcher() *documentStatusWatcher {
+	return &documentStatusWatcher{
+		docs:        make(map[string]*trackedDoc),
+		subscribers: make(map[string]map[chan statusEvent]bool),
+		loaded:      make(map[string]bool),
+	}
+}
+
+// Track 开始跟踪一个刚写入知识库的文档，直到处理完成或失败
+func (w *documentStatusWatcher) Track(userID, resourceID, docID, docName string) {
+	w.track(userID, resourceID, docID, docName, -1)
+}
+
+func (w *documentStatusWatcher) track(userID, resourceID, docID, docName string, processStatus int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	w.docs[registryKey(userID, docID)] = &trackedDoc{
+		UserID:        userID,
+		DocID:         docID,
+		DocName:       docName,
+		ResourceID:    resourceID,
+		ProcessStatus: processStatus,
+		Interval:      watchInitialInterval,
+		NextPoll:      now.Add(watchInitialInterval),
+		Since:         now,
+	}
+}
+
+// ProcessStatuses 返回正在跟踪的文档最近查询到的处理状态，键为 registryKey，尚未查询到时为 -1
+func (w *documentStatusWatcher) ProcessStatuses() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	statuses := make(map[string]int, len(w.docs))
+	for key, doc := range w.docs {
+		statuses[key] = doc.ProcessStatus
+	}
+	return statuses
+}
+
+// Watches 返回正在跟踪的文档，退出时保存，下次启动继续跟踪
+func (w *documentStatusWatcher) Watches() []pendingWatch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watches := make([]pendingWatch, 0, len(w.docs))
+	for _, key := range sortedKeys(w.docs) {
+		doc := w.docs[key]
+		watches = append(watches, pendingWatch{
+			UserID:        doc.UserID,
+			ResourceID:    doc.ResourceID,
+			DocID:         doc.DocID,
+			DocName:       doc.DocName,
+			ProcessStatus: doc.ProcessStatus,
+		})
+	}
+	return watches
+}
+
+// Subscribe 订阅用户的文档状态变化，返回的取消函数会关闭通道
+func (w *documentStatusWatcher) Subscribe(userID string) (<-chan statusEvent, func()) {
+	ch := make(chan statusEvent, subscriberBuffer)
+
+	w.mu.Lock()
+	if w.subscribers[userID] == nil {
+		w.subscribers[userID] = make(map[chan statusEvent]bool)
+	}
+	w.subscribers[userID][ch] = true
+	w.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			if w.subscribers[userID][ch] {
+				delete(w.subscribers[userID], ch)
+				close(ch)
+			}
+			if len(w.subscribers[userID]) == 0 {
+				delete(w.subscribers, userID)
+			}
+		})
+	}
+}
+
+// Snapshot 返回用户当前正在跟踪的文档。用户第一次订阅时从知识库加载处理中的文档，
+// 以便跟踪服务重启前写入的文档
+func (w *documentStatusWatcher) Snapshot(ctx context.Context, userID string) ([]statusEvent, error) {
+	w.mu.Lock()
+	loaded := w.loaded[userID]
+	w.mu.Unlock()
+
+	if !loaded {
+		exists, resourceID, err := viking_db_tool.CheckKnowledgeBaseExists(ctx, knowledgeBaseName(userID), knowledgeBaseProject)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			for doc, err := range viking_db_tool.Documents(ctx, resourceID) {
+				if err != nil {
+					return nil, err
+				}
+				if isInFlight(doc.Status.ProcessStatus) && !w.isTracked(userID, doc.DocID) {
+					w.track(userID, resourceID, doc.DocID, doc.DocName, doc.Status.ProcessStatus)
+				}
+			}
+		}
+		w.mu.Lock()
+		w.loaded[userID] = true
+		w.mu.Unlock()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []statusEvent
+	for _, doc := range w.docs {
+		if doc.UserID == userID {
+			events = append(events, newStatusEvent(statusEventSnapshot, doc))
+		}
+	}
+	return events, nil
+}
+
+func (w *documentStatusWatcher) isTracked(userID, docID string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.docs[registryKey(userID, docID)]
+	return ok
+}
+
+// Run 定期查询到期文档的状态，直到 ctx 取消；退出时关闭所有订阅通道
+func (w *documentStatusWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(watchTick)
+	defer ticker.Stop()
+	defer w.closeSubscribers()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, doc := range w.due(time.Now()) {
+				w.poll(ctx, doc)
+			}
+		}
+	}
+}
+
+// due 返回到了查询时间的文档副本
+func (w *documentStatusWatcher) due(now time.Time) []trackedDoc {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var docs []trackedDoc
+	for _, doc := range w.docs {
+		if !doc.NextPoll.After(now) {
+			docs = append(docs, *doc)
+		}
+	}
+	return docs
+}
+
+// poll 查询单个文档的状态，状态变化时推送事件并重置查询间隔，否则按指数退避
+func (w *documentStatusWatcher) poll(ctx context.Context, doc trackedDoc) {
+	info, err := viking_db_tool.GetDocumentInfo(ctx, viking_db_tool.DocumentInfoRequest{
+		ResourceID:       doc.ResourceID,
+		DocID:            doc.DocID,
+		ReturnTokenUsage: true,
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := registryKey(doc.UserID, doc.DocID)
+	tracked, ok := w.docs[key]
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get document status", "user_id", doc.UserID, "doc_id", doc.DocID, "error", err)
+		w.backoffLocked(tracked)
+		return
+	}
+
+	// 文档不存在说明已被删除
+	if viking_db_tool.IsDocumentNotFound(info.Code) {
+		queryCache.Invalidate(ctx, doc.UserID)
+		delete(w.docs, key)
+		w.publishLocked(newStatusEvent(statusEventRemoved, tracked))
+		return
+	}
+	// 限流、服务端错误等其他返回码只说明本次查询失败，稍后重试
+	if info.Code != 0 || info.Data == nil {
+		slog.WarnContext(ctx, "Failed to get document status", "user_id", doc.UserID, "doc_id", doc.DocID,
+			"code", info.Code, "message", info.Message, "kb_request_id", info.RequestID)
+		w.backoffLocked(tracked)
+		return
+	}
+
+	status := info.Data.Status.ProcessStatus
+	if info.Data.DocName != "" {
+		tracked.DocName = info.Data.DocName
+	}
+
+	changed := status != tracked.ProcessStatus
+	tracked.ProcessStatus = status
+	// 处理完成或失败后知识库中可检索的内容发生变化
+	if status == 0 || status == docStatusFailed {
+		queryCache.Invalidate(ctx, doc.UserID)
+	}
+	switch {
+	case status == 0:
+		delete(w.docs, key)
+		w.publishLocked(newStatusEvent(statusEventReady, tracked))
+		if err := registry.RecordTokens(doc.UserID, doc.DocID, info.Data.TotalTokens); err != nil {
+			slog.ErrorContext(ctx, "Failed to save document record", "doc_id", doc.DocID, "error", err)
+		}
+	case status == docStatusFailed:
+		delete(w.docs, key)
+		event := newStatusEvent(statusEventFailed, tracked)
+		event.Error = failureReason(info.Data.Status)
+		w.publishLocked(event)
+		if err := registry.RecordFailure(doc.UserID, doc.DocID, event.Error); err != nil {
+			slog.ErrorContext(ctx, "Failed to save document record", "doc_id", doc.DocID, "error", err)
+		}
+	case time.Since(tracked.Since) > watchMaxDuration:
+		delete(w.docs, key)
+		w.publishLocked(newStatusEvent(statusEventTimeout, tracked))
+	case changed:
+		tracked.Interval = watchInitialInterval
+		tracked.NextPoll = time.Now().Add(tracked.Interval)
+		w.publishLocked(newStatusEvent(statusEventStatus, tracked))
+	default:
+		w.backoffLocked(tracked)
+	}
+}
+
+func (w *documentStatusWatcher) backoffLocked(doc *trackedDoc) {
+	doc.Interval = min(doc.Interval*2, watchMaxInterval)
+	doc.NextPoll = time.Now().Add(doc.Interval)
+}
+
+// publishLocked 把事件发送给用户的所有订阅者，订阅者处理不过来时丢弃事件
+func (w *documentStatusWatcher) publishLocked(event statusEvent) {
+	for ch := range w.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("Dropped status event, subscriber is too slow", "user_id", event.UserID, "doc_id", event.DocID)
+		}
+	}
+}
+
+func (w *documentStatusWatcher) closeSubscribers() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for userID, channels := range w.subscribers {
+		for ch := range channels {
+			close(ch)
+		}
+		delete(w.subscribers, userID)
+	}
+}
+
+func newStatusEvent(eventType string, doc *trackedDoc) statusEvent {
+	event := statusEvent{
+		Type:          eventType,
+		UserID:        doc.UserID,
+		DocID:         doc.DocID,
+		DocName:       doc.DocName,
+		ProcessStatus: doc.ProcessStatus,
+		Status:        "unknown",
+		Time:          time.Now(),
+	}
+	if doc.ProcessStatus >= 0 {
+		info := viking_db_tool.NewDocumentStatusInfo(viking_db_tool.DocumentInfo{
+			Status: viking_db_tool.DocumentProcessingStatus{ProcessStatus: doc.ProcessStatus},
+		})
+		event.StatusText = info.StatusText
+		event.Status = docStatusKey(doc.ProcessStatus)
+	}
+	return event
+}
+
+// 通过 SSE 推送文档处理状态变化
+func documentEvents(ctx context.Context, c *app.RequestContext) {
+	userID := c.Query("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	events, cancel := statusWatcher.Subscribe(userID)
+	defer cancel()
+
+	snapshot, err := statusWatcher.Snapshot(ctx, userID)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to load document status: " + err.Error(),
+		})
+		return
+	}
+
+	c.SetStatusCode(consts.StatusOK)
+	c.Response.Header.Set("Content-Type", "text/event-stream")
+	c.Response.Header.Set("Cache-Control", "no-cache")
+	c.Response.Header.Set("Connection", "keep-alive")
+	c.Response.Header.Set("X-Accel-Buffering", "no")
+	c.Response.HijackWriter(resp.NewChunkedBodyWriter(&c.Response, c.GetWriter()))
+
+	for _, event := range snapshot {
+		if err := writeSSE(c, event.Type, event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeSSE(c, event.Type, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			// 写入失败说明客户端已断开
+			if _, err := c.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+			if err := c.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeSSE 写入一条 SSE 事件并立即发送
+func writeSSE(c *app.RequestContext, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, payload))); err != nil {
+		return err
+	}
+	return c.Flush()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"viking_db_tool"
+)
+
+func TestStatusWatcherPublish(t *testing.T) {
+	w := newDocumentStatusWatcher()
+	events, cancel := w.Subscribe("u1")
+	other, cancelOther := w.Subscribe("u2")
+	defer cancelOther()
+
+	w.Track("u1", "kb-1", "atxt", "a.txt")
+	doc := w.docs[registryKey("u1", "atxt")]
+	doc.ProcessStatus = 6
+
+	w.mu.Lock()
+	w.publishLocked(newStatusEvent(statusEventStatus, doc))
+	w.mu.Unlock()
+
+	select {
+	case event := <-events:
+		if event.DocID != "atxt" || event.Status != "processing" || event.StatusText != "处理中" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	default:
+		t.Fatal("expected an event for u1")
+	}
+	select {
+	case event := <-other:
+		t.Errorf("u2 should not receive u1 events: %+v", event)
+	default:
+	}
+
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed after cancel")
+	}
+}
+
+func TestStatusWatcherBackoff(t *testing.T) {
+	w := newDocumentStatusWatcher()
+	w.Track("u1", "kb-1", "atxt", "a.txt")
+	doc := w.docs[registryKey("u1", "atxt")]
+
+	for i := 0; i < 10; i++ {
+		w.backoffLocked(doc)
+	}
+	if doc.Interval != watchMaxInterval {
+		t.Errorf("Interval = %v, want %v", doc.Interval, watchMaxInterval)
+	}
+
+	if due := w.due(time.Now()); len(due) != 0 {
+		t.Errorf("doc should not be due yet: %+v", due)
+	}
+	if due := w.due(time.Now().Add(watchMaxInterval)); len(due) != 1 {
+		t.Errorf("doc should be due after the interval, got %d", len(due))
+	}
+
+	// 尚未查询到状态的文档状态为 unknown
+	if event := newStatusEvent(statusEventSnapshot, doc); event.Status != "unknown" || event.ProcessStatus != -1 {
+		t.Errorf("unexpected snapshot event: %+v", event)
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		status viking_db_tool.DocumentProcessingStatus
+		want   string
+	}{
+		{viking_db_tool.DocumentProcessingStatus{ProcessStatus: 1}, "知识库处理失败"},
+		{viking_db_tool.DocumentProcessingStatus{ProcessStatus: 1, FailedMsg: "bad file"}, "bad file"},
+		{viking_db_tool.DocumentProcessingStatus{ProcessStatus: 1, FailedMsg: "bad file", FailedCode: 7}, "bad file (code 7)"},
+	}
+	for _, tt := range tests {
+		if got := failureReason(tt.status); got != tt.want {
+			t.Errorf("failureReason(%+v) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestStatusWatcherPollErrorCodes(t *testing.T) {
+	var code atomic.Int64
+	code.Store(1000029) // 限流
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":` + strconv.FormatInt(code.Load(), 10) + `,"message":"error"}`))
+	}))
+	defer server.Close()
+	savedDomain, savedTransport := viking_db_tool.KnowledgeBaseDomain, http.DefaultTransport
+	viking_db_tool.KnowledgeBaseDomain = strings.TrimPrefix(server.URL, "https://")
+	http.DefaultTransport = server.Client().Transport
+	defer func() {
+		viking_db_tool.KnowledgeBaseDomain, http.DefaultTransport = savedDomain, savedTransport
+	}()
+
+	w := newDocumentStatusWatcher()
+	w.Track("u1", "kb-1", "atxt", "a.txt")
+	events, cancel := w.Subscribe("u1")
+	defer cancel()
+	drain := func() []statusEvent {
+		var got []statusEvent
+		for {
+			select {
+			case event := <-events:
+				got = append(got, event)
+			default:
+				return got
+			}
+		}
+	}
+	drain()
+
+	// 限流等返回码不代表文档已删除，继续跟踪并退避
+	doc := *w.docs[registryKey("u1", "atxt")]
+	w.poll(context.Background(), doc)
+	if _, ok := w.docs[registryKey("u1", "atxt")]; !ok {
+		t.Fatal("document should still be tracked after a transient error")
+	}
+	if got := w.docs[registryKey("u1", "atxt")].Interval; got <= doc.Interval {
+		t.Errorf("Interval = %v, want backoff from %v", got, doc.Interval)
+	}
+	if got := drain(); len(got) != 0 {
+		t.Errorf("unexpected events: %+v", got)
+	}
+
+	// 文档不存在时停止跟踪并推送 removed
+	code.Store(viking_db_tool.CodeDocNotExist)
+	w.poll(context.Background(), *w.docs[registryKey("u1", "atxt")])
+	if _, ok := w.docs[registryKey("u1", "atxt")]; ok {
+		t.Fatal("document should no longer be tracked")
+	}
+	if got := drain(); len(got) != 1 || got[0].Type != statusEventRemoved {
+		t.Errorf("events = %+v, want one removed event", got)
+	}
+}
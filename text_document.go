@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+const maxTextDocumentSize = 10 << 20 // 10MB
+
+// textDocumentExtension 返回文本格式对应的文件后缀
+func textDocumentExtension(format string) (string, bool) {
+	switch strings.ToLower(format) {
+	case "", "txt", "text":
+		return ".txt", true
+	case "md", "markdown":
+		return ".md", true
+	default:
+		return "", false
+	}
+}
+
+// 通过粘贴的文本或markdown创建知识库文档
+func createTextDocument(ctx context.Context, c *app.RequestContext) {
+	var request struct {
+		UserID  string `json:"user_id"`
+		Name    string `json:"name"`
+		Format  string `json:"format"`
+		Content string `json:"content"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	if !requireUserID(c, request.UserID) {
+		return
+	}
+
+	if strings.TrimSpace(request.Content) == "" {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Content is required",
+		})
+		return
+	}
+
+	if len(request.Content) > maxTextDocumentSize {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": fmt.Sprintf("Content is too large. Max size is %d bytes", maxTextDocumentSize),
+		})
+		return
+	}
+
+	ext, ok := textDocumentExtension(request.Format)
+	if !ok {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Unsupported format, use txt or markdown",
+		})
+		return
+	}
+
+	// 没有名称时使用时间戳命名，没有后缀时按格式补全
+	filename := filepath.Base(strings.TrimSpace(request.Name))
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "note_" + time.Now().Format("20060102150405")
+	}
+	if filepath.Ext(filename) == "" {
+		filename += ext
+	}
+	if !requireFilename(c, filename) {
+		return
+	}
+	docType := getDocTypeByExtension(filename)
+	if docType != "txt" && docType != "markdown" {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Document name must end with .txt, .md or .markdown",
+		})
+		return
+	}
+
+	docID := generateDocID(filename)
+	if _, exists := registry.Get(request.UserID, docID); exists {
+		c.JSON(consts.StatusConflict, utils.H{
+			"error":  "Document already exists, use PUT /api/documents/" + docID + " to edit it",
+			"doc_id": docID,
+		})
+		return
+	}
+	if _, inTrash := registry.GetTrashed(request.UserID, docID); inTrash {
+		c.JSON(consts.StatusConflict, utils.H{
+			"error":  "Document is in trash, use POST /api/trash/" + docID + "/restore to restore it",
+			"doc_id": docID,
+		})
+		return
+	}
+
+	if err := checkQuota(request.UserID, int64(len(request.Content)), 1); err != nil {
+		writeQuotaError(c, err)
+		return
+	}
+
+	resourceID, err := ensureKnowledgeBase(ctx, request.UserID)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 同步保存一份到TOS，使其出现在文件列表中
+	objectKey := uploadObjectKey(request.UserID, filename)
+	v, err := storeVersionContent(ctx, request.UserID, docID, filename, objectKey, 1, []byte(request.Content))
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	record := DocumentRecord{
+		DocID:     docID,
+		UserID:    request.UserID,
+		Name:      filename,
+		DocType:   docType,
+		ObjectKey: objectKey,
+		Source:    documentSourceText,
+		Size:      v.Size,
+		Version:   1,
+		Versions:  []DocumentVersion{v},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// 预处理后的文本写入知识库，TOS中保留原文
+	// 写入失败时同样保存记录，以便查看失败原因并重新处理
+	processed, err := indexAndSave(ctx, resourceID, &record, v, []byte(request.Content), nil)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"message":    "Document created successfully",
+		"document":   record,
+		"url":        downloadPath(record.UserID, record.Name),
+		"preprocess": processed,
+	})
+}
+
+// 编辑文本文档，每次编辑生成新版本并重新索引
+func updateTextDocument(ctx context.Context, c *app.RequestContext) {
+	docID := c.Param("id")
+	if !requireDocID(c, docID) {
+		return
+	}
+
+	var request struct {
+		UserID  string `json:"user_id"`
+		Content string `json:"content"`
+		Version *int   `json:"version"` // 可选，编辑所基于的版本号，用于检测并发修改
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	if !requireUserID(c, request.UserID) {
+		return
+	}
+
+	if strings.TrimSpace(request.Content) == "" {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Content is required",
+		})
+		return
+	}
+
+	if len(request.Content) > maxTextDocumentSize {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": fmt.Sprintf("Content is too large. Max size is %d bytes", maxTextDocumentSize),
+		})
+		return
+	}
+
+	record, ok := registry.Get(request.UserID, docID)
+	if !ok {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "Document not found",
+		})
+		return
+	}
+
+	if record.DocType != "txt" && record.DocType != "markdown" {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Only txt and markdown documents can be edited",
+		})
+		return
+	}
+
+	if request.Version != nil && *request.Version != record.Version {
+		c.JSON(consts.StatusConflict, utils.H{
+			"error":           "Document has been modified, current version is " + strconv.Itoa(record.Version),
+			"current_version": record.Version,
+		})
+		return
+	}
+
+	// 历史版本保留在TOS中，新版本同样计入存储用量
+	if err := checkQuota(request.UserID, int64(len(request.Content)), 0); err != nil {
+		writeQuotaError(c, err)
+		return
+	}
+
+	resourceID, err := ensureKnowledgeBase(ctx, request.UserID)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 新内容保存为新版本，历史版本保留在TOS中
+	version := record.LatestVersion() + 1
+	v, err := storeVersionContent(ctx, request.UserID, docID, record.Name, record.ObjectKey, version, []byte(request.Content))
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	previous := record.ActiveVersion()
+	record.Version = version
+	record.Versions = append(record.Versions, v)
+	record.Size = v.Size
+	record.UpdatedAt = time.Now()
+
+	// 写入失败时同样保存记录，以便查看失败原因并重新处理
+	processed, err := indexAndSave(ctx, resourceID, &record, v, []byte(request.Content), &previous)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"message":    "Document updated successfully",
+		"document":   record,
+		"url":        downloadPath(record.UserID, record.Name),
+		"preprocess": processed,
+	})
+}
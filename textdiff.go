@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 差异行类型
+const (
+	diffEqual  = "equal"
+	diffDelete = "delete"
+	diffInsert = "insert"
+)
+
+const (
+	maxDiffCells = 4 << 20 // LCS 动态规划表的最大单元数，超过时把中间部分整体视为替换
+	diffContext  = 3       // unified diff 每个变更块保留的上下文行数
+)
+
+// diffLine 是逐行差异中的一行
+type diffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// diffLines 计算两组文本行之间的差异：先去掉公共前后缀，再对中间部分求最长公共子序列
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, line := range a[:prefix] {
+		lines = append(lines, diffLine{Op: diffEqual, Text: line})
+	}
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{Op: diffEqual, Text: line})
+	}
+	return lines
+}
+
+func diffMiddle(a, b []string) []diffLine {
+	var lines []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			lines = append(lines, diffLine{Op: diffDelete, Text: line})
+		}
+		for _, line := range b {
+			lines = append(lines, diffLine{Op: diffInsert, Text: line})
+		}
+		return lines
+	}
+
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	n, m := len(a), len(b)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{Op: diffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{Op: diffDelete, Text: a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{Op: diffInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		lines = append(lines, diffLine{Op: diffDelete, Text: a[i]})
+	}
+	for ; j < m; j++ {
+		lines = append(lines, diffLine{Op: diffInsert, Text: b[j]})
+	}
+	return lines
+}
+
+// formatUnifiedDiff 把逐行差异格式化为 unified diff 文本
+func formatUnifiedDiff(lines []diffLine, fromName, toName string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(lines); {
+		// 找到下一个变更
+		first := start
+		for first < len(lines) && lines[first].Op == diffEqual {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+
+		// 变更块向后延伸，直到连续的相同行超过两倍上下文
+		end := first
+		for end < len(lines) {
+			if lines[end].Op != diffEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].Op == diffEqual {
+				run++
+			}
+			if run == len(lines) || run-end > 2*diffContext {
+				break
+			}
+			end = run
+		}
+
+		hunkStart := max(first-diffContext, start)
+		hunkEnd := min(end+diffContext, len(lines))
+
+		// 计算变更块在两个版本中的起始行号和行数
+		fromLine, toLine := 1, 1
+		for _, line := range lines[:hunkStart] {
+			if line.Op != diffInsert {
+				fromLine++
+			}
+			if line.Op != diffDelete {
+				toLine++
+			}
+		}
+		fromCount, toCount := 0, 0
+		for _, line := range lines[hunkStart:hunkEnd] {
+			if line.Op != diffInsert {
+				fromCount++
+			}
+			if line.Op != diffDelete {
+				toCount++
+			}
+		}
+
+		fmt.Fprintf(&builder, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+		for _, line := range lines[hunkStart:hunkEnd] {
+			switch line.Op {
+			case diffDelete:
+				builder.WriteString("-")
+			case diffInsert:
+				builder.WriteString("+")
+			default:
+				builder.WriteString(" ")
+			}
+			builder.WriteString(line.Text)
+			builder.WriteString("\n")
+		}
+		start = hunkEnd
+	}
+	return builder.String()
+}
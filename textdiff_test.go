@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := splitLines("a\nb\nc\nd\n")
+	b := splitLines("a\nc\nd\ne\n")
+
+	lines := diffLines(a, b)
+	var ops []string
+	for _, line := range lines {
+		ops = append(ops, line.Op[:1]+line.Text)
+	}
+	got := strings.Join(ops, ",")
+	want := "ea,db,ec,ed,ie"
+	if got != want {
+		t.Errorf("diffLines = %s, want %s", got, want)
+	}
+}
+
+func TestFormatUnifiedDiff(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 20; i++ {
+		line := string(rune('a' + i))
+		a = append(a, line)
+		b = append(b, line)
+	}
+	b[2] = "C"
+	b[15] = "P"
+
+	unified := formatUnifiedDiff(diffLines(a, b), "v1/doc.md", "v2/doc.md")
+	if strings.Count(unified, "@@") != 4 {
+		t.Fatalf("expected two hunks:\n%s", unified)
+	}
+	if !strings.Contains(unified, "@@ -1,6 +1,6 @@\n a\n b\n-c\n+C\n d\n e\n f\n") {
+		t.Errorf("unexpected first hunk:\n%s", unified)
+	}
+	if !strings.Contains(unified, "@@ -13,7 +13,7 @@\n m\n n\n o\n-p\n+P\n q\n r\n s\n") {
+		t.Errorf("unexpected second hunk:\n%s", unified)
+	}
+
+	if same := formatUnifiedDiff(diffLines(a, a), "x", "y"); strings.Contains(same, "@@") {
+		t.Errorf("identical input should have no hunks:\n%s", same)
+	}
+}
@@ -1,32 +1,71 @@
 package tos_tool
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"log/slog"
 	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/volcengine/ve-tos-golang-sdk/v2/tos"
 	"github.com/volcengine/ve-tos-golang-sdk/v2/tos/enum"
 )
 
-// checkErr handles error checking and provides detailed error information
-func checkErr(err error) {
-	if err != nil {
-		if serverErr, ok := err.(*tos.TosServerError); ok {
-			fmt.Println("Error:", serverErr.Error())
-			fmt.Println("Request ID:", serverErr.RequestID)
-			fmt.Println("Response Status Code:", serverErr.StatusCode)
-			fmt.Println("Response Header:", serverErr.Header)
-			fmt.Println("Response Err Code:", serverErr.Code)
-			fmt.Println("Response Err Msg:", serverErr.Message)
-		} else if clientErr, ok := err.(*tos.TosClientError); ok {
-			fmt.Println("Error:", clientErr.Error())
-			fmt.Println("Client Cause Err:", clientErr.Cause.Error())
-		} else {
-			fmt.Println("Error:", err)
+// errAttrs returns log attributes describing a TOS error, including the request ID returned by TOS
+func errAttrs(err error) []any {
+	var serverErr *tos.TosServerError
+	if errors.As(err, &serverErr) {
+		return []any{
+			slog.String("error", serverErr.Error()),
+			slog.String("tos_request_id", serverErr.RequestID),
+			slog.Int("status", serverErr.StatusCode),
+			slog.String("code", serverErr.Code),
+		}
+	}
+	var clientErr *tos.TosClientError
+	if errors.As(err, &clientErr) && clientErr.Cause != nil {
+		return []any{
+			slog.String("error", clientErr.Error()),
+			slog.String("cause", clientErr.Cause.Error()),
 		}
-		panic(err)
 	}
+	return []any{slog.String("error", err.Error())}
+}
+
+// logErr logs a failed TOS operation with the details carried by the error
+func logErr(ctx context.Context, op, objectKey string, err error) {
+	args := append([]any{slog.String("op", op), slog.String("key", objectKey)}, errAttrs(err)...)
+	slog.ErrorContext(ctx, "TOS request failed", args...)
+}
+
+// logDone logs a successful TOS operation at debug level
+func logDone(ctx context.Context, op, objectKey, requestID string) {
+	slog.DebugContext(ctx, "TOS request finished",
+		slog.String("op", op), slog.String("key", objectKey), slog.String("tos_request_id", requestID))
+}
+
+// Observer is called before each TOS request; the returned function is called when the request finishes.
+// It is used for metrics and tracing, and the returned context is used for the request.
+type Observer func(ctx context.Context, op string) (context.Context, func(err error))
+
+var observer Observer
+
+// SetObserver registers the observer for TOS requests; call it before issuing any request
+func SetObserver(o Observer) {
+	observer = o
+}
+
+func observe(ctx context.Context, op string) (context.Context, func(error)) {
+	if observer == nil {
+		return ctx, func(error) {}
+	}
+	return observer(ctx, op)
 }
 
 // UploadConfig holds the configuration for TOS upload
@@ -38,26 +77,57 @@ type UploadConfig struct {
 	BucketName string
 }
 
-// UploadFile uploads a file to TOS and returns a pre-signed URL for the uploaded object
-func UploadFile(config UploadConfig, localFilePath, objectKey string) (string, error) {
-	ctx := context.Background()
+// envConfig builds the TOS configuration used by the *WithEnvConfig helpers
+func envConfig() (UploadConfig, error) {
+	Ak := "AKLTZmRkY2Q1N2ZkZDlhNDIxNWIzNzUzZmRiNzY5ZGYwM2M"
+	Sk := "T1RkaU56WTBPR1k0WldRek5EVTJOV0UwTldNNVptWTBNVEU1WmpWaE5ETQ=="
+	mkb_bucket := "mkb-test"
+	config := UploadConfig{
+		AccessKey:  Ak,
+		SecretKey:  Sk,
+		Endpoint:   "https://tos-cn-beijing.volces.com", // Default endpoint
+		Region:     "cn-beijing",                        // Default region
+		BucketName: mkb_bucket,
+	}
 
-	// Initialize TOS client
-	client, err := tos.NewClientV2(config.Endpoint,
+	// Validate required environment variables
+	if config.AccessKey == "" {
+		return config, fmt.Errorf("TOS_ACCESS_KEY environment variable is required")
+	}
+	if config.SecretKey == "" {
+		return config, fmt.Errorf("TOS_SECRET_KEY environment variable is required")
+	}
+	if config.BucketName == "" {
+		return config, fmt.Errorf("TOS_BUCKET_NAME environment variable is required")
+	}
+
+	return config, nil
+}
+
+// newClient creates a TOS client from the configuration
+func newClient(config UploadConfig) (*tos.ClientV2, error) {
+	return tos.NewClientV2(config.Endpoint,
 		tos.WithRegion(config.Region),
 		tos.WithCredentials(tos.NewStaticCredentials(config.AccessKey, config.SecretKey)))
+}
+
+// UploadFile uploads a file to TOS; use PreSignGetURL when the object needs a download URL
+func UploadFile(ctx context.Context, config UploadConfig, localFilePath, objectKey string) error {
+	// Initialize TOS client
+	client, err := newClient(config)
 	if err != nil {
-		return "", fmt.Errorf("failed to create TOS client: %w", err)
+		return fmt.Errorf("failed to create TOS client: %w", err)
 	}
 
 	// Open local file
 	file, err := os.Open(localFilePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open file %s: %w", localFilePath, err)
+		return fmt.Errorf("failed to open file %s: %w", localFilePath, err)
 	}
 	defer file.Close()
 
 	// Upload file to TOS
+	ctx, done := observe(ctx, "PutObject")
 	output, err := client.PutObjectV2(ctx, &tos.PutObjectV2Input{
 		PutObjectBasicInput: tos.PutObjectBasicInput{
 			Bucket: config.BucketName,
@@ -65,213 +135,419 @@ func UploadFile(config UploadConfig, localFilePath, objectKey string) (string, e
 		},
 		Content: file,
 	})
+	done(err)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file: %w", err)
+		logErr(ctx, "PutObject", objectKey, err)
+		return fmt.Errorf("failed to upload file: %w", err)
 	}
+	logDone(ctx, "PutObject", objectKey, output.RequestID)
 
-	fmt.Printf("File uploaded successfully! Request ID: %s\n", output.RequestID)
+	return nil
+}
 
-	// Generate pre-signed URL for the uploaded object
-	preSignedURL, err := client.PreSignedURL(&tos.PreSignedURLInput{
-		HTTPMethod: enum.HttpMethodGet,
-		Bucket:     config.BucketName,
-		Key:        objectKey,
-	})
+// UploadFileWithEnvConfig uploads a file using environment variables for configuration
+func UploadFileWithEnvConfig(ctx context.Context, localFilePath, objectKey string) error {
+	config, err := envConfig()
 	if err != nil {
-		return "", fmt.Errorf("failed to generate pre-signed URL: %w", err)
+		return err
 	}
 
-	return preSignedURL.SignedUrl, nil
+	return UploadFile(ctx, config, localFilePath, objectKey)
 }
 
-// UploadFileWithEnvConfig uploads a file using environment variables for configuration and returns pre-signed URL
-func UploadFileWithEnvConfig(localFilePath, objectKey string) (string, error) {
-	Ak := "AKLTZmRkY2Q1N2ZkZDlhNDIxNWIzNzUzZmRiNzY5ZGYwM2M"
-	Sk := "T1RkaU56WTBPR1k0WldRek5EVTJOV0UwTldNNVptWTBNVEU1WmpWaE5ETQ=="
-	mkb_bucket := "mkb-test"
-	config := UploadConfig{
-		AccessKey:  Ak,
-		SecretKey:  Sk,
-		Endpoint:   "https://tos-cn-beijing.volces.com", // Default endpoint
-		Region:     "cn-beijing",                        // Default region
-		BucketName: mkb_bucket,
+// UploadContent uploads in-memory content to TOS; use PreSignGetURL when the object needs a download URL
+func UploadContent(ctx context.Context, config UploadConfig, content []byte, objectKey string) error {
+	// Initialize TOS client
+	client, err := newClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create TOS client: %w", err)
 	}
 
-	// Validate required environment variables
-	if config.AccessKey == "" {
-		return "", fmt.Errorf("TOS_ACCESS_KEY environment variable is required")
-	}
-	if config.SecretKey == "" {
-		return "", fmt.Errorf("TOS_SECRET_KEY environment variable is required")
-	}
-	if config.BucketName == "" {
-		return "", fmt.Errorf("TOS_BUCKET_NAME environment variable is required")
+	// Upload content to TOS
+	ctx, done := observe(ctx, "PutObject")
+	output, err := client.PutObjectV2(ctx, &tos.PutObjectV2Input{
+		PutObjectBasicInput: tos.PutObjectBasicInput{
+			Bucket: config.BucketName,
+			Key:    objectKey,
+		},
+		Content: bytes.NewReader(content),
+	})
+	done(err)
+	if err != nil {
+		logErr(ctx, "PutObject", objectKey, err)
+		return fmt.Errorf("failed to upload content: %w", err)
 	}
+	logDone(ctx, "PutObject", objectKey, output.RequestID)
 
-	return UploadFile(config, localFilePath, objectKey)
+	return nil
 }
 
-// ListFilesWithEnvConfig lists files in TOS bucket with a specific prefix using environment variables for configuration
-func ListFilesWithEnvConfig(prefix string) ([]map[string]interface{}, error) {
-	Ak := "AKLTZmRkY2Q1N2ZkZDlhNDIxNWIzNzUzZmRiNzY5ZGYwM2M"
-	Sk := "T1RkaU56WTBPR1k0WldRek5EVTJOV0UwTldNNVptWTBNVEU1WmpWaE5ETQ=="
-	mkb_bucket := "mkb-test"
-	config := UploadConfig{
-		AccessKey:  Ak,
-		SecretKey:  Sk,
-		Endpoint:   "https://tos-cn-beijing.volces.com", // Default endpoint
-		Region:     "cn-beijing",                        // Default region
-		BucketName: mkb_bucket,
-	}
-
-	// Validate required environment variables
-	if config.AccessKey == "" {
-		return nil, fmt.Errorf("TOS_ACCESS_KEY environment variable is required")
-	}
-	if config.SecretKey == "" {
-		return nil, fmt.Errorf("TOS_SECRET_KEY environment variable is required")
-	}
-	if config.BucketName == "" {
-		return nil, fmt.Errorf("TOS_BUCKET_NAME environment variable is required")
+// UploadContentWithEnvConfig uploads in-memory content using environment variables for configuration
+func UploadContentWithEnvConfig(ctx context.Context, content []byte, objectKey string) error {
+	config, err := envConfig()
+	if err != nil {
+		return err
 	}
 
-	return ListFiles(config, prefix)
+	return UploadContent(ctx, config, content, objectKey)
 }
 
-// ListFiles lists files in TOS bucket with a specific prefix
-func ListFiles(config UploadConfig, prefix string) ([]map[string]interface{}, error) {
-	ctx := context.Background()
+// ObjectInfo describes an object returned by Objects
+type ObjectInfo struct {
+	Key          string
+	Name         string // Key relative to the listed prefix
+	Size         int64
+	LastModified time.Time
+}
 
-	// Initialize TOS client
-	client, err := tos.NewClientV2(config.Endpoint,
-		tos.WithRegion(config.Region),
-		tos.WithCredentials(tos.NewStaticCredentials(config.AccessKey, config.SecretKey)))
+// ObjectsWithEnvConfig iterates over the objects under a prefix using environment variables for configuration
+func ObjectsWithEnvConfig(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	config, err := envConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create TOS client: %w", err)
+		return func(yield func(ObjectInfo, error) bool) {
+			yield(ObjectInfo{}, err)
+		}
 	}
 
-	var files []map[string]interface{}
-	var continuationToken string
-
-	for {
-		// List objects with prefix
-		input := &tos.ListObjectsType2Input{
-			Bucket:            config.BucketName,
-			Prefix:            prefix,
-			ContinuationToken: continuationToken,
-			MaxKeys:           1000, // Maximum number of keys to return
-		}
+	return Objects(ctx, config, prefix)
+}
 
-		output, err := client.ListObjectsType2(ctx, input)
+// Objects iterates over the objects under a prefix, fetching one page at a time.
+// Iteration stops after the first error is yielded.
+func Objects(ctx context.Context, config UploadConfig, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		client, err := newClient(config)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+			yield(ObjectInfo{}, fmt.Errorf("failed to create TOS client: %w", err))
+			return
 		}
 
-		// Process each object
-		for _, object := range output.Contents {
-			// Skip the prefix directory itself
-			if object.Key == prefix {
-				continue
+		for object, err := range objects(ctx, client, config, prefix) {
+			if !yield(object, err) {
+				return
 			}
+		}
+	}
+}
 
-			// Extract filename from the full path
-			filename := object.Key
-			if prefix != "" {
-				// Remove the prefix from the key to get just the filename
-				if len(object.Key) > len(prefix) {
-					filename = object.Key[len(prefix):]
-					// Remove leading slash if present
-					if len(filename) > 0 && filename[0] == '/' {
-						filename = filename[1:]
-					}
-				}
+func objects(ctx context.Context, client *tos.ClientV2, config UploadConfig, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		var continuationToken string
+
+		for {
+			// List objects with prefix
+			input := &tos.ListObjectsType2Input{
+				Bucket:            config.BucketName,
+				Prefix:            prefix,
+				ContinuationToken: continuationToken,
+				MaxKeys:           1000, // Maximum number of keys to return
 			}
 
-			// Generate pre-signed URL for the object
-			preSignedURL, err := client.PreSignedURL(&tos.PreSignedURLInput{
-				HTTPMethod: enum.HttpMethodGet,
-				Bucket:     config.BucketName,
-				Key:        object.Key,
-			})
+			pageCtx, done := observe(ctx, "ListObjects")
+			output, err := client.ListObjectsType2(pageCtx, input)
+			done(err)
 			if err != nil {
-				fmt.Printf("Warning: failed to generate pre-signed URL for %s: %v\n", object.Key, err)
-				continue
+				logErr(ctx, "ListObjects", prefix, err)
+				yield(ObjectInfo{}, fmt.Errorf("failed to list objects: %w", err))
+				return
+			}
+
+			for _, object := range output.Contents {
+				// Skip the prefix directory itself
+				if object.Key == prefix {
+					continue
+				}
+
+				// Remove the prefix and any leading slash to get just the filename
+				name := strings.TrimPrefix(strings.TrimPrefix(object.Key, prefix), "/")
+
+				if !yield(ObjectInfo{
+					Key:          object.Key,
+					Name:         name,
+					Size:         object.Size,
+					LastModified: object.LastModified,
+				}, nil) {
+					return
+				}
 			}
 
-			files = append(files, map[string]interface{}{
-				"name":    filename,
-				"size":    object.Size,
-				"modTime": object.LastModified.Format("2006-01-02T15:04:05Z07:00"),
-				"key":     object.Key,
-				"url":     preSignedURL.SignedUrl,
-			})
+			// Check if there are more objects to fetch
+			if !output.IsTruncated {
+				return
+			}
+			continuationToken = output.NextContinuationToken
 		}
+	}
+}
+
+// ListFilesWithEnvConfig lists files in TOS bucket with a specific prefix using environment variables for configuration
+func ListFilesWithEnvConfig(ctx context.Context, prefix string) ([]map[string]interface{}, error) {
+	config, err := envConfig()
+	if err != nil {
+		return nil, err
+	}
 
-		// Check if there are more objects to fetch
-		if !output.IsTruncated {
-			break
+	return ListFiles(ctx, config, prefix)
+}
+
+// ListFiles lists files in TOS bucket with a specific prefix.
+// Entries carry no download URL; use PreSignGetURL for the objects that need one.
+func ListFiles(ctx context.Context, config UploadConfig, prefix string) ([]map[string]interface{}, error) {
+	// Initialize TOS client
+	client, err := newClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TOS client: %w", err)
+	}
+
+	var files []map[string]interface{}
+	for object, err := range objects(ctx, client, config, prefix) {
+		if err != nil {
+			return nil, err
 		}
-		continuationToken = output.NextContinuationToken
+
+		files = append(files, map[string]interface{}{
+			"name":    object.Name,
+			"size":    object.Size,
+			"modTime": object.LastModified.Format("2006-01-02T15:04:05Z07:00"),
+			"key":     object.Key,
+		})
 	}
 
 	return files, nil
 }
 
 // DeleteFile deletes a file from TOS
-func DeleteFile(config UploadConfig, objectKey string) error {
-	ctx := context.Background()
-
+func DeleteFile(ctx context.Context, config UploadConfig, objectKey string) error {
 	// Initialize TOS client
-	client, err := tos.NewClientV2(config.Endpoint,
-		tos.WithRegion(config.Region),
-		tos.WithCredentials(tos.NewStaticCredentials(config.AccessKey, config.SecretKey)))
+	client, err := newClient(config)
 	if err != nil {
 		return fmt.Errorf("failed to create TOS client: %w", err)
 	}
 
 	// Delete object from TOS
-	_, err = client.DeleteObjectV2(ctx, &tos.DeleteObjectV2Input{
+	ctx, done := observe(ctx, "DeleteObject")
+	output, err := client.DeleteObjectV2(ctx, &tos.DeleteObjectV2Input{
 		Bucket: config.BucketName,
 		Key:    objectKey,
 	})
+	done(err)
 	if err != nil {
+		logErr(ctx, "DeleteObject", objectKey, err)
 		return fmt.Errorf("failed to delete object: %w", err)
 	}
-
-	fmt.Printf("File deleted successfully from TOS: %s\n", objectKey)
+	logDone(ctx, "DeleteObject", objectKey, output.RequestID)
 	return nil
 }
 
 // DeleteFileWithEnvConfig deletes a file from TOS using environment variables for configuration
-func DeleteFileWithEnvConfig(objectKey string) error {
-	Ak := "AKLTZmRkY2Q1N2ZkZDlhNDIxNWIzNzUzZmRiNzY5ZGYwM2M"
-	Sk := "T1RkaU56WTBPR1k0WldRek5EVTJOV0UwTldNNVptWTBNVEU1WmpWaE5ETQ=="
-	mkb_bucket := "mkb-test"
-	config := UploadConfig{
-		AccessKey:  Ak,
-		SecretKey:  Sk,
-		Endpoint:   "https://tos-cn-beijing.volces.com", // Default endpoint
-		Region:     "cn-beijing",                        // Default region
-		BucketName: mkb_bucket,
+func DeleteFileWithEnvConfig(ctx context.Context, objectKey string) error {
+	config, err := envConfig()
+	if err != nil {
+		return err
 	}
 
-	// Validate required environment variables
-	if config.AccessKey == "" {
-		return fmt.Errorf("TOS_ACCESS_KEY environment variable is required")
+	return DeleteFile(ctx, config, objectKey)
+}
+
+// CopyObject copies an object within the bucket, overwriting the destination
+func CopyObject(ctx context.Context, config UploadConfig, srcKey, dstKey string) error {
+	// Initialize TOS client
+	client, err := newClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create TOS client: %w", err)
 	}
-	if config.SecretKey == "" {
-		return fmt.Errorf("TOS_SECRET_KEY environment variable is required")
+
+	ctx, done := observe(ctx, "CopyObject")
+	output, err := client.CopyObject(ctx, &tos.CopyObjectInput{
+		Bucket:    config.BucketName,
+		Key:       dstKey,
+		SrcBucket: config.BucketName,
+		SrcKey:    srcKey,
+	})
+	done(err)
+	if err != nil {
+		logErr(ctx, "CopyObject", srcKey, err)
+		return fmt.Errorf("failed to copy object: %w", err)
 	}
-	if config.BucketName == "" {
-		return fmt.Errorf("TOS_BUCKET_NAME environment variable is required")
+	slog.DebugContext(ctx, "TOS request finished", slog.String("op", "CopyObject"),
+		slog.String("key", srcKey), slog.String("dst_key", dstKey), slog.String("tos_request_id", output.RequestID))
+	return nil
+}
+
+// CopyObjectWithEnvConfig copies an object using environment variables for configuration
+func CopyObjectWithEnvConfig(ctx context.Context, srcKey, dstKey string) error {
+	config, err := envConfig()
+	if err != nil {
+		return err
+	}
+
+	return CopyObject(ctx, config, srcKey, dstKey)
+}
+
+// GetObject opens an object for reading and returns its content and size; the caller must close the reader
+func GetObject(ctx context.Context, config UploadConfig, objectKey string) (io.ReadCloser, int64, error) {
+	// Initialize TOS client
+	client, err := newClient(config)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create TOS client: %w", err)
+	}
+
+	ctx, done := observe(ctx, "GetObject")
+	output, err := client.GetObjectV2(ctx, &tos.GetObjectV2Input{
+		Bucket: config.BucketName,
+		Key:    objectKey,
+	})
+	done(err)
+	if err != nil {
+		logErr(ctx, "GetObject", objectKey, err)
+		return nil, 0, fmt.Errorf("failed to get object: %w", err)
+	}
+	logDone(ctx, "GetObject", objectKey, output.RequestID)
+
+	return output.Content, output.ContentLength, nil
+}
+
+// GetObjectWithEnvConfig opens an object for reading using environment variables for configuration
+func GetObjectWithEnvConfig(ctx context.Context, objectKey string) (io.ReadCloser, int64, error) {
+	config, err := envConfig()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return GetObject(ctx, config, objectKey)
+}
+
+// MaxPreSignExpiry is the longest expiry TOS accepts for a pre-signed URL
+const MaxPreSignExpiry = 7 * 24 * time.Hour
+
+// presignExpires converts an expiry into the seconds expected by TOS, clamped to [1s, MaxPreSignExpiry].
+// Zero keeps the SDK default of one hour.
+func presignExpires(expires time.Duration) int64 {
+	if expires <= 0 {
+		return 0
+	}
+	return int64(min(max(expires, time.Second), MaxPreSignExpiry) / time.Second)
+}
+
+// PreSignGetURL generates a pre-signed download URL for an object that is valid for expires;
+// zero uses the SDK default of one hour
+func PreSignGetURL(config UploadConfig, objectKey string, expires time.Duration) (string, error) {
+	// Initialize TOS client
+	client, err := newClient(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to create TOS client: %w", err)
+	}
+
+	preSignedURL, err := client.PreSignedURL(&tos.PreSignedURLInput{
+		HTTPMethod: enum.HttpMethodGet,
+		Bucket:     config.BucketName,
+		Key:        objectKey,
+		Expires:    presignExpires(expires),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate pre-signed URL: %w", err)
+	}
+
+	return preSignedURL.SignedUrl, nil
+}
+
+// PreSignGetURLWithEnvConfig generates a pre-signed download URL using environment variables for configuration
+func PreSignGetURLWithEnvConfig(objectKey string, expires time.Duration) (string, error) {
+	config, err := envConfig()
+	if err != nil {
+		return "", err
+	}
+
+	return PreSignGetURL(config, objectKey, expires)
+}
+
+// ErrObjectNotFound is returned by StatObject when the object does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// StatObject returns the size and modification time of an object, or ErrObjectNotFound
+func StatObject(ctx context.Context, config UploadConfig, objectKey string) (ObjectInfo, error) {
+	// Initialize TOS client
+	client, err := newClient(config)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to create TOS client: %w", err)
+	}
+
+	ctx, done := observe(ctx, "HeadObject")
+	output, err := client.HeadObjectV2(ctx, &tos.HeadObjectV2Input{
+		Bucket: config.BucketName,
+		Key:    objectKey,
+	})
+	if err != nil {
+		if tos.StatusCode(err) == 404 {
+			// A missing object is an expected answer, not a failed request
+			done(nil)
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		done(err)
+		logErr(ctx, "HeadObject", objectKey, err)
+		return ObjectInfo{}, fmt.Errorf("failed to head object: %w", err)
+	}
+	done(nil)
+
+	return ObjectInfo{
+		Key:          objectKey,
+		Name:         path.Base(objectKey),
+		Size:         output.ContentLength,
+		LastModified: output.LastModified,
+	}, nil
+}
+
+// StatObjectWithEnvConfig returns object information using environment variables for configuration
+func StatObjectWithEnvConfig(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	config, err := envConfig()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	return StatObject(ctx, config, objectKey)
+}
+
+// HeadBucket checks that the bucket exists and the credentials can access it
+func HeadBucket(ctx context.Context, config UploadConfig) error {
+	// Initialize TOS client
+	client, err := newClient(config)
+	if err != nil {
+		return fmt.Errorf("failed to create TOS client: %w", err)
+	}
+
+	ctx, done := observe(ctx, "HeadBucket")
+	output, err := client.HeadBucket(ctx, &tos.HeadBucketInput{
+		Bucket: config.BucketName,
+	})
+	done(err)
+	if err != nil {
+		logErr(ctx, "HeadBucket", "", err)
+		return fmt.Errorf("failed to head bucket %s: %w", config.BucketName, err)
+	}
+	logDone(ctx, "HeadBucket", "", output.RequestID)
+
+	return nil
+}
+
+// HeadBucketWithEnvConfig checks the bucket using environment variables for configuration
+func HeadBucketWithEnvConfig(ctx context.Context) error {
+	config, err := envConfig()
+	if err != nil {
+		return err
 	}
 
-	return DeleteFile(config, objectKey)
+	return HeadBucket(ctx, config)
 }
 
 //func main() {
 //	// Example usage with environment variables
-//	preSignedURL, err := UploadFileWithEnvConfig("../README.md", "example_dir/README2.md")
-//	checkErr(err)
+//	ctx := context.Background()
+//	if err := UploadFileWithEnvConfig(ctx, "../README.md", "example_dir/README2.md"); err != nil {
+//		panic(err)
+//	}
+//	preSignedURL, err := PreSignGetURLWithEnvConfig("example_dir/README2.md", time.Hour)
+//	if err != nil {
+//		panic(err)
+//	}
 //	fmt.Printf("Pre-signed URL: %s\n", preSignedURL)
 //}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "file-upload-server"
+
+// 链路导出方式
+const (
+	traceExporterNone   = "none"
+	traceExporterStdout = "stdout"
+	traceExporterOTLP   = "otlp"
+)
+
+// tracer 在 setupTracing 之前使用时不产生span
+var tracer = otel.Tracer(serviceName)
+
+// setupTracing 按配置注册全局 TracerProvider，返回的函数在退出前调用以导出剩余的span。
+// otlp 的地址等参数通过标准的 OTEL_EXPORTER_OTLP_* 环境变量配置
+func setupTracing(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(exporter) {
+	case traceExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case traceExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case traceExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("invalid MKB_TRACE_EXPORTER %q, use none, stdout or otlp", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// startSpan 开始一个处理阶段的span
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan 记录阶段的结果并结束span，err 不为空时标记为失败
+func endSpan(span trace.Span, err error, attrs ...attribute.KeyValue) {
+	span.SetAttributes(attrs...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tokenUsageAttributes 返回模型token用量的span属性，usage 为 nil 时返回空
+func tokenUsageAttributes(prefix string, usage *viking_db_tool.ModelTokenUsage) []attribute.KeyValue {
+	if usage == nil {
+		return nil
+	}
+	return []attribute.KeyValue{
+		attribute.Int64(prefix+".prompt_tokens", usage.PromptTokens),
+		attribute.Int64(prefix+".completion_tokens", usage.CompletionTokens),
+		attribute.Int64(prefix+".total_tokens", usage.TotalTokens),
+	}
+}
+
+// searchUsageAttributes 返回检索各阶段token用量的span属性
+func searchUsageAttributes(usage *viking_db_tool.TotalTokenUsage) []attribute.KeyValue {
+	if usage == nil {
+		return nil
+	}
+	attrs := append(tokenUsageAttributes("search.embedding", usage.EmbeddingUsage), tokenUsageAttributes("search.rewrite", usage.RewriteUsage)...)
+	if usage.RerankUsage != nil {
+		attrs = append(attrs, attribute.Int64("search.rerank.total_tokens", *usage.RerankUsage))
+	}
+	return attrs
+}
+
+// promptReportAttributes 返回提示词预算和各切片使用情况的span属性
+func promptReportAttributes(report *viking_db_tool.PromptReport) []attribute.KeyValue {
+	if report == nil {
+		return nil
+	}
+	counts := make(map[viking_db_tool.PromptChunkStatus]int)
+	for _, chunk := range report.Chunks {
+		counts[chunk.Status]++
+	}
+	return []attribute.KeyValue{
+		attribute.Int("prompt.chunk_budget", report.ChunkBudget),
+		attribute.Int("prompt.chunk_tokens", report.ChunkTokens),
+		attribute.Int("prompt.chunks_included", counts[viking_db_tool.PromptChunkIncluded]),
+		attribute.Int("prompt.chunks_truncated", counts[viking_db_tool.PromptChunkTruncated]),
+		attribute.Int("prompt.chunks_dropped", counts[viking_db_tool.PromptChunkDropped]),
+	}
+}
+
+// headerCarrier 让 propagator 读写Hertz请求头中的链路上下文
+type headerCarrier struct {
+	c *app.RequestContext
+}
+
+func (h headerCarrier) Get(key string) string {
+	return string(h.c.GetHeader(key))
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h.c.Request.Header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	var keys []string
+	h.c.Request.Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// tracingMiddleware 为每个请求创建服务端span，调用方传入 traceparent 时接入调用方的链路
+func tracingMiddleware(ctx context.Context, c *app.RequestContext) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier{c})
+	method := string(c.Method())
+	ctx, span := tracer.Start(ctx, method+" "+c.FullPath(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(method),
+			semconv.HTTPRoute(c.FullPath()),
+			attribute.String("request_id", requestIDFrom(ctx)),
+		),
+	)
+	defer span.End()
+
+	c.Next(ctx)
+
+	status := c.Response.StatusCode()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+	}
+}
+
+// outboundTracer 返回为出站请求创建客户端span的观察者
+func outboundTracer(system string) outboundHook {
+	return func(ctx context.Context, op string) (context.Context, func(error)) {
+		ctx, span := tracer.Start(ctx, system+" "+op,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("peer.service", system), attribute.String("operation", op)),
+		)
+		return ctx, func(err error) {
+			endSpan(span, err)
+		}
+	}
+}
+
+// chainHooks 把多个出站请求观察者合并为一个，结束时按相反顺序调用
+func chainHooks(hooks ...outboundHook) outboundHook {
+	return func(ctx context.Context, op string) (context.Context, func(error)) {
+		dones := make([]func(error), len(hooks))
+		for i, hook := range hooks {
+			ctx, dones[i] = hook(ctx, op)
+		}
+		return ctx, func(err error) {
+			for i := len(dones) - 1; i >= 0; i-- {
+				dones[i](err)
+			}
+		}
+	}
+}
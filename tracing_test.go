@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestChainHooks(t *testing.T) {
+	var calls []string
+	hook := func(name string) outboundHook {
+		return func(ctx context.Context, op string) (context.Context, func(error)) {
+			calls = append(calls, "start "+name+" "+op)
+			return ctx, func(err error) {
+				calls = append(calls, "done "+name+" "+err.Error())
+			}
+		}
+	}
+
+	_, done := chainHooks(hook("metrics"), hook("tracing"))(context.Background(), "PutObject")
+	done(errors.New("boom"))
+
+	want := []string{"start metrics PutObject", "start tracing PutObject", "done tracing boom", "done metrics boom"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestSetupTracing(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), traceExporterNone)
+	if err != nil {
+		t.Fatalf("setupTracing(none) error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error = %v", err)
+	}
+
+	if _, err := setupTracing(context.Background(), "jaeger"); err == nil {
+		t.Fatal("setupTracing(jaeger) error = nil, want error")
+	}
+}
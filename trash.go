@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"tos_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// moveToTrash 把文档的当前文件移到回收站前缀下，并将记录标记为已删除
+func moveToTrash(ctx context.Context, record *DocumentRecord) error {
+	if err := checkObjectKey(record.UserID, record.ObjectKey); err != nil {
+		return err
+	}
+	trashKey := trashObjectKey(record.UserID, record.DocID, record.Name)
+	if err := tos_tool.CopyObjectWithEnvConfig(ctx, record.ObjectKey, trashKey); err != nil {
+		return err
+	}
+	if err := tos_tool.DeleteFileWithEnvConfig(ctx, record.ObjectKey); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	saved, ok, err := registry.Update(record.UserID, record.DocID, func(rec *DocumentRecord) {
+		rec.TrashKey = trashKey
+		rec.DeletedAt = &now
+		rec.UpdatedAt = now
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save document record", "doc_id", record.DocID, "error", err)
+	}
+	if ok {
+		*record = saved
+	}
+	return nil
+}
+
+// purgeDocument 永久删除回收站中的文档及其所有历史版本
+func purgeDocument(ctx context.Context, record DocumentRecord) error {
+	if err := checkObjectKey(record.UserID, record.TrashKey); err != nil {
+		return err
+	}
+	if err := tos_tool.DeleteFileWithEnvConfig(ctx, record.TrashKey); err != nil {
+		return fmt.Errorf("failed to delete %s from TOS: %w", record.TrashKey, err)
+	}
+	deleteDocumentObjects(ctx, record)
+	return registry.Delete(record.UserID, record.DocID)
+}
+
+// purgeExpiredTrash 永久删除超过保留期限的回收站文档
+func purgeExpiredTrash(ctx context.Context) {
+	for _, record := range registry.ListDeletedBefore(time.Now().Add(-cfg.TrashRetention)) {
+		if err := purgeDocument(ctx, record); err != nil {
+			slog.ErrorContext(ctx, "Failed to purge document from trash", "user_id", record.UserID, "doc_id", record.DocID, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Purged document from trash", "user_id", record.UserID, "doc_id", record.DocID)
+	}
+}
+
+// startTrashPurger 启动后台任务，定期清理回收站；清理间隔为 0 时不启动
+func startTrashPurger(ctx context.Context) {
+	if cfg.TrashPurgeInterval <= 0 {
+		return
+	}
+	inflight.Go(func() {
+		ticker := time.NewTicker(cfg.TrashPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purgeExpiredTrash(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// 列出回收站中的文件
+func listTrash(ctx context.Context, c *app.RequestContext) {
+	userID := c.Query("user_id")
+	if !requireUserID(c, userID) {
+		return
+	}
+
+	items := []utils.H{}
+	for _, record := range registry.ListTrash(userID) {
+		items = append(items, utils.H{
+			"doc_id":     record.DocID,
+			"name":       record.Name,
+			"doc_type":   record.DocType,
+			"size":       record.Size,
+			"version":    record.Version,
+			"deleted_at": record.DeletedAt,
+			"purge_at":   record.DeletedAt.Add(cfg.TrashRetention),
+		})
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"items": items,
+		"count": len(items),
+	})
+}
+
+// 从回收站恢复文件，并重新写入知识库
+func restoreTrashItem(ctx context.Context, c *app.RequestContext) {
+	var request struct {
+		UserID string `json:"user_id"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	if !requireUserID(c, request.UserID) {
+		return
+	}
+
+	docID := c.Param("id")
+	if !requireDocID(c, docID) {
+		return
+	}
+
+	record, ok := registry.GetTrashed(request.UserID, docID)
+	if !ok {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "Document not found in trash",
+		})
+		return
+	}
+
+	// 回收站中的文件已计入存储用量，恢复后重新计入文档数
+	if err := checkQuota(request.UserID, 0, 1); err != nil {
+		writeQuotaError(c, err)
+		return
+	}
+
+	resourceID, err := ensureKnowledgeBase(ctx, request.UserID)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 先把文件放回原位置，再重新索引当前版本
+	if err := checkObjectKey(record.UserID, record.TrashKey); err != nil {
+		c.JSON(consts.StatusForbidden, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := tos_tool.CopyObjectWithEnvConfig(ctx, record.TrashKey, record.ObjectKey); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to restore file in TOS: " + err.Error(),
+		})
+		return
+	}
+
+	attempt := indexVersion(ctx, resourceID, record, record.ActiveVersion(), nil, nil)
+	if attempt.err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": attempt.err.Error(),
+		})
+		return
+	}
+
+	if err := tos_tool.DeleteFileWithEnvConfig(ctx, record.TrashKey); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete object from TOS", "key", record.TrashKey, "error", err)
+	}
+
+	if saved, ok, err := registry.Update(record.UserID, record.DocID, func(rec *DocumentRecord) {
+		attempt.apply(rec)
+		rec.TrashKey = ""
+		rec.DeletedAt = nil
+		rec.UpdatedAt = time.Now()
+	}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save document record", "doc_id", record.DocID, "error", err)
+	} else if ok {
+		record = saved
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"message":  "Document restored successfully",
+		"document": record,
+	})
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"tos_tool"
+	"viking_db_tool"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+const maxDiffSize = 2 << 20 // 参与比较的单个版本最大 2MB
+
+// 可以比较差异的文本类文档
+var diffableDocTypes = map[string]bool{
+	"txt":      true,
+	"markdown": true,
+	"csv":      true,
+	"jsonl":    true,
+}
+
+// fileSHA256 计算本地文件的 SHA256
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeVersionFile 把本地文件保存为新版本对象，并复制到 uploads/ 下作为当前版本
+func storeVersionFile(ctx context.Context, userID, docID, filename, objectKey string, version int, tempFilePath string, size int64) (DocumentVersion, error) {
+	checksum, err := fileSHA256(tempFilePath)
+	if err != nil {
+		return DocumentVersion{}, fmt.Errorf("Failed to read temporary file: %w", err)
+	}
+
+	versionKey := versionObjectKey(userID, docID, version, filename)
+	if err := tos_tool.UploadFileWithEnvConfig(ctx, tempFilePath, versionKey); err != nil {
+		return DocumentVersion{}, fmt.Errorf("Failed to upload to TOS: %w", err)
+	}
+	if err := tos_tool.CopyObjectWithEnvConfig(ctx, versionKey, objectKey); err != nil {
+		return DocumentVersion{}, fmt.Errorf("Failed to update current version in TOS: %w", err)
+	}
+
+	return DocumentVersion{
+		Version:   version,
+		ObjectKey: versionKey,
+		Size:      size,
+		SHA256:    checksum,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// storeVersionContent 把内容保存为新版本对象，并复制到 uploads/ 下作为当前版本
+func storeVersionContent(ctx context.Context, userID, docID, filename, objectKey string, version int, content []byte) (DocumentVersion, error) {
+	sum := sha256.Sum256(content)
+
+	versionKey := versionObjectKey(userID, docID, version, filename)
+	if err := tos_tool.UploadContentWithEnvConfig(ctx, content, versionKey); err != nil {
+		return DocumentVersion{}, fmt.Errorf("Failed to upload to TOS: %w", err)
+	}
+	if err := tos_tool.CopyObjectWithEnvConfig(ctx, versionKey, objectKey); err != nil {
+		return DocumentVersion{}, fmt.Errorf("Failed to update current version in TOS: %w", err)
+	}
+
+	return DocumentVersion{
+		Version:   version,
+		ObjectKey: versionKey,
+		Size:      int64(len(content)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// readObject 读取TOS对象的全部内容，超过 limit 字节时报错
+func readObject(ctx context.Context, objectKey string, limit int64) ([]byte, error) {
+	body, _, err := tos_tool.GetObjectWithEnvConfig(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("object %s is larger than %d bytes", objectKey, limit)
+	}
+	return data, nil
+}
+
+// deleteDocumentObjects 删除文档的历史版本和预处理副本，失败时只记录日志
+func deleteDocumentObjects(ctx context.Context, record DocumentRecord) {
+	keys := []string{}
+	for _, v := range record.Versions {
+		keys = append(keys, v.ObjectKey)
+	}
+	if record.ProcessedKey != "" {
+		keys = append(keys, record.ProcessedKey)
+	}
+	for _, key := range keys {
+		if err := tos_tool.DeleteFileWithEnvConfig(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete object from TOS", "key", key, "error", err)
+		}
+	}
+}
+
+// documentMeta 生成写入知识库的文档元数据字段
+func documentMeta(record DocumentRecord, version int) []viking_db_tool.MetaField {
+	meta := []viking_db_tool.MetaField{
+		viking_db_tool.CreateStringMetaField("行业", "企业服务"),
+		viking_db_tool.CreateStringMetaField("用户ID", record.UserID),
+		viking_db_tool.CreateIntMetaField("版本", version),
+	}
+	for _, field := range sortedMetaKeys(record.Metadata) {
+		meta = append(meta, viking_db_tool.CreateStringMetaField(field, record.Metadata[field]))
+	}
+	return meta
+}
+
+// indexAttempt 是一次写入知识库的结果，由调用方通过 apply 记入文档记录
+type indexAttempt struct {
+	at        time.Time
+	processed *preprocessResult
+	err       error
+}
+
+// apply 在记录中更新提交次数、失败原因和预处理副本，在 registry.Update 中调用，不覆盖并发写入的其他字段
+func (a indexAttempt) apply(rec *DocumentRecord) {
+	at := a.at
+	rec.Attempts++
+	rec.LastAttemptAt = &at
+	if a.err != nil {
+		rec.LastError = a.err.Error()
+		return
+	}
+	rec.LastError = ""
+	if a.processed != nil {
+		rec.ProcessedKey = a.processed.ObjectKey
+	}
+}
+
+// indexVersion 把文档的指定版本写入知识库，知识库中只保留当前生效的版本。
+// raw 为版本的原始内容，为空且需要内容（文本文档或需要预处理）时从TOS读取；previous 为知识库中已有的版本，
+// 不为空时先删除知识库中的旧文档，新版本写入失败时重新写入 previous
+func indexVersion(ctx context.Context, resourceID string, record DocumentRecord, v DocumentVersion, raw []byte, previous *DocumentVersion) indexAttempt {
+	attempt := indexAttempt{at: time.Now()}
+	attempt.processed, attempt.err = submitVersion(ctx, resourceID, record, v, raw, previous)
+	return attempt
+}
+
+func submitVersion(ctx context.Context, resourceID string, record DocumentRecord, v DocumentVersion, raw []byte, previous *DocumentVersion) (*preprocessResult, error) {
+	prepared, err := prepareVersion(ctx, record, v, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// 无论写入是否成功，知识库中的内容都可能已经变化
+	defer queryCache.Invalidate(ctx, record.UserID)
+
+	// 知识库不支持原地更新文档内容，也不能同时存在相同docID的文档，只能先删除旧文档再以相同docID重新添加
+	if previous != nil {
+		if _, err := viking_db_tool.DeleteDocumentByResourceID(ctx, resourceID, record.DocID); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete previous version from knowledge base", "doc_id", record.DocID, "error", err)
+		}
+	}
+
+	if err := addVersion(ctx, resourceID, record, v, prepared); err != nil {
+		if previous != nil {
+			restorePreviousVersion(ctx, resourceID, record, *previous)
+		}
+		return nil, err
+	}
+	return prepared.processed, nil
+}
+
+// restorePreviousVersion 在新版本写入失败后重新写入之前的版本，避免知识库中没有该文档
+func restorePreviousVersion(ctx context.Context, resourceID string, record DocumentRecord, previous DocumentVersion) {
+	prepared, err := prepareVersion(ctx, record, previous, nil)
+	if err == nil {
+		err = addVersion(ctx, resourceID, record, previous, prepared)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to restore previous version in knowledge base", "doc_id", record.DocID, "version", previous.Version, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "Restored previous version in knowledge base", "doc_id", record.DocID, "version", previous.Version)
+}
+
+// preparedVersion 是准备写入知识库的版本内容
+type preparedVersion struct {
+	docType   string
+	content   string // 文本文档写入知识库的内容
+	processed *preprocessResult
+}
+
+// prepareVersion 读取并预处理版本内容，还没有改动知识库
+func prepareVersion(ctx context.Context, record DocumentRecord, v DocumentVersion, raw []byte) (preparedVersion, error) {
+	isText := record.Source == documentSourceText
+	if raw == nil && (isText || shouldPreprocess(record.DocType)) {
+		var err error
+		if raw, err = readObject(ctx, v.ObjectKey, maxFileSize); err != nil {
+			return preparedVersion{}, fmt.Errorf("Failed to read version %d from TOS: %w", v.Version, err)
+		}
+	}
+
+	prepared := preparedVersion{docType: record.DocType, content: string(raw)}
+	if shouldPreprocess(record.DocType) {
+		processed, err := preprocessDocument(ctx, record.UserID, record.Name, record.DocType, raw)
+		if err != nil {
+			return preparedVersion{}, fmt.Errorf("Failed to preprocess document: %w", err)
+		}
+		prepared.docType = processed.DocType
+		prepared.content = processed.Text
+		prepared.processed = processed
+	}
+	return prepared, nil
+}
+
+// addVersion 以文档的docID把准备好的版本添加到知识库，并跟踪处理状态
+func addVersion(ctx context.Context, resourceID string, record DocumentRecord, v DocumentVersion, prepared preparedVersion) error {
+	meta := documentMeta(record, v.Version)
+	var response *viking_db_tool.DocumentUploadResponse
+	var err error
+	if record.Source == documentSourceText {
+		response, err = viking_db_tool.UploadDocumentByContent(ctx, resourceID, record.DocID, record.Name, prepared.docType, prepared.content, meta)
+	} else {
+		// 每次提交都重新签名，有效期需覆盖知识库排队拉取文件的时间
+		indexKey := v.ObjectKey
+		if prepared.processed != nil {
+			indexKey = prepared.processed.ObjectKey
+		}
+		var indexURL string
+		indexURL, err = tos_tool.PreSignGetURLWithEnvConfig(indexKey, cfg.IngestURLExpiry)
+		if err != nil {
+			return fmt.Errorf("Failed to generate pre-signed URL: %w", err)
+		}
+		response, err = viking_db_tool.UploadDocumentByURL(ctx, resourceID, record.DocID, record.Name, prepared.docType, indexURL, meta)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to upload to Viking DB: %w", err)
+	}
+	if response.Code != 0 {
+		return fmt.Errorf("Failed to upload to Viking DB: code %d, message %s", response.Code, response.Message)
+	}
+
+	slog.InfoContext(ctx, "Indexed document in knowledge base", "user_id", record.UserID, "doc_id", record.DocID, "version", v.Version, "kb_request_id", response.RequestID)
+
+	// 跟踪处理状态，完成或失败时推送给订阅者
+	statusWatcher.Track(record.UserID, resourceID, record.DocID, record.Name)
+	return nil
+}
+
+// lookupDocument 按请求中的 :id 和 user_id 查找文档记录，找不到时写入错误响应
+func lookupDocument(c *app.RequestContext, userID string) (DocumentRecord, bool) {
+	docID := c.Param("id")
+	if !requireUserID(c, userID) || !requireDocID(c, docID) {
+		return DocumentRecord{}, false
+	}
+
+	record, ok := registry.Get(userID, docID)
+	if !ok {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": "Document not found",
+		})
+		return DocumentRecord{}, false
+	}
+	return record, true
+}
+
+// parseVersion 解析版本号参数
+func parseVersion(c *app.RequestContext, record DocumentRecord, value string) (DocumentVersion, bool) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid version: " + value,
+		})
+		return DocumentVersion{}, false
+	}
+
+	v, ok := record.FindVersion(number)
+	if !ok {
+		c.JSON(consts.StatusNotFound, utils.H{
+			"error": fmt.Sprintf("Version %d not found", number),
+		})
+		return DocumentVersion{}, false
+	}
+	return v, true
+}
+
+// 列出文档的历史版本
+func listDocumentVersions(ctx context.Context, c *app.RequestContext) {
+	record, ok := lookupDocument(c, c.Query("user_id"))
+	if !ok {
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"doc_id":         record.DocID,
+		"name":           record.Name,
+		"active_version": record.Version,
+		"versions":       record.Versions,
+	})
+}
+
+// 下载文档的指定版本，重定向到预签名URL
+func downloadDocumentVersion(ctx context.Context, c *app.RequestContext) {
+	record, ok := lookupDocument(c, c.Query("user_id"))
+	if !ok {
+		return
+	}
+	v, ok := parseVersion(c, record, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	preSignedURL, err := tos_tool.PreSignGetURLWithEnvConfig(v.ObjectKey, cfg.DownloadURLExpiry)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Failed to generate download URL: " + err.Error(),
+		})
+		return
+	}
+
+	c.Redirect(consts.StatusFound, []byte(preSignedURL))
+}
+
+// 比较文档两个版本之间的差异，仅支持文本类文档
+func diffDocumentVersions(ctx context.Context, c *app.RequestContext) {
+	record, ok := lookupDocument(c, c.Query("user_id"))
+	if !ok {
+		return
+	}
+
+	if !diffableDocTypes[record.DocType] {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Diff is only supported for txt, markdown, csv and jsonl documents",
+		})
+		return
+	}
+
+	from, ok := parseVersion(c, record, c.Query("from"))
+	if !ok {
+		return
+	}
+	toValue := c.Query("to")
+	if toValue == "" {
+		toValue = strconv.Itoa(record.Version)
+	}
+	to, ok := parseVersion(c, record, toValue)
+	if !ok {
+		return
+	}
+
+	var contents [2]string
+	for i, v := range []DocumentVersion{from, to} {
+		data, err := readObject(ctx, v.ObjectKey, maxDiffSize)
+		if err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{
+				"error": fmt.Sprintf("Failed to read version %d: %v", v.Version, err),
+			})
+			return
+		}
+		contents[i] = string(data)
+	}
+
+	lines := diffLines(splitLines(contents[0]), splitLines(contents[1]))
+	c.JSON(consts.StatusOK, utils.H{
+		"doc_id":  record.DocID,
+		"from":    from.Version,
+		"to":      to.Version,
+		"changes": lines,
+		"unified": formatUnifiedDiff(lines, fmt.Sprintf("v%d/%s", from.Version, record.Name), fmt.Sprintf("v%d/%s", to.Version, record.Name)),
+	})
+}
+
+// 回滚到指定版本：将该版本设为当前版本并重新写入知识库
+func rollbackDocument(ctx context.Context, c *app.RequestContext) {
+	var request struct {
+		UserID  string `json:"user_id"`
+		Version int    `json:"version"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": "Invalid request format: " + err.Error(),
+		})
+		return
+	}
+
+	record, ok := lookupDocument(c, request.UserID)
+	if !ok {
+		return
+	}
+	v, ok := parseVersion(c, record, strconv.Itoa(request.Version))
+	if !ok {
+		return
+	}
+	if v.Version == record.Version {
+		c.JSON(consts.StatusBadRequest, utils.H{
+			"error": fmt.Sprintf("Version %d is already active", v.Version),
+		})
+		return
+	}
+
+	resourceID, err := ensureKnowledgeBase(ctx, request.UserID)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 先写入知识库，成功后再复制到 uploads/ 下作为当前版本；写入失败时知识库恢复为原来的版本，TOS中的当前版本不变。
+	// 失败时同样保存记录，以便查看失败原因
+	active := record.ActiveVersion()
+	attempt := indexVersion(ctx, resourceID, record, v, nil, &active)
+	if saved, ok, err := registry.Update(record.UserID, record.DocID, func(rec *DocumentRecord) {
+		attempt.apply(rec)
+		if attempt.err == nil {
+			rec.Version = v.Version
+			rec.Size = v.Size
+			rec.UpdatedAt = time.Now()
+		}
+	}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save document record", "doc_id", record.DocID, "error", err)
+	} else if ok {
+		record = saved
+	}
+	if attempt.err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": attempt.err.Error(),
+		})
+		return
+	}
+
+	if err := tos_tool.CopyObjectWithEnvConfig(ctx, v.ObjectKey, record.ObjectKey); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{
+			"error": "Document rolled back in knowledge base but failed to update current file in TOS: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"message":  fmt.Sprintf("Document rolled back to version %d", v.Version),
+		"document": record,
+	})
+}
+
+// splitLines 按行切分文本，忽略末尾换行
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
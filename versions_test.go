@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"viking_db_tool"
+)
+
+func TestSubmitVersionURLUploadError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>502 Bad Gateway</html>"))
+	}))
+	defer server.Close()
+	savedDomain, savedTransport := viking_db_tool.KnowledgeBaseDomain, http.DefaultTransport
+	viking_db_tool.KnowledgeBaseDomain = strings.TrimPrefix(server.URL, "https://")
+	http.DefaultTransport = server.Client().Transport
+	defer func() {
+		viking_db_tool.KnowledgeBaseDomain, http.DefaultTransport = savedDomain, savedTransport
+	}()
+
+	record := DocumentRecord{UserID: "u1", DocID: "apdf", Name: "a.pdf", DocType: "pdf"}
+	version := DocumentVersion{Version: 1, ObjectKey: "u1/a.pdf"}
+
+	// 通过URL添加文档失败时返回错误，不能因为响应为空而崩溃
+	if _, err := submitVersion(context.Background(), "kb-1", record, version, []byte("%PDF"), nil); err == nil {
+		t.Fatal("submitVersion succeeded, want knowledge base upload error")
+	}
+}
+
+func TestSubmitVersionRestoresPreviousVersion(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			URL string `json:"url"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, r.URL.Path+" "+body.URL)
+		if r.URL.Path == viking_db_tool.DocumentUploadPath && len(calls) == 2 {
+			// 新版本写入失败
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>502 Bad Gateway</html>"))
+			return
+		}
+		w.Write([]byte(`{"code":0,"message":"success"}`))
+	}))
+	defer server.Close()
+	savedDomain, savedTransport := viking_db_tool.KnowledgeBaseDomain, http.DefaultTransport
+	viking_db_tool.KnowledgeBaseDomain = strings.TrimPrefix(server.URL, "https://")
+	http.DefaultTransport = server.Client().Transport
+	defer func() {
+		viking_db_tool.KnowledgeBaseDomain, http.DefaultTransport = savedDomain, savedTransport
+	}()
+	savedWatcher := statusWatcher
+	statusWatcher = newDocumentStatusWatcher()
+	defer func() { statusWatcher = savedWatcher }()
+
+	record := DocumentRecord{UserID: "u1", DocID: "bpdf", Name: "b.pdf", DocType: "pdf", Version: 2}
+	previous := DocumentVersion{Version: 1, ObjectKey: "versions/u1/bpdf/1/b.pdf"}
+	version := DocumentVersion{Version: 2, ObjectKey: "versions/u1/bpdf/2/b.pdf"}
+	if _, err := submitVersion(context.Background(), "kb-1", record, version, []byte("%PDF"), &previous); err == nil {
+		t.Fatal("submitVersion succeeded, want knowledge base upload error")
+	}
+
+	// 先删除旧文档，新版本写入失败后重新写入之前的版本，知识库中仍有该文档
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 3 || !strings.HasPrefix(calls[0], viking_db_tool.DocumentDeletePath) ||
+		!strings.Contains(calls[1], version.ObjectKey) || !strings.Contains(calls[2], previous.ObjectKey) {
+		t.Fatalf("knowledge base calls = %q, want delete, add version 2, add version 1", calls)
+	}
+}
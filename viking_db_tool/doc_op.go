@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"time"
 )
 
@@ -30,9 +29,10 @@ type MetaField struct {
 
 // DocumentUploadResponse represents the response structure for document upload
 type DocumentUploadResponse struct {
-	Code    int64                       `json:"code"`
-	Message string                      `json:"message,omitempty"`
-	Data    *DocumentUploadResponseData `json:"data,omitempty"`
+	Code      int64                       `json:"code"`
+	Message   string                      `json:"message,omitempty"`
+	Data      *DocumentUploadResponseData `json:"data,omitempty"`
+	RequestID string                      `json:"request_id,omitempty"`
 }
 
 // DocumentUploadResponseData represents the data part of the upload response
@@ -59,13 +59,8 @@ func UploadDocument(ctx context.Context, req *DocumentUploadRequest) (*DocumentU
 	// Create HTTP request using the existing PrepareRequest function
 	httpReq := PrepareRequest("POST", DocumentUploadPath, body)
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
 	// Execute request
-	resp, err := client.Do(httpReq.WithContext(ctx))
+	resp, err := doRequest(ctx, httpReq, 30*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -77,6 +72,8 @@ func UploadDocument(ctx context.Context, req *DocumentUploadRequest) (*DocumentU
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	logResponse(ctx, DocumentUploadPath, respBody)
+
 	// Parse response
 	var uploadResp DocumentUploadResponse
 	if err := json.Unmarshal(respBody, &uploadResp); err != nil {
@@ -189,13 +186,8 @@ func DeleteDocument(ctx context.Context, req *DocumentDeleteRequest) (*DocumentD
 	// Create HTTP request using the existing PrepareRequest function
 	httpReq := PrepareRequest("POST", DocumentDeletePath, body)
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
 	// Execute request
-	resp, err := client.Do(httpReq.WithContext(ctx))
+	resp, err := doRequest(ctx, httpReq, 30*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -207,6 +199,8 @@ func DeleteDocument(ctx context.Context, req *DocumentDeleteRequest) (*DocumentD
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	logResponse(ctx, DocumentDeletePath, respBody)
+
 	// Parse response
 	var deleteResp DocumentDeleteResponse
 	if err := json.Unmarshal(respBody, &deleteResp); err != nil {
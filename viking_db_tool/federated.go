@@ -0,0 +1,184 @@
+package viking_db_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FusionMethod 是联合检索合并多个知识库结果的方式
+type FusionMethod string
+
+const (
+	// FusionRRF 倒数排名融合：切片的融合得分为 1/(RRFConstant+名次)，只依赖各知识库内的排名，不受各知识库得分分布不同的影响
+	FusionRRF FusionMethod = "rrf"
+	// FusionScore 得分归一化：把各知识库的得分分别线性缩放到 [0, 1] 后按归一化的得分排序
+	FusionScore FusionMethod = "score"
+)
+
+// RRFConstant 是倒数排名融合的平滑常数，越大排名靠后的切片与靠前的差距越小
+var RRFConstant = 60.0
+
+// SearchTarget 是联合检索中的一个知识库，ResourceId 为空时使用 Name + Project
+type SearchTarget struct {
+	Name       string
+	Project    string
+	ResourceId string
+}
+
+// CollectionResults 是一个知识库的检索结果
+type CollectionResults struct {
+	Collection string
+	Items      []*CollectionSearchResponseItem
+}
+
+// ParseFusionMethod 解析融合方式，为空时使用倒数排名融合
+func ParseFusionMethod(value string) (FusionMethod, error) {
+	switch method := FusionMethod(strings.ToLower(value)); method {
+	case "":
+		return FusionRRF, nil
+	case FusionRRF, FusionScore:
+		return method, nil
+	default:
+		return "", fmt.Errorf("unknown fusion method %q, use rrf or score", value)
+	}
+}
+
+// SearchKnowledgeFederated 使用相同的检索参数并发检索多个知识库，按 method 合并结果，最多返回 req.Limit 个切片。
+// 每个切片的 SourceCollection 为其所在的知识库，各知识库的token用量累加。
+// 部分知识库检索失败时记录日志并返回其余知识库的结果，全部失败时返回错误
+func SearchKnowledgeFederated(ctx context.Context, req CollectionSearchKnowledgeRequest, targets []SearchTarget, method FusionMethod) (*CollectionSearchKnowledgeResponse, error) {
+	responses := make([]*CollectionSearchKnowledgeResponse, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			targetReq := req
+			targetReq.Name = target.Name
+			targetReq.Project = target.Project
+			targetReq.ResourceId = target.ResourceId
+			resp, err := SearchKnowledgeWithParams(ctx, targetReq)
+			if err == nil && resp.Code != 0 {
+				err = fmt.Errorf("code %d, message %s", resp.Code, resp.Message)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", target.Name, err)
+				return
+			}
+			responses[i] = resp
+		}()
+	}
+	wg.Wait()
+
+	var results []CollectionResults
+	var usage *TotalTokenUsage
+	for i, resp := range responses {
+		if errs[i] != nil {
+			slog.WarnContext(ctx, "federated search skipped knowledge base", slog.String("error", errs[i].Error()))
+			continue
+		}
+		if resp.Data == nil {
+			continue
+		}
+		results = append(results, CollectionResults{Collection: targets[i].Name, Items: resp.Data.ResultList})
+		usage = addSearchUsage(usage, resp.Data.TokenUsage)
+	}
+	if len(results) == 0 {
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+	}
+
+	items := FuseResults(results, method)
+	if req.Limit > 0 && len(items) > int(req.Limit) {
+		items = items[:req.Limit]
+	}
+	names := make([]string, len(targets))
+	for i, target := range targets {
+		names[i] = target.Name
+	}
+	return &CollectionSearchKnowledgeResponse{
+		Data: &CollectionSearchKnowledgeResponseData{
+			CollectionName: strings.Join(names, ","),
+			Count:          int32(len(items)),
+			TokenUsage:     usage,
+			ResultList:     items,
+		},
+	}, nil
+}
+
+// FuseResults 合并多个知识库的检索结果，按融合得分从高到低排序。
+// 返回的切片是副本，填写了 SourceCollection 和 FusionScore，得分相同时靠前的知识库优先
+func FuseResults(results []CollectionResults, method FusionMethod) []*CollectionSearchResponseItem {
+	var fused []*CollectionSearchResponseItem
+	for _, result := range results {
+		// 检索接口按文档聚合切片时返回的顺序不是得分顺序，先按得分排序
+		ranked := make([]*CollectionSearchResponseItem, len(result.Items))
+		copy(ranked, result.Items)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return relevanceScore(ranked[i]) > relevanceScore(ranked[j])
+		})
+
+		minScore, maxScore := 0.0, 0.0
+		if len(ranked) > 0 {
+			maxScore, minScore = relevanceScore(ranked[0]), relevanceScore(ranked[len(ranked)-1])
+		}
+		for rank, item := range ranked {
+			fusedItem := *item
+			fusedItem.SourceCollection = result.Collection
+			switch {
+			case method == FusionScore && maxScore > minScore:
+				fusedItem.FusionScore = (relevanceScore(item) - minScore) / (maxScore - minScore)
+			case method == FusionScore:
+				fusedItem.FusionScore = 1
+			default:
+				fusedItem.FusionScore = 1 / (RRFConstant + float64(rank+1))
+			}
+			fused = append(fused, &fusedItem)
+		}
+	}
+	sort.SliceStable(fused, func(i, j int) bool {
+		return fused[i].FusionScore > fused[j].FusionScore
+	})
+	return fused
+}
+
+// addSearchUsage 累加检索各阶段的token用量
+func addSearchUsage(total, usage *TotalTokenUsage) *TotalTokenUsage {
+	if usage == nil {
+		return total
+	}
+	if total == nil {
+		total = &TotalTokenUsage{}
+	}
+	total.EmbeddingUsage = addModelUsage(total.EmbeddingUsage, usage.EmbeddingUsage)
+	total.LLMUsage = addModelUsage(total.LLMUsage, usage.LLMUsage)
+	total.RewriteUsage = addModelUsage(total.RewriteUsage, usage.RewriteUsage)
+	if usage.RerankUsage != nil {
+		sum := *usage.RerankUsage
+		if total.RerankUsage != nil {
+			sum += *total.RerankUsage
+		}
+		total.RerankUsage = &sum
+	}
+	return total
+}
+
+func addModelUsage(total, usage *ModelTokenUsage) *ModelTokenUsage {
+	if usage == nil {
+		return total
+	}
+	sum := *usage
+	if total != nil {
+		sum.PromptTokens += total.PromptTokens
+		sum.CompletionTokens += total.CompletionTokens
+		sum.TotalTokens += total.TotalTokens
+	}
+	return &sum
+}
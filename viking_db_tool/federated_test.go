@@ -0,0 +1,78 @@
+package viking_db_tool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFuseResultsRRF(t *testing.T) {
+	results := []CollectionResults{
+		{Collection: "kb_u1", Items: []*CollectionSearchResponseItem{
+			chunk("a", 1, 0.5, "u1-second"),
+			chunk("a", 2, 0.6, "u1-first"),
+		}},
+		{Collection: "kb_team", Items: []*CollectionSearchResponseItem{
+			chunk("b", 1, 0.95, "team-first"),
+			chunk("b", 2, 0.9, "team-second"),
+			chunk("b", 3, 0.85, "team-third"),
+		}},
+	}
+	fused := FuseResults(results, FusionRRF)
+
+	// 同一名次按知识库顺序交替，与各知识库的得分高低无关
+	want := "u1-first,team-first,u1-second,team-second,team-third"
+	if got := strings.Join(contents(fused), ","); got != want {
+		t.Fatalf("FuseResults() = %s, want %s", got, want)
+	}
+	if fused[0].SourceCollection != "kb_u1" || fused[1].SourceCollection != "kb_team" {
+		t.Errorf("source collections = %q, %q", fused[0].SourceCollection, fused[1].SourceCollection)
+	}
+	if fused[0].FusionScore != 1/(RRFConstant+1) {
+		t.Errorf("fusion score = %v", fused[0].FusionScore)
+	}
+	if results[0].Items[0].SourceCollection != "" {
+		t.Error("input items should not be modified")
+	}
+}
+
+func TestFuseResultsScore(t *testing.T) {
+	results := []CollectionResults{
+		{Collection: "kb_u1", Items: []*CollectionSearchResponseItem{
+			chunk("a", 1, 0.2, "u1-low"),
+			chunk("a", 2, 0.4, "u1-high"),
+			chunk("a", 3, 0.35, "u1-mid"),
+		}},
+		{Collection: "kb_team", Items: []*CollectionSearchResponseItem{
+			chunk("b", 1, 0.9, "team-high"),
+			chunk("b", 2, 0.8, "team-low"),
+		}},
+	}
+	fused := FuseResults(results, FusionScore)
+
+	// 各知识库分别归一化：u1-mid 为 0.75，高于 team-low 的 0
+	want := "u1-high,team-high,u1-mid,u1-low,team-low"
+	if got := strings.Join(contents(fused), ","); got != want {
+		t.Fatalf("FuseResults() = %s, want %s", got, want)
+	}
+}
+
+func TestAddSearchUsage(t *testing.T) {
+	rerank := int64(5)
+	total := addSearchUsage(nil, &TotalTokenUsage{EmbeddingUsage: &ModelTokenUsage{PromptTokens: 10, TotalTokens: 10}, RerankUsage: &rerank})
+	total = addSearchUsage(total, &TotalTokenUsage{EmbeddingUsage: &ModelTokenUsage{PromptTokens: 7, TotalTokens: 7}, RerankUsage: &rerank})
+	if total.EmbeddingUsage.TotalTokens != 17 || *total.RerankUsage != 10 || rerank != 5 {
+		t.Fatalf("addSearchUsage() = %+v, rerank %d", total.EmbeddingUsage, *total.RerankUsage)
+	}
+}
+
+func TestParseFusionMethod(t *testing.T) {
+	if method, err := ParseFusionMethod(""); err != nil || method != FusionRRF {
+		t.Fatalf("ParseFusionMethod(\"\") = %q, %v", method, err)
+	}
+	if method, err := ParseFusionMethod("Score"); err != nil || method != FusionScore {
+		t.Fatalf("ParseFusionMethod(Score) = %q, %v", method, err)
+	}
+	if _, err := ParseFusionMethod("max"); err == nil {
+		t.Fatal("expected error for unknown method")
+	}
+}
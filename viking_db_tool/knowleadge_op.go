@@ -6,10 +6,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"iter"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/volcengine/volc-sdk-golang/base"
@@ -163,9 +167,10 @@ type PostProcessing struct {
 检索接口返回参数结构体，详细介绍见官方文档
 */
 type CollectionSearchKnowledgeResponse struct {
-	Code    int64                                  `json:"code"`
-	Message string                                 `json:"message,omitempty"`
-	Data    *CollectionSearchKnowledgeResponseData `json:"data,omitempty"`
+	Code      int64                                  `json:"code"`
+	Message   string                                 `json:"message,omitempty"`
+	Data      *CollectionSearchKnowledgeResponseData `json:"data,omitempty"`
+	RequestID string                                 `json:"request_id,omitempty"`
 }
 type CollectionSearchKnowledgeResponseData struct {
 	CollectionName string                          `json:"collection_name"`
@@ -209,6 +214,10 @@ type CollectionSearchResponseItem struct {
 	ChunkAttachmentList []ChunkAttachment                   `json:"chunk_attachment,omitempty"`
 	TableChunkFields    []PointTableChunkField              `json:"table_chunk_fields,omitempty"`
 	OriginalCoordinate  *ChunkPositions                     `json:"original_coordinate,omitempty"`
+
+	// 以下字段不由检索接口返回，联合检索多个知识库时在本地填写
+	SourceCollection string  `json:"source_collection,omitempty"` // 切片所在的知识库名称
+	FusionScore      float64 `json:"fusion_score,omitempty"`      // 融合多个知识库结果后的排序得分
 }
 
 type CollectionSearchResponseItemDocInfo struct {
@@ -258,9 +267,10 @@ type CollectionChatCompletionRequest struct {
 */
 
 type CollectionChatCompletionResponse struct {
-	Code    int64                                 `json:"code"`
-	Message string                                `json:"message,omitempty"`
-	Data    *CollectionChatCompletionResponseData `json:"data,omitempty"`
+	Code      int64                                 `json:"code"`
+	Message   string                                `json:"message,omitempty"`
+	Data      *CollectionChatCompletionResponseData `json:"data,omitempty"`
+	RequestID string                                `json:"request_id,omitempty"`
 }
 
 type CollectionChatCompletionResponseData struct {
@@ -275,6 +285,20 @@ type ModelTokenUsage struct {
 	TotalTokens      int64 `json:"total_tokens"`      // PromptTokens + CompletionTokens
 }
 
+/*
+解析模型生成接口返回的token使用情况，Usage 为空时返回 nil
+*/
+func (d *CollectionChatCompletionResponseData) TokenUsage() (*ModelTokenUsage, error) {
+	if d == nil || d.Usage == "" {
+		return nil, nil
+	}
+	var usage ModelTokenUsage
+	if err := ParseJsonUseNumber([]byte(d.Usage), &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse token usage: %w", err)
+	}
+	return &usage, nil
+}
+
 /*
 知识库创建请求参数结构体
 */
@@ -304,9 +328,10 @@ type TableField struct {
 知识库创建响应参数结构体
 */
 type CreateKnowledgeBaseResponse struct {
-	Code    int64                            `json:"code"`
-	Message string                           `json:"message,omitempty"`
-	Data    *CreateKnowledgeBaseResponseData `json:"data,omitempty"`
+	Code      int64                            `json:"code"`
+	Message   string                           `json:"message,omitempty"`
+	Data      *CreateKnowledgeBaseResponseData `json:"data,omitempty"`
+	RequestID string                           `json:"request_id,omitempty"`
 }
 
 type CreateKnowledgeBaseResponseData struct {
@@ -327,9 +352,10 @@ type KnowledgeBaseInfoRequest struct {
 知识库信息查询响应参数结构体
 */
 type KnowledgeBaseInfoResponse struct {
-	Code    int64                          `json:"code"`
-	Message string                         `json:"message,omitempty"`
-	Data    *KnowledgeBaseInfoResponseData `json:"data,omitempty"`
+	Code      int64                          `json:"code"`
+	Message   string                         `json:"message,omitempty"`
+	Data      *KnowledgeBaseInfoResponseData `json:"data,omitempty"`
+	RequestID string                         `json:"request_id,omitempty"`
 }
 
 type KnowledgeBaseInfoResponseData struct {
@@ -385,6 +411,122 @@ func isVisionModel(modelName string) bool {
 	return strings.Contains(modelName, "vision")
 }
 
+/*
+知识库接口的并发控制：所有请求共享同一组并发名额，超出时排队等待，避免突发流量打满知识库接口的配额
+*/
+var (
+	DefaultMaxConcurrentRequests = 16
+	requestSlots                 = make(chan struct{}, DefaultMaxConcurrentRequests)
+)
+
+// SetMaxConcurrentRequests 设置同时发往知识库接口的最大请求数，需在发出请求之前调用
+func SetMaxConcurrentRequests(n int) {
+	if n > 0 {
+		requestSlots = make(chan struct{}, n)
+	}
+}
+
+// Observer 在每次请求知识库接口之前调用，返回的函数在请求结束（响应体关闭）时调用，用于统计指标和链路追踪。
+// op 为接口路径，返回的 ctx 用于发出请求
+type Observer func(ctx context.Context, op string) (context.Context, func(err error))
+
+var observer Observer
+
+// SetObserver 注册知识库请求的观察者，需在发出请求之前调用
+func SetObserver(o Observer) {
+	observer = o
+}
+
+func observe(ctx context.Context, op string) (context.Context, func(error)) {
+	if observer == nil {
+		return ctx, func(error) {}
+	}
+	return observer(ctx, op)
+}
+
+// doRequest 占用一个并发名额后发送请求，排队时响应 ctx 取消；名额在响应体关闭时释放
+func doRequest(ctx context.Context, req *http.Request, timeout time.Duration) (*http.Response, error) {
+	return sendRequest(ctx, req, timeout, requestSlots)
+}
+
+// doUnboundedRequest 不占用并发名额直接发送请求，用于健康检查，避免名额被对话等长请求占满时排队超时
+func doUnboundedRequest(ctx context.Context, req *http.Request, timeout time.Duration) (*http.Response, error) {
+	return sendRequest(ctx, req, timeout, nil)
+}
+
+// sendRequest 发送请求，slots 不为空时先占用其中一个名额
+func sendRequest(ctx context.Context, req *http.Request, timeout time.Duration, slots chan struct{}) (*http.Response, error) {
+	ctx, done := observe(ctx, req.URL.Path)
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			done(ctx.Err())
+			return nil, ctx.Err()
+		}
+	}
+	releaseSlot := func() {
+		if slots != nil {
+			<-slots
+		}
+	}
+
+	start := time.Now()
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		releaseSlot()
+		done(err)
+		slog.ErrorContext(ctx, "knowledge base request failed",
+			slog.String("path", req.URL.Path), slog.Duration("duration", time.Since(start)), slog.String("error", err.Error()))
+		return nil, err
+	}
+	slog.DebugContext(ctx, "knowledge base request finished",
+		slog.String("path", req.URL.Path), slog.Int("status", resp.StatusCode), slog.Duration("duration", time.Since(start)))
+
+	var statusErr error
+	if resp.StatusCode >= http.StatusBadRequest {
+		statusErr = fmt.Errorf("knowledge base returned HTTP %d", resp.StatusCode)
+	}
+	release := sync.OnceFunc(func() {
+		releaseSlot()
+		done(statusErr)
+	})
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// logResponse 记录知识库接口返回的错误码和 request_id，返回错误码时记为警告，便于按 request_id 向知识库排查
+func logResponse(ctx context.Context, path string, body []byte) {
+	var result struct {
+		Code      int64  `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		slog.WarnContext(ctx, "knowledge base returned invalid response", slog.String("path", path), slog.String("error", err.Error()))
+		return
+	}
+
+	level := slog.LevelDebug
+	if result.Code != 0 {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "knowledge base response",
+		slog.String("path", path), slog.Int64("code", result.Code), slog.String("message", result.Message), slog.String("kb_request_id", result.RequestID))
+}
+
+// releasingBody 在关闭时释放并发名额并结束观察，流式响应读完之前一直占用名额
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
 func PrepareRequest(method string, path string, body []byte) *http.Request {
 	u := url.URL{
 		Scheme: "https",
@@ -453,8 +595,7 @@ func SearchKnowledge(ctx context.Context) (*CollectionSearchKnowledgeResponse, e
 		return nil, err
 	}
 	req := PrepareRequest("POST", SearchKnowledgePath, searchKnowledgeReqParamsBytes)
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doRequest(ctx, req, 10*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -464,6 +605,7 @@ func SearchKnowledge(ctx context.Context) (*CollectionSearchKnowledgeResponse, e
 	if err != nil {
 		return nil, err
 	}
+	logResponse(ctx, SearchKnowledgePath, body)
 	var searchKnowledgeResp *CollectionSearchKnowledgeResponse
 	err = ParseJsonUseNumber(body, &searchKnowledgeResp)
 	if err != nil {
@@ -479,8 +621,7 @@ func SearchKnowledgeWithParams(ctx context.Context, searchReq CollectionSearchKn
 		return nil, err
 	}
 	req := PrepareRequest("POST", SearchKnowledgePath, searchReqBytes)
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doRequest(ctx, req, 10*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -490,6 +631,7 @@ func SearchKnowledgeWithParams(ctx context.Context, searchReq CollectionSearchKn
 	if err != nil {
 		return nil, err
 	}
+	logResponse(ctx, SearchKnowledgePath, body)
 	var searchKnowledgeResp *CollectionSearchKnowledgeResponse
 	err = ParseJsonUseNumber(body, &searchKnowledgeResp)
 	if err != nil {
@@ -500,14 +642,14 @@ func SearchKnowledgeWithParams(ctx context.Context, searchReq CollectionSearchKn
 
 func GenerateChatCompletionReqParams(stream bool, messages []MessageParam) *CollectionChatCompletionRequest {
 	return &CollectionChatCompletionRequest{
-		Model:            ModelName, // 如果使用私有ep，此处替换为私有ep即可，格式 ep-xxx-xxx
-		ModelVersion:     "",        // 模型版本，使用公有接入点时，可以选择指定模型版本，不指定则服务会自动指定默认版本
-		Stream:           stream,    // 模型结果是否流式返回
-		ReturnTokenUsage: true,      // 是否返回token使用情况
-		MaxTokens:        4096,      // 最大token数
-		Temperature:      0.7,       // 模型温度,取值范围0~1，值越大随机性越大
-		APIKey:           APIKey,    // 使用私有ep时，必须传递此参数才能生效
-		Messages:         messages,  // 模型对话信息
+		Model:            ModelName,       // 如果使用私有ep，此处替换为私有ep即可，格式 ep-xxx-xxx
+		ModelVersion:     "",              // 模型版本，使用公有接入点时，可以选择指定模型版本，不指定则服务会自动指定默认版本
+		Stream:           stream,          // 模型结果是否流式返回
+		ReturnTokenUsage: true,            // 是否返回token使用情况
+		MaxTokens:        MaxAnswerTokens, // 最大token数
+		Temperature:      0.7,             // 模型温度,取值范围0~1，值越大随机性越大
+		APIKey:           APIKey,          // 使用私有ep时，必须传递此参数才能生效
+		Messages:         messages,        // 模型对话信息
 	}
 }
 
@@ -520,10 +662,7 @@ func ChatCompletion(ctx context.Context, messages []MessageParam) (*CollectionCh
 	}
 
 	request := PrepareRequest("POST", ChatCompletionPath, chatCompletionReqParamsBytes)
-	client := &http.Client{
-		Timeout: time.Second * 120,
-	}
-	resp, err := client.Do(request)
+	resp, err := doRequest(ctx, request, time.Second*120)
 	if err != nil {
 		return nil, err
 	}
@@ -532,6 +671,7 @@ func ChatCompletion(ctx context.Context, messages []MessageParam) (*CollectionCh
 	if err != nil {
 		return nil, err
 	}
+	logResponse(ctx, ChatCompletionPath, body)
 	var chatCompletionResp *CollectionChatCompletionResponse
 	err = ParseJsonUseNumber(body, &chatCompletionResp)
 	if err != nil {
@@ -549,11 +689,8 @@ func ChatCompletionStream(ctx context.Context, messages []MessageParam) (answer
 	}
 
 	request := PrepareRequest("POST", ChatCompletionPath, chatCompletionReqParamsBytes)
-	client := &http.Client{
-		Timeout: time.Second * 120,
-	}
 	request.Header.Set("Accept", "text/event-stream")
-	resp, err := client.Do(request)
+	resp, err := doRequest(ctx, request, time.Second*120)
 	if err != nil {
 		return "", nil, err
 	}
@@ -582,8 +719,12 @@ func ChatCompletionStream(ctx context.Context, messages []MessageParam) (answer
 		if err != nil {
 			return "", nil, err
 		}
-		// 获取流式返回的内容
-		fmt.Println(chatCompletionResponse.Data.GenerateAnswer)
+		if chatCompletionResponse.Code != 0 {
+			logResponse(ctx, ChatCompletionPath, []byte(streamJson))
+		}
+		if chatCompletionResponse.Data == nil {
+			continue
+		}
 
 		answerBuilder.WriteString(chatCompletionResponse.Data.GenerateAnswer)
 
@@ -619,60 +760,43 @@ func getContentForPrompt(item *CollectionSearchResponseItem, imageNum int) strin
 	return content
 }
 
+// GeneratePrompt 把全部检索结果拼接为提示词，不限制长度；需要按模型上下文长度裁剪时使用 GeneratePromptWithBudget
 func GeneratePrompt(resp *CollectionSearchKnowledgeResponse) (string, []string, error) {
-	if resp == nil {
-		return "", nil, fmt.Errorf("response is nil")
-	}
-	if resp.Code != 0 {
-		return "", nil, fmt.Errorf(resp.Message)
-	}
+	prompt, images, _, err := GeneratePromptWithBudget(resp, PromptBudget{})
+	return prompt, images, err
+}
 
+// renderPromptChunk 按 PromptExtraContextExample 配置的字段拼接一个切片，imageNum 为切片图片的编号，没有图片时为 0
+func renderPromptChunk(point *CollectionSearchResponseItem, imageNum int) string {
 	var promptBuilder strings.Builder
-	var imageURLs []string
-	usingVLM := isVisionModel(ModelName)
-	imageCnt := 0
-
-	for _, point := range resp.Data.ResultList {
-		// 对vision模型需要额外处理图片链接
-		if usingVLM && len(point.ChunkAttachmentList) > 0 {
-			link := point.ChunkAttachmentList[0].Link
-			if link != "" {
-				imageURLs = append(imageURLs, link)
-				imageCnt++
-			}
-		}
 
-		// 处理系统字段
-		docInfo := point.DocInfo
-
-		// 拼接用户指定的系统字段
-		for _, sysField := range PromptExtraContextExample.SystemFields {
-			switch sysField {
-			case SysFieldDocName:
-				promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, docInfo.DocName))
-			case SysFieldTitle:
-				promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, docInfo.Title))
-			case SysFieldChunkTitle:
-				promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, point.ChunkTitle))
-			case SysFieldContent:
-				promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, getContentForPrompt(point, imageCnt)))
-			}
+	// 处理系统字段
+	docInfo := point.DocInfo
+
+	// 拼接用户指定的系统字段
+	for _, sysField := range PromptExtraContextExample.SystemFields {
+		switch sysField {
+		case SysFieldDocName:
+			promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, docInfo.DocName))
+		case SysFieldTitle:
+			promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, docInfo.Title))
+		case SysFieldChunkTitle:
+			promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, point.ChunkTitle))
+		case SysFieldContent:
+			promptBuilder.WriteString(fmt.Sprintf("%s: %s\n", sysField, getContentForPrompt(point, imageNum)))
 		}
+	}
 
-		// 结构化数据- 拼接用户指定的自定义字段
-		for _, selfField := range PromptExtraContextExample.SelfDefineFields {
-			for _, tableChunkField := range point.TableChunkFields {
-				if tableChunkField.FieldName == selfField {
-					promptBuilder.WriteString(fmt.Sprintf("%s: %v\n", tableChunkField.FieldName, tableChunkField.FieldValue))
-				}
+	// 结构化数据- 拼接用户指定的自定义字段
+	for _, selfField := range PromptExtraContextExample.SelfDefineFields {
+		for _, tableChunkField := range point.TableChunkFields {
+			if tableChunkField.FieldName == selfField {
+				promptBuilder.WriteString(fmt.Sprintf("%s: %v\n", tableChunkField.FieldName, tableChunkField.FieldValue))
 			}
 		}
-		promptBuilder.WriteString("---\n")
 	}
-
-	// 基础提示词模板替换
-	finalPrompt := strings.Replace(BasePrompt, "{prompt}", promptBuilder.String(), -1)
-	return finalPrompt, imageURLs, nil
+	promptBuilder.WriteString("---\n")
+	return promptBuilder.String()
 }
 
 // RAG 检索增强生成流程串联
@@ -688,7 +812,7 @@ func RAG(ctx context.Context, stream bool) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("提示词：%s\n", prompt)
+	slog.DebugContext(ctx, "RAG prompt", slog.String("prompt", prompt))
 
 	// 生成Chat的Message结构体，拼接message对话, 问题对应role为user，系统对应role为system, 答案对应role为assistant, 内容对应content
 	var messages []MessageParam
@@ -736,8 +860,7 @@ func RAG(ctx context.Context, stream bool) error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf("大模型流式调用返回结果：%s\n", answer)
-		fmt.Printf("大模型流式调用返回token使用情况：%+v\n", usage)
+		slog.DebugContext(ctx, "RAG stream answer", slog.String("answer", answer), slog.Any("usage", usage))
 	} else {
 		// 非流式调用
 		ChatCompletionResponse, err := ChatCompletion(ctx, messages)
@@ -748,15 +871,13 @@ func RAG(ctx context.Context, stream bool) error {
 			return fmt.Errorf(ChatCompletionResponse.Message)
 		}
 
-		answer := ChatCompletionResponse.Data.GenerateAnswer
-		fmt.Printf("非流式大模型返回结果：%s\n", answer)
-
 		var modelTokenUsage ModelTokenUsage
 		err = ParseJsonUseNumber([]byte(ChatCompletionResponse.Data.Usage), &modelTokenUsage)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("非流式大模型返回token使用情况：%+v\n", modelTokenUsage)
+		slog.DebugContext(ctx, "RAG answer",
+			slog.String("answer", ChatCompletionResponse.Data.GenerateAnswer), slog.Any("usage", modelTokenUsage))
 	}
 	return nil
 }
@@ -781,8 +902,7 @@ func CreateKnowledgeBase(ctx context.Context, name, description, dataType, proje
 
 	// 准备HTTP请求
 	req := PrepareRequest("POST", CreateKnowledgeBasePath, createReqBytes)
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doRequest(ctx, req, 30*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -793,6 +913,7 @@ func CreateKnowledgeBase(ctx context.Context, name, description, dataType, proje
 	if err != nil {
 		return nil, err
 	}
+	logResponse(ctx, CreateKnowledgeBasePath, body)
 
 	// 解析响应
 	var createResp *CreateKnowledgeBaseResponse
@@ -830,8 +951,7 @@ func GetKnowledgeBaseInfo(ctx context.Context, name, project string) (*Knowledge
 
 	// 准备HTTP请求
 	req := PrepareRequest("POST", KnowledgeBaseInfoPath, infoReqBytes)
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := doRequest(ctx, req, 30*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -842,6 +962,7 @@ func GetKnowledgeBaseInfo(ctx context.Context, name, project string) (*Knowledge
 	if err != nil {
 		return nil, err
 	}
+	logResponse(ctx, KnowledgeBaseInfoPath, body)
 
 	// 解析响应
 	var infoResp *KnowledgeBaseInfoResponse
@@ -853,6 +974,49 @@ func GetKnowledgeBaseInfo(ctx context.Context, name, project string) (*Knowledge
 	return infoResp, nil
 }
 
+/*
+检查知识库接口是否可用：查询知识库信息，收到知识库返回的错误码（包括知识库不存在）也说明网络和鉴权正常，
+只有请求失败、鉴权被拒绝或服务端错误时返回错误
+*/
+func Ping(ctx context.Context, name, project string) error {
+	infoReqBytes, err := SerializeToJsonBytesUseNumber(KnowledgeBaseInfoRequest{
+		Name:    name,
+		Project: project,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 健康检查不占用并发名额，名额被占满时同样能及时返回
+	req := PrepareRequest("POST", KnowledgeBaseInfoPath, infoReqBytes)
+	resp, err := doUnboundedRequest(ctx, req, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("knowledge base rejected the credentials: HTTP %d", resp.StatusCode)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("knowledge base returned HTTP %d", resp.StatusCode)
+	}
+
+	var infoResp KnowledgeBaseInfoResponse
+	if err := ParseJsonUseNumber(body, &infoResp); err != nil {
+		return fmt.Errorf("knowledge base returned invalid response: %w", err)
+	}
+	// 探测的知识库通常不存在，返回错误码是正常情况，不记为警告
+	slog.DebugContext(ctx, "knowledge base ping",
+		slog.Int64("code", infoResp.Code), slog.String("message", infoResp.Message), slog.String("kb_request_id", infoResp.RequestID))
+	return nil
+}
+
 /*
 检查知识库是否存在
 */
@@ -887,9 +1051,10 @@ type DocumentListRequest struct {
 文档列表查询响应参数结构体
 */
 type DocumentListResponse struct {
-	Code    int64                     `json:"code"`
-	Message string                    `json:"message,omitempty"`
-	Data    *DocumentListResponseData `json:"data,omitempty"`
+	Code      int64                     `json:"code"`
+	Message   string                    `json:"message,omitempty"`
+	Data      *DocumentListResponseData `json:"data,omitempty"`
+	RequestID string                    `json:"request_id,omitempty"`
 }
 
 type DocumentListResponseData struct {
@@ -953,9 +1118,10 @@ type DocumentInfoRequest struct {
 文档信息查询响应参数结构体
 */
 type DocumentInfoResponse struct {
-	Code    int64                     `json:"code"`
-	Message string                    `json:"message,omitempty"`
-	Data    *DocumentInfoResponseData `json:"data,omitempty"`
+	Code      int64                     `json:"code"`
+	Message   string                    `json:"message,omitempty"`
+	Data      *DocumentInfoResponseData `json:"data,omitempty"`
+	RequestID string                    `json:"request_id,omitempty"`
 }
 
 type DocumentInfoResponseData struct {
@@ -974,16 +1140,21 @@ type DocumentInfoResponseData struct {
 }
 
 type DocumentProcessingStatus struct {
-	ProcessStatus int `json:"process_status"`
+	ProcessStatus int    `json:"process_status"`
+	FailedCode    int    `json:"failed_code,omitempty"` // 处理失败时的错误码
+	FailedMsg     string `json:"failed_msg,omitempty"`  // 处理失败时的原因
 }
 
 // DocumentStatusInfo 表示文档处理状态的详细信息
 type DocumentStatusInfo struct {
 	DocID         string `json:"doc_id"`
 	DocName       string `json:"doc_name"`
+	DocType       string `json:"doc_type,omitempty"`
 	ProcessStatus int    `json:"process_status"`
 	StatusText    string `json:"status_text"`
 	IsCompleted   bool   `json:"is_completed"`
+	UpdateTime    int64  `json:"update_time,omitempty"`
+	FailedMsg     string `json:"failed_msg,omitempty"`
 }
 
 // getStatusText 根据process_status返回对应的状态文本
@@ -1004,6 +1175,17 @@ func getStatusText(status int) string {
 	}
 }
 
+// 知识库接口表示资源不存在的返回码，其余非 0 返回码（如限流、服务端错误）不代表资源不存在
+const (
+	CodeCollectionNotExist int64 = 1000005 // 知识库不存在
+	CodeDocNotExist        int64 = 1001001 // 文档不存在
+)
+
+// IsDocumentNotFound 返回文档信息查询的返回码是否表示文档已不存在，知识库被删除时其中的文档同样不存在
+func IsDocumentNotFound(code int64) bool {
+	return code == CodeDocNotExist || code == CodeCollectionNotExist
+}
+
 /*
 查询单个文档信息
 */
@@ -1016,8 +1198,7 @@ func GetDocumentInfo(ctx context.Context, req DocumentInfoRequest) (*DocumentInf
 
 	// 准备HTTP请求
 	httpReq := PrepareRequest("POST", DocumentInfoPath, reqBytes)
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(httpReq.WithContext(ctx))
+	resp, err := doRequest(ctx, httpReq, 30*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -1028,6 +1209,7 @@ func GetDocumentInfo(ctx context.Context, req DocumentInfoRequest) (*DocumentInf
 	if err != nil {
 		return nil, err
 	}
+	logResponse(ctx, DocumentInfoPath, body)
 
 	// 解析响应
 	var infoResp DocumentInfoResponse
@@ -1064,43 +1246,34 @@ func CheckDocumentProcessingStatus(ctx context.Context, resourceID, docID string
 }
 
 /*
-获取知识库中所有文档的处理状态 - 直接从文档列表响应中获取
+获取知识库中所有文档的处理状态 - 分页遍历文档列表，从中获取处理状态
 */
 func GetDocumentProcessingStatus(ctx context.Context, resourceID string) ([]DocumentStatusInfo, error) {
-	// 获取文档列表
-	req := DocumentListRequest{
-		ResourceID: resourceID,
-		Limit:      100, // 获取足够多的结果
-	}
-
-	resp, err := GetDocumentList(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	// 如果返回码不为0，说明查询失败
-	if resp.Code != 0 {
-		return nil, fmt.Errorf("query failed with code %d: %s", resp.Code, resp.Message)
-	}
-
-	// 构建文档处理状态列表
 	var docStatusList []DocumentStatusInfo
-
-	// 直接从文档列表中获取处理状态
-	for _, doc := range resp.Data.DocList {
-		statusInfo := DocumentStatusInfo{
-			DocID:         doc.DocID,
-			DocName:       doc.DocName,
-			ProcessStatus: doc.Status.ProcessStatus,
-			StatusText:    getStatusText(doc.Status.ProcessStatus),
-			IsCompleted:   doc.Status.ProcessStatus == 0, // 0表示处理完成
+	for doc, err := range Documents(ctx, resourceID) {
+		if err != nil {
+			return nil, err
 		}
-		docStatusList = append(docStatusList, statusInfo)
+		docStatusList = append(docStatusList, NewDocumentStatusInfo(doc))
 	}
 
 	return docStatusList, nil
 }
 
+// NewDocumentStatusInfo 从文档列表中的文档信息构建处理状态
+func NewDocumentStatusInfo(doc DocumentInfo) DocumentStatusInfo {
+	return DocumentStatusInfo{
+		DocID:         doc.DocID,
+		DocName:       doc.DocName,
+		DocType:       doc.DocType,
+		ProcessStatus: doc.Status.ProcessStatus,
+		StatusText:    getStatusText(doc.Status.ProcessStatus),
+		IsCompleted:   doc.Status.ProcessStatus == 0, // 0表示处理完成
+		UpdateTime:    doc.UpdateTime,
+		FailedMsg:     doc.Status.FailedMsg,
+	}
+}
+
 /*
 查询知识库中的文档列表
 */
@@ -1113,8 +1286,7 @@ func GetDocumentList(ctx context.Context, req DocumentListRequest) (*DocumentLis
 
 	// 准备HTTP请求
 	httpReq := PrepareRequest("POST", DocumentListPath, reqBytes)
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(httpReq.WithContext(ctx))
+	resp, err := doRequest(ctx, httpReq, 30*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -1125,6 +1297,7 @@ func GetDocumentList(ctx context.Context, req DocumentListRequest) (*DocumentLis
 	if err != nil {
 		return nil, err
 	}
+	logResponse(ctx, DocumentListPath, body)
 
 	// 解析响应
 	var listResp DocumentListResponse
@@ -1136,6 +1309,60 @@ func GetDocumentList(ctx context.Context, req DocumentListRequest) (*DocumentLis
 	return &listResp, nil
 }
 
+// DocumentListPageSize 分页查询文档列表时每页的文档数
+const DocumentListPageSize = 100
+
+/*
+逐页遍历知识库中的全部文档，出错时返回错误并结束遍历
+*/
+func Documents(ctx context.Context, resourceID string) iter.Seq2[DocumentInfo, error] {
+	return func(yield func(DocumentInfo, error) bool) {
+		seen := 0
+		for offset := 0; ; offset += DocumentListPageSize {
+			resp, err := GetDocumentList(ctx, DocumentListRequest{
+				ResourceID: resourceID,
+				Offset:     offset,
+				Limit:      DocumentListPageSize,
+			})
+			if err == nil && resp.Code != 0 {
+				err = fmt.Errorf("query failed with code %d: %s", resp.Code, resp.Message)
+			}
+			if err != nil {
+				yield(DocumentInfo{}, err)
+				return
+			}
+			if resp.Data == nil {
+				return
+			}
+
+			for _, doc := range resp.Data.DocList {
+				if !yield(doc, nil) {
+					return
+				}
+			}
+
+			seen += len(resp.Data.DocList)
+			if len(resp.Data.DocList) < DocumentListPageSize || seen >= resp.Data.TotalNum {
+				return
+			}
+		}
+	}
+}
+
+/*
+分页查询知识库中的全部文档
+*/
+func ListAllDocuments(ctx context.Context, resourceID string) ([]DocumentInfo, error) {
+	var docs []DocumentInfo
+	for doc, err := range Documents(ctx, resourceID) {
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
 /*
 func main() {
 	ctx := context.Background()
@@ -0,0 +1,269 @@
+package viking_db_tool
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PostProcessStep 是检索结果的一个后处理步骤，输入为按顺序排列的切片，返回处理后的切片。
+// 步骤不修改传入的切片，需要改动时复制一份
+type PostProcessStep func(items []*CollectionSearchResponseItem) []*CollectionSearchResponseItem
+
+// PostProcessResults 依次执行后处理步骤，返回结果列表替换后的检索响应副本，原响应不变
+func PostProcessResults(resp *CollectionSearchKnowledgeResponse, steps ...PostProcessStep) *CollectionSearchKnowledgeResponse {
+	if resp == nil || resp.Data == nil || len(steps) == 0 {
+		return resp
+	}
+	items := resp.Data.ResultList
+	for _, step := range steps {
+		items = step(items)
+	}
+
+	data := *resp.Data
+	data.ResultList = items
+	data.Count = int32(len(items))
+	processed := *resp
+	processed.Data = &data
+	return &processed
+}
+
+// relevanceScore 返回切片与问题的相关度得分，开启重排时使用重排得分
+func relevanceScore(item *CollectionSearchResponseItem) float64 {
+	if item.RerankScore != 0 {
+		return item.RerankScore
+	}
+	return item.Score
+}
+
+// rankScore 返回切片的排序得分，联合检索的结果使用融合得分，不同知识库的相关度得分不可直接比较
+func rankScore(item *CollectionSearchResponseItem) float64 {
+	if item.FusionScore != 0 {
+		return item.FusionScore
+	}
+	return relevanceScore(item)
+}
+
+// docKey 区分不同知识库中的同一文档ID
+func docKey(item *CollectionSearchResponseItem) string {
+	return item.SourceCollection + "/" + item.DocInfo.Docid
+}
+
+// MinScoreStep 去掉相关度得分低于 minScore 的切片，并按得分从高到低排序
+func MinScoreStep(minScore float64) PostProcessStep {
+	return func(items []*CollectionSearchResponseItem) []*CollectionSearchResponseItem {
+		kept := make([]*CollectionSearchResponseItem, 0, len(items))
+		for _, item := range items {
+			if relevanceScore(item) >= minScore {
+				kept = append(kept, item)
+			}
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			return rankScore(kept[i]) > rankScore(kept[j])
+		})
+		return kept
+	}
+}
+
+// DedupStep 去掉内容几乎相同的切片，只保留排在前面的一个。
+// 相似度为切片内容三字符片段集合的 Jaccard 系数，不低于 threshold 时视为重复
+func DedupStep(threshold float64) PostProcessStep {
+	return func(items []*CollectionSearchResponseItem) []*CollectionSearchResponseItem {
+		kept := make([]*CollectionSearchResponseItem, 0, len(items))
+		var keptShingles []map[string]struct{}
+		for _, item := range items {
+			shingles := contentShingles(item.Content)
+			duplicate := false
+			for _, other := range keptShingles {
+				if jaccard(shingles, other) >= threshold {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				kept = append(kept, item)
+				keptShingles = append(keptShingles, shingles)
+			}
+		}
+		return kept
+	}
+}
+
+// contentShingles 把内容规范化（去掉空白，英文转小写）后切分为相邻三个字符组成的片段
+func contentShingles(content string) map[string]struct{} {
+	runes := []rune(strings.ToLower(strings.Join(strings.FieldsFunc(content, unicode.IsSpace), "")))
+	shingles := make(map[string]struct{})
+	if len(runes) < 3 {
+		shingles[string(runes)] = struct{}{}
+		return shingles
+	}
+	for i := 0; i+3 <= len(runes); i++ {
+		shingles[string(runes[i:i+3])] = struct{}{}
+	}
+	return shingles
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	intersection := 0
+	for s := range a {
+		if _, ok := b[s]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
+
+// MaxChunksPerDocStep 每个文档最多保留排在前面的 max 个切片，避免单个文档占满上下文
+func MaxChunksPerDocStep(max int) PostProcessStep {
+	return func(items []*CollectionSearchResponseItem) []*CollectionSearchResponseItem {
+		counts := make(map[string]int)
+		kept := make([]*CollectionSearchResponseItem, 0, len(items))
+		for _, item := range items {
+			if counts[docKey(item)] < max {
+				counts[docKey(item)]++
+				kept = append(kept, item)
+			}
+		}
+		return kept
+	}
+}
+
+// MergeAdjacentStep 把同一文档中 ChunkId 相邻的切片按 ChunkId 顺序合并为一个切片，
+// 合并后的切片位于其中排在最前的切片的位置，得分取最高分
+func MergeAdjacentStep() PostProcessStep {
+	return func(items []*CollectionSearchResponseItem) []*CollectionSearchResponseItem {
+		// 按文档分组，组内按 ChunkId 排序
+		byDoc := make(map[string][]int)
+		for i, item := range items {
+			byDoc[docKey(item)] = append(byDoc[docKey(item)], i)
+		}
+
+		merged := make(map[int]*CollectionSearchResponseItem) // 合并结果所在的位置 -> 合并后的切片
+		skipped := make(map[int]bool)
+		for _, indexes := range byDoc {
+			sort.Slice(indexes, func(i, j int) bool {
+				return items[indexes[i]].ChunkId < items[indexes[j]].ChunkId
+			})
+			for start := 0; start < len(indexes); {
+				end := start + 1
+				for end < len(indexes) && items[indexes[end]].ChunkId == items[indexes[end-1]].ChunkId+1 {
+					end++
+				}
+				if end-start > 1 {
+					run := indexes[start:end]
+					first := run[0]
+					for _, i := range run[1:] {
+						if i < first {
+							first = i
+						}
+					}
+					for _, i := range run {
+						if i != first {
+							skipped[i] = true
+						}
+					}
+					merged[first] = mergeChunks(items, run)
+				}
+				start = end
+			}
+		}
+
+		result := make([]*CollectionSearchResponseItem, 0, len(items)-len(skipped))
+		for i, item := range items {
+			if skipped[i] {
+				continue
+			}
+			if m, ok := merged[i]; ok {
+				item = m
+			}
+			result = append(result, item)
+		}
+		return result
+	}
+}
+
+// mergeChunks 合并按 ChunkId 排序的相邻切片，标题和文档信息取第一个切片
+func mergeChunks(items []*CollectionSearchResponseItem, run []int) *CollectionSearchResponseItem {
+	merged := *items[run[0]]
+	var contents, mdContents []string
+	merged.ChunkAttachmentList = nil
+	for _, i := range run {
+		item := items[i]
+		contents = append(contents, item.Content)
+		if item.MdContent != "" {
+			mdContents = append(mdContents, item.MdContent)
+		}
+		merged.ChunkAttachmentList = append(merged.ChunkAttachmentList, item.ChunkAttachmentList...)
+		if item.Score > merged.Score {
+			merged.Score = item.Score
+		}
+		if item.RerankScore > merged.RerankScore {
+			merged.RerankScore = item.RerankScore
+		}
+		if item.FusionScore > merged.FusionScore {
+			merged.FusionScore = item.FusionScore
+		}
+	}
+	merged.Content = strings.Join(contents, "\n")
+	merged.MdContent = strings.Join(mdContents, "\n")
+	return &merged
+}
+
+// TokenBudgetStep 按顺序保留切片，直到内容的估算token数达到 budget，超出预算的切片截断到剩余的预算
+func TokenBudgetStep(budget int) PostProcessStep {
+	return func(items []*CollectionSearchResponseItem) []*CollectionSearchResponseItem {
+		kept := make([]*CollectionSearchResponseItem, 0, len(items))
+		remaining := budget
+		for _, item := range items {
+			if remaining <= 0 {
+				break
+			}
+			tokens := EstimateTokens(item.Content)
+			if tokens <= remaining {
+				kept = append(kept, item)
+				remaining -= tokens
+				continue
+			}
+			truncated := *item
+			truncated.Content = truncateToTokens(item.Content, remaining)
+			truncated.MdContent = ""
+			kept = append(kept, &truncated)
+			break
+		}
+		return kept
+	}
+}
+
+// EstimateTokens 估算文本的token数：每个汉字等非拉丁字符约一个token，拉丁字母、数字和标点约四个字符一个token。
+// 只用于控制上下文长度，实际用量以模型返回的为准
+func EstimateTokens(text string) int {
+	tokens, latin := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			latin++
+		} else {
+			tokens++
+		}
+	}
+	return tokens + (latin+3)/4
+}
+
+// truncateToTokens 截取文本开头估算token数不超过 budget 的部分
+func truncateToTokens(text string, budget int) string {
+	tokens, latin := 0, 0
+	for i, r := range text {
+		if r < utf8.RuneSelf {
+			latin++
+		} else {
+			tokens++
+		}
+		if tokens+(latin+3)/4 > budget {
+			return text[:i]
+		}
+	}
+	return text
+}
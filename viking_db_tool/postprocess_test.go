@@ -0,0 +1,142 @@
+package viking_db_tool
+
+import (
+	"strings"
+	"testing"
+)
+
+func chunk(docID string, chunkID int, score float64, content string) *CollectionSearchResponseItem {
+	return &CollectionSearchResponseItem{
+		Content: content,
+		Score:   score,
+		ChunkId: chunkID,
+		DocInfo: CollectionSearchResponseItemDocInfo{Docid: docID},
+	}
+}
+
+func contents(items []*CollectionSearchResponseItem) []string {
+	var result []string
+	for _, item := range items {
+		result = append(result, item.Content)
+	}
+	return result
+}
+
+func TestMinScoreStep(t *testing.T) {
+	items := []*CollectionSearchResponseItem{
+		chunk("a", 1, 0.3, "low"),
+		chunk("a", 2, 0.9, "high"),
+		chunk("b", 1, 0.6, "mid"),
+	}
+	got := contents(MinScoreStep(0.5)(items))
+	if strings.Join(got, ",") != "high,mid" {
+		t.Fatalf("MinScoreStep() = %v", got)
+	}
+
+	// 开启重排时按重排得分排序
+	items[0].RerankScore = 0.95
+	if got := contents(MinScoreStep(0)(items)); got[0] != "low" {
+		t.Fatalf("MinScoreStep() with rerank = %v", got)
+	}
+}
+
+func TestDedupStep(t *testing.T) {
+	items := []*CollectionSearchResponseItem{
+		chunk("a", 1, 0.9, "退货需要在收到商品后七天内申请。"),
+		chunk("b", 4, 0.8, "退货需要在收到商品后 七天内申请"),
+		chunk("c", 2, 0.7, "Shipping takes three to five business days."),
+	}
+	got := contents(DedupStep(0.8)(items))
+	if len(got) != 2 || got[0] != items[0].Content || got[1] != items[2].Content {
+		t.Fatalf("DedupStep() = %v", got)
+	}
+}
+
+func TestMaxChunksPerDocStep(t *testing.T) {
+	items := []*CollectionSearchResponseItem{
+		chunk("a", 1, 0.9, "a1"),
+		chunk("a", 5, 0.8, "a5"),
+		chunk("b", 1, 0.7, "b1"),
+		chunk("a", 9, 0.6, "a9"),
+	}
+	got := contents(MaxChunksPerDocStep(2)(items))
+	if strings.Join(got, ",") != "a1,a5,b1" {
+		t.Fatalf("MaxChunksPerDocStep() = %v", got)
+	}
+}
+
+func TestMergeAdjacentStep(t *testing.T) {
+	items := []*CollectionSearchResponseItem{
+		chunk("a", 3, 0.9, "a3"),
+		chunk("b", 1, 0.8, "b1"),
+		chunk("a", 2, 0.7, "a2"),
+		chunk("a", 5, 0.6, "a5"),
+		chunk("a", 4, 0.5, "a4"),
+	}
+	got := MergeAdjacentStep()(items)
+	if strings.Join(contents(got), ",") != "a2\na3\na4\na5,b1" {
+		t.Fatalf("MergeAdjacentStep() = %q", contents(got))
+	}
+	if got[0].ChunkId != 2 || got[0].Score != 0.9 {
+		t.Errorf("merged chunk id = %d, score = %v", got[0].ChunkId, got[0].Score)
+	}
+	if items[0].Content != "a3" {
+		t.Error("input items should not be modified")
+	}
+}
+
+func TestTokenBudgetStep(t *testing.T) {
+	items := []*CollectionSearchResponseItem{
+		chunk("a", 1, 0.9, "一二三四五"),
+		chunk("a", 2, 0.8, "六七八九十"),
+		chunk("a", 3, 0.7, "never reached"),
+	}
+	got := contents(TokenBudgetStep(8)(items))
+	if strings.Join(got, ",") != "一二三四五,六七八" {
+		t.Fatalf("TokenBudgetStep() = %v", got)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	cases := map[string]int{
+		"":         0,
+		"abcd":     1,
+		"abcde":    2,
+		"你好":       2,
+		"你好 world": 4,
+	}
+	for text, want := range cases {
+		if got := EstimateTokens(text); got != want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestPostProcessResults(t *testing.T) {
+	resp := &CollectionSearchKnowledgeResponse{Data: &CollectionSearchKnowledgeResponseData{
+		Count:      2,
+		ResultList: []*CollectionSearchResponseItem{chunk("a", 1, 0.2, "x"), chunk("a", 2, 0.9, "y")},
+	}}
+	got := PostProcessResults(resp, MinScoreStep(0.5))
+	if got.Data.Count != 1 || got.Data.ResultList[0].Content != "y" {
+		t.Fatalf("PostProcessResults() = %+v", got.Data)
+	}
+	if len(resp.Data.ResultList) != 2 {
+		t.Error("original response should not be modified")
+	}
+}
+
+func TestPostProcessFederatedResults(t *testing.T) {
+	// 联合检索的结果按融合得分排序，不同知识库中相同的文档ID不视为同一文档
+	personal := chunk("doc", 1, 0.4, "personal")
+	personal.SourceCollection, personal.FusionScore = "kb_u1", 0.02
+	team := chunk("doc", 2, 0.9, "team")
+	team.SourceCollection, team.FusionScore = "kb_team", 0.01
+
+	items := MinScoreStep(0)([]*CollectionSearchResponseItem{team, personal})
+	items = MaxChunksPerDocStep(1)(items)
+	items = MergeAdjacentStep()(items)
+	if strings.Join(contents(items), ",") != "personal,team" {
+		t.Fatalf("post-processed federated results = %v", contents(items))
+	}
+}
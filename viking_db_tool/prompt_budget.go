@@ -0,0 +1,206 @@
+package viking_db_tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxAnswerTokens 是对话接口生成回答的最大token数，拼接提示词时为回答预留同样多的上下文
+var MaxAnswerTokens int32 = 4096
+
+// ModelContextWindows 是常用模型的上下文长度，不在表中的模型从名称中的 32k、256k 等推断，仍无法确定时使用 DefaultContextWindow
+var ModelContextWindows = map[string]int{
+	"Doubao-1-5-pro-32k":        32 * 1024,
+	"Doubao-1-5-pro-256k":       256 * 1024,
+	"Doubao-1-5-lite-32k":       32 * 1024,
+	"Doubao-1-5-vision-pro-32k": 32 * 1024,
+	"Doubao-pro-4k":             4 * 1024,
+	"Doubao-pro-32k":            32 * 1024,
+	"Doubao-pro-128k":           128 * 1024,
+}
+
+// DefaultContextWindow 是无法确定上下文长度的模型（如私有接入点 ep-xxxx）使用的上下文长度
+var DefaultContextWindow = 32 * 1024
+
+// EstimatedImageTokens 是每张图片按固定值估算的token数
+var EstimatedImageTokens = 1024
+
+// PromptSafetyPercent 是估算误差的余量，按上下文长度的百分比预留，token数只是估算值
+var PromptSafetyPercent = 10
+
+// minTruncatedChunkTokens 是截断切片时至少保留的内容token数，剩余预算不足时直接丢弃，避免只剩几个字的切片
+const minTruncatedChunkTokens = 50
+
+var contextWindowPattern = regexp.MustCompile(`(?i)(\d+)k\b`)
+
+// ContextWindow 返回模型的上下文长度
+func ContextWindow(model string) int {
+	if window, ok := ModelContextWindows[model]; ok {
+		return window
+	}
+	if m := contextWindowPattern.FindStringSubmatch(model); m != nil {
+		if k, err := strconv.Atoi(m[1]); err == nil && k > 0 {
+			return k * 1024
+		}
+	}
+	return DefaultContextWindow
+}
+
+// PromptBudget 描述拼接提示词时可以使用的上下文，零值表示不限制
+type PromptBudget struct {
+	ContextWindow  int // 模型的上下文长度
+	ReservedTokens int // 为用户问题、对话历史和回答预留的token数
+}
+
+// NewPromptBudget 按模型的上下文长度创建预算，为 messages（用户问题和对话历史）和回答预留上下文
+func NewPromptBudget(model string, messages []MessageParam) PromptBudget {
+	return PromptBudget{
+		ContextWindow:  ContextWindow(model),
+		ReservedTokens: int(MaxAnswerTokens) + EstimateMessagesTokens(messages),
+	}
+}
+
+// chunkBudget 返回可以用于检索切片的token数：扣除估算余量、预留部分和基础提示词之后剩余的上下文
+func (b PromptBudget) chunkBudget() int {
+	if b.ContextWindow <= 0 {
+		return math.MaxInt
+	}
+	available := b.ContextWindow*(100-PromptSafetyPercent)/100 - b.ReservedTokens -
+		EstimateTokens(strings.Replace(BasePrompt, "{prompt}", "", -1))
+	return max(available, 0)
+}
+
+// EstimateMessagesTokens 估算对话消息的token数，每条消息另加角色等格式开销
+func EstimateMessagesTokens(messages []MessageParam) int {
+	const messageOverhead = 4
+	tokens := 0
+	for _, message := range messages {
+		tokens += messageOverhead
+		switch content := message.Content.(type) {
+		case string:
+			tokens += EstimateTokens(content)
+		case []*ChatCompletionMessageContentPart:
+			for _, part := range content {
+				if part.Type == ChatCompletionMessageContentPartTypeImageURL {
+					tokens += EstimatedImageTokens
+				} else {
+					tokens += EstimateTokens(part.Text)
+				}
+			}
+		default:
+			data, _ := json.Marshal(content)
+			tokens += EstimateTokens(string(data))
+		}
+	}
+	return tokens
+}
+
+// PromptChunkStatus 是检索切片在提示词中的情况
+type PromptChunkStatus string
+
+const (
+	PromptChunkIncluded  PromptChunkStatus = "included"  // 完整放入提示词
+	PromptChunkTruncated PromptChunkStatus = "truncated" // 截断后放入提示词
+	PromptChunkDropped   PromptChunkStatus = "dropped"   // 超出预算，没有放入提示词
+)
+
+// PromptChunkReport 记录一个检索切片是否放入了提示词
+type PromptChunkReport struct {
+	Rank           int               `json:"rank"` // 在检索结果中的名次，从 1 开始
+	KnowledgeBase  string            `json:"knowledge_base,omitempty"`
+	DocID          string            `json:"doc_id"`
+	ChunkID        int               `json:"chunk_id"`
+	Status         PromptChunkStatus `json:"status"`
+	Tokens         int               `json:"tokens"`          // 放入提示词的估算token数
+	OriginalTokens int               `json:"original_tokens"` // 完整放入时的估算token数
+}
+
+// PromptReport 记录提示词的预算和各检索切片的使用情况，token数均为估算值
+type PromptReport struct {
+	ContextWindow  int                 `json:"context_window"`
+	ReservedTokens int                 `json:"reserved_tokens"`
+	ChunkBudget    int                 `json:"chunk_budget"` // 可以用于检索切片的token数
+	ChunkTokens    int                 `json:"chunk_tokens"` // 放入提示词的检索切片的token数
+	Chunks         []PromptChunkReport `json:"chunks"`
+}
+
+// GeneratePromptWithBudget 按名次把检索结果拼接为提示词，直到用完预算：放不下的第一个切片截断到剩余的预算，
+// 之后名次更低的切片全部丢弃。返回提示词、需要传给视觉模型的图片链接和各切片的使用情况
+func GeneratePromptWithBudget(resp *CollectionSearchKnowledgeResponse, budget PromptBudget) (string, []string, *PromptReport, error) {
+	if resp == nil {
+		return "", nil, nil, fmt.Errorf("response is nil")
+	}
+	if resp.Code != 0 {
+		return "", nil, nil, fmt.Errorf(resp.Message)
+	}
+
+	remaining := budget.chunkBudget()
+	report := &PromptReport{
+		ContextWindow:  budget.ContextWindow,
+		ReservedTokens: budget.ReservedTokens,
+		ChunkBudget:    remaining,
+		Chunks:         []PromptChunkReport{},
+	}
+
+	var promptBuilder strings.Builder
+	var imageURLs []string
+	usingVLM := isVisionModel(ModelName)
+	var results []*CollectionSearchResponseItem
+	if resp.Data != nil {
+		results = resp.Data.ResultList
+	}
+
+	for i, point := range results {
+		// 对vision模型需要额外处理图片链接
+		link, imageNum, imageTokens := "", 0, 0
+		if usingVLM && len(point.ChunkAttachmentList) > 0 && point.ChunkAttachmentList[0].Link != "" {
+			link, imageNum, imageTokens = point.ChunkAttachmentList[0].Link, len(imageURLs)+1, EstimatedImageTokens
+		}
+
+		text := renderPromptChunk(point, imageNum)
+		tokens := EstimateTokens(text) + imageTokens
+		chunkReport := PromptChunkReport{
+			Rank:           i + 1,
+			KnowledgeBase:  point.SourceCollection,
+			DocID:          point.DocInfo.Docid,
+			ChunkID:        point.ChunkId,
+			Status:         PromptChunkIncluded,
+			Tokens:         tokens,
+			OriginalTokens: tokens,
+		}
+
+		if tokens > remaining {
+			// 切片内容以外的字段和图片同样占用预算，只截断内容
+			contentBudget := remaining - (tokens - EstimateTokens(point.Content))
+			if contentBudget < minTruncatedChunkTokens {
+				chunkReport.Status, chunkReport.Tokens = PromptChunkDropped, 0
+				report.Chunks = append(report.Chunks, chunkReport)
+				remaining = 0
+				continue
+			}
+			truncated := *point
+			truncated.Content = truncateToTokens(point.Content, contentBudget)
+			text = renderPromptChunk(&truncated, imageNum)
+			chunkReport.Status, chunkReport.Tokens = PromptChunkTruncated, EstimateTokens(text)+imageTokens
+		}
+
+		promptBuilder.WriteString(text)
+		if link != "" {
+			imageURLs = append(imageURLs, link)
+		}
+		report.ChunkTokens += chunkReport.Tokens
+		report.Chunks = append(report.Chunks, chunkReport)
+		remaining -= chunkReport.Tokens
+		if chunkReport.Status == PromptChunkTruncated {
+			remaining = 0
+		}
+	}
+
+	// 基础提示词模板替换
+	finalPrompt := strings.Replace(BasePrompt, "{prompt}", promptBuilder.String(), -1)
+	return finalPrompt, imageURLs, report, nil
+}
@@ -0,0 +1,100 @@
+package viking_db_tool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContextWindow(t *testing.T) {
+	cases := map[string]int{
+		"Doubao-1-5-pro-32k":  32 * 1024,
+		"Doubao-1-5-pro-256k": 256 * 1024,
+		"my-model-8K":         8 * 1024,
+		"ep-20250101-abcd":    DefaultContextWindow,
+	}
+	for model, want := range cases {
+		if got := ContextWindow(model); got != want {
+			t.Errorf("ContextWindow(%q) = %d, want %d", model, got, want)
+		}
+	}
+}
+
+func TestEstimateMessagesTokens(t *testing.T) {
+	messages := []MessageParam{
+		{Role: "user", Content: "abcdabcd"},
+		{Role: "user", Content: []*ChatCompletionMessageContentPart{
+			{Type: ChatCompletionMessageContentPartTypeText, Text: "你好"},
+			{Type: ChatCompletionMessageContentPartTypeImageURL, ImageURL: &ChatMessageImageURL{URL: "https://example.com/a.png"}},
+		}},
+	}
+	if got, want := EstimateMessagesTokens(messages), 4+2+4+2+EstimatedImageTokens; got != want {
+		t.Fatalf("EstimateMessagesTokens() = %d, want %d", got, want)
+	}
+}
+
+func TestGeneratePromptWithBudget(t *testing.T) {
+	long := strings.Repeat("长", 400)
+	resp := &CollectionSearchKnowledgeResponse{Data: &CollectionSearchKnowledgeResponseData{
+		ResultList: []*CollectionSearchResponseItem{
+			chunk("a", 1, 0.9, "第一名的切片"),
+			chunk("b", 2, 0.8, long),
+			chunk("c", 3, 0.7, "名次最低的切片"),
+		},
+	}}
+	resp.Data.ResultList[1].SourceCollection = "kb_team"
+
+	// 预算足够第一个切片，第二个切片截断，第三个丢弃
+	base := EstimateTokens(strings.Replace(BasePrompt, "{prompt}", "", -1))
+	first := EstimateTokens(renderPromptChunk(resp.Data.ResultList[0], 0))
+	window := (base + first + 200) * 100 / (100 - PromptSafetyPercent)
+	prompt, _, report, err := GeneratePromptWithBudget(resp, PromptBudget{ContextWindow: window})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := []PromptChunkStatus{PromptChunkIncluded, PromptChunkTruncated, PromptChunkDropped}
+	for i, want := range statuses {
+		if got := report.Chunks[i].Status; got != want {
+			t.Errorf("chunk %d status = %s, want %s", i+1, got, want)
+		}
+	}
+	if report.Chunks[1].KnowledgeBase != "kb_team" || report.Chunks[1].Tokens >= report.Chunks[1].OriginalTokens {
+		t.Errorf("truncated chunk report = %+v", report.Chunks[1])
+	}
+	if report.ChunkTokens > report.ChunkBudget {
+		t.Errorf("chunk tokens %d exceed budget %d", report.ChunkTokens, report.ChunkBudget)
+	}
+	if !strings.Contains(prompt, "第一名的切片") || strings.Contains(prompt, long) || strings.Contains(prompt, "名次最低的切片") {
+		t.Errorf("unexpected prompt: %s", prompt)
+	}
+	if resp.Data.ResultList[1].Content != long {
+		t.Error("search results should not be modified")
+	}
+}
+
+func TestGeneratePromptWithBudgetDropsWhenFull(t *testing.T) {
+	resp := &CollectionSearchKnowledgeResponse{Data: &CollectionSearchKnowledgeResponseData{
+		ResultList: []*CollectionSearchResponseItem{chunk("a", 1, 0.9, "内容")},
+	}}
+	// 预留部分超过上下文长度时不放入任何切片
+	_, _, report, err := GeneratePromptWithBudget(resp, PromptBudget{ContextWindow: 1000, ReservedTokens: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ChunkBudget != 0 || report.Chunks[0].Status != PromptChunkDropped {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestGeneratePromptUnlimited(t *testing.T) {
+	resp := &CollectionSearchKnowledgeResponse{Data: &CollectionSearchKnowledgeResponseData{
+		ResultList: []*CollectionSearchResponseItem{chunk("a", 1, 0.9, strings.Repeat("长", 100000))},
+	}}
+	prompt, _, err := GeneratePrompt(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prompt, strings.Repeat("长", 100000)) {
+		t.Fatal("GeneratePrompt should not limit the prompt length")
+	}
+}
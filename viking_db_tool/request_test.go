@@ -0,0 +1,81 @@
+package viking_db_tool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoRequestConcurrencyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	SetMaxConcurrentRequests(1)
+	defer SetMaxConcurrentRequests(DefaultMaxConcurrentRequests)
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		return req
+	}
+
+	// 第一个响应未关闭时占用唯一的名额，第二个请求排队直到 ctx 超时
+	first, err := doRequest(context.Background(), newRequest(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := doRequest(ctx, newRequest(), time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second request error = %v, want deadline exceeded", err)
+	}
+
+	// 关闭响应体后释放名额，重复关闭不会多释放
+	first.Body.Close()
+	first.Body.Close()
+	second, err := doRequest(context.Background(), newRequest(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Body.Close()
+	if len(requestSlots) != 0 {
+		t.Errorf("%d slots still held", len(requestSlots))
+	}
+}
+
+func TestDoUnboundedRequestSkipsConcurrencyLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	SetMaxConcurrentRequests(1)
+	defer SetMaxConcurrentRequests(DefaultMaxConcurrentRequests)
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		return req
+	}
+
+	// 唯一的名额被占用时，健康检查的请求不排队
+	held, err := doRequest(context.Background(), newRequest(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := doUnboundedRequest(ctx, newRequest(), time.Second)
+	if err != nil {
+		t.Fatalf("unbounded request error = %v", err)
+	}
+	resp.Body.Close()
+	if len(requestSlots) != 1 {
+		t.Errorf("%d slots held, want 1", len(requestSlots))
+	}
+}